@@ -0,0 +1,285 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.25.1
+// source: acmes.proto
+
+package acmespb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Acmes_Obtain_FullMethodName = "/acmes.v1.Acmes/Obtain"
+	Acmes_Renew_FullMethodName  = "/acmes.v1.Acmes/Renew"
+	Acmes_Revoke_FullMethodName = "/acmes.v1.Acmes/Revoke"
+	Acmes_List_FullMethodName   = "/acmes.v1.Acmes/List"
+	Acmes_Watch_FullMethodName  = "/acmes.v1.Acmes/Watch"
+)
+
+// AcmesClient is the client API for Acmes service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AcmesClient interface {
+	Obtain(ctx context.Context, in *ObtainRequest, opts ...grpc.CallOption) (*Certificate, error)
+	Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Certificate, error)
+	Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*RevokeResponse, error)
+	List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Acmes_WatchClient, error)
+}
+
+type acmesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAcmesClient(cc grpc.ClientConnInterface) AcmesClient {
+	return &acmesClient{cc}
+}
+
+func (c *acmesClient) Obtain(ctx context.Context, in *ObtainRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, Acmes_Obtain_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *acmesClient) Renew(ctx context.Context, in *RenewRequest, opts ...grpc.CallOption) (*Certificate, error) {
+	out := new(Certificate)
+	err := c.cc.Invoke(ctx, Acmes_Renew_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *acmesClient) Revoke(ctx context.Context, in *RevokeRequest, opts ...grpc.CallOption) (*RevokeResponse, error) {
+	out := new(RevokeResponse)
+	err := c.cc.Invoke(ctx, Acmes_Revoke_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *acmesClient) List(ctx context.Context, in *ListRequest, opts ...grpc.CallOption) (*ListResponse, error) {
+	out := new(ListResponse)
+	err := c.cc.Invoke(ctx, Acmes_List_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *acmesClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Acmes_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Acmes_ServiceDesc.Streams[0], Acmes_Watch_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &acmesWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Acmes_WatchClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type acmesWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *acmesWatchClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AcmesServer is the server API for Acmes service.
+// All implementations must embed UnimplementedAcmesServer
+// for forward compatibility
+type AcmesServer interface {
+	Obtain(context.Context, *ObtainRequest) (*Certificate, error)
+	Renew(context.Context, *RenewRequest) (*Certificate, error)
+	Revoke(context.Context, *RevokeRequest) (*RevokeResponse, error)
+	List(context.Context, *ListRequest) (*ListResponse, error)
+	Watch(*WatchRequest, Acmes_WatchServer) error
+	mustEmbedUnimplementedAcmesServer()
+}
+
+// UnimplementedAcmesServer must be embedded to have forward compatible implementations.
+type UnimplementedAcmesServer struct {
+}
+
+func (UnimplementedAcmesServer) Obtain(context.Context, *ObtainRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Obtain not implemented")
+}
+func (UnimplementedAcmesServer) Renew(context.Context, *RenewRequest) (*Certificate, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
+func (UnimplementedAcmesServer) Revoke(context.Context, *RevokeRequest) (*RevokeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Revoke not implemented")
+}
+func (UnimplementedAcmesServer) List(context.Context, *ListRequest) (*ListResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedAcmesServer) Watch(*WatchRequest, Acmes_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedAcmesServer) mustEmbedUnimplementedAcmesServer() {}
+
+// UnsafeAcmesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AcmesServer will
+// result in compilation errors.
+type UnsafeAcmesServer interface {
+	mustEmbedUnimplementedAcmesServer()
+}
+
+func RegisterAcmesServer(s grpc.ServiceRegistrar, srv AcmesServer) {
+	s.RegisterService(&Acmes_ServiceDesc, srv)
+}
+
+func _Acmes_Obtain_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ObtainRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AcmesServer).Obtain(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Acmes_Obtain_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AcmesServer).Obtain(ctx, req.(*ObtainRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Acmes_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AcmesServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Acmes_Renew_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AcmesServer).Renew(ctx, req.(*RenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Acmes_Revoke_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RevokeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AcmesServer).Revoke(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Acmes_Revoke_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AcmesServer).Revoke(ctx, req.(*RevokeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Acmes_List_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AcmesServer).List(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Acmes_List_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AcmesServer).List(ctx, req.(*ListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Acmes_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AcmesServer).Watch(m, &acmesWatchServer{stream})
+}
+
+type Acmes_WatchServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type acmesWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *acmesWatchServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Acmes_ServiceDesc is the grpc.ServiceDesc for Acmes service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Acmes_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "acmes.v1.Acmes",
+	HandlerType: (*AcmesServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Obtain",
+			Handler:    _Acmes_Obtain_Handler,
+		},
+		{
+			MethodName: "Renew",
+			Handler:    _Acmes_Renew_Handler,
+		},
+		{
+			MethodName: "Revoke",
+			Handler:    _Acmes_Revoke_Handler,
+		},
+		{
+			MethodName: "List",
+			Handler:    _Acmes_List_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Acmes_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "acmes.proto",
+}
@@ -0,0 +1,4 @@
+// Package acmespb is generated from acmes.proto, run go generate after changing it, metadata.go is written by hand.
+package acmespb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative acmes.proto
@@ -0,0 +1,27 @@
+package acmespb
+
+import "google.golang.org/grpc/metadata"
+
+// MetadataCarrier carries the w3c trace context of calls in grpc metadata,
+// the client injects it into outgoing metadata and acmes extracts it from incoming metadata.
+type MetadataCarrier metadata.MD
+
+func (carrier MetadataCarrier) Get(key string) string {
+	values := metadata.MD(carrier).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (carrier MetadataCarrier) Set(key string, value string) {
+	metadata.MD(carrier).Set(key, value)
+}
+
+func (carrier MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(carrier))
+	for key := range carrier {
+		keys = append(keys, key)
+	}
+	return keys
+}
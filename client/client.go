@@ -6,8 +6,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aacfactory/afssl"
+	"go.opentelemetry.io/otel/propagation"
 	"io"
 	"net/http"
 	"net/url"
@@ -21,6 +23,25 @@ func New(caPEM []byte, caKeyPem []byte, host string) (v *Client, err error) {
 		err = fmt.Errorf("acmes: host is empty")
 		return
 	}
+	tlsConfig, tlsErr := createTLSConfig(caPEM, caKeyPem)
+	if tlsErr != nil {
+		err = tlsErr
+		return
+	}
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	v = &Client{
+		host:       host,
+		httpClient: httpClient,
+	}
+	return
+}
+
+// createTLSConfig generates a client cert signed by the ca, acmes identifies clients by it.
+func createTLSConfig(caPEM []byte, caKeyPem []byte) (tlsConfig *tls.Config, err error) {
 	config := afssl.CertificateConfig{}
 	cert, key, genSslErr := afssl.GenerateCertificate(config, afssl.WithExpirationDays(365), afssl.WithParent(caPEM, caKeyPem))
 	if genSslErr != nil {
@@ -37,29 +58,66 @@ func New(caPEM []byte, caKeyPem []byte, host string) (v *Client, err error) {
 		err = fmt.Errorf("acmes: generate client cert failed, %v", certificateErr)
 		return
 	}
-	tlsConfig := &tls.Config{
+	tlsConfig = &tls.Config{
 		RootCAs:            roots,
 		Certificates:       []tls.Certificate{certificate},
 		InsecureSkipVerify: true,
 	}
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: tlsConfig,
-		},
-	}
-	v = &Client{
-		host:       host,
-		httpClient: httpClient,
-	}
 	return
 }
 
 type Client struct {
-	host       string
-	httpClient *http.Client
+	host           string
+	httpClient     *http.Client
+	account        string
+	preferredChain string
+	mustStaple     bool
+}
+
+// WithAccount returns a client whose certificates are ordered by the named acme account of acmes,
+// acmes picks the account by the domain when no account is named.
+func (c *Client) WithAccount(name string) *Client {
+	v := *c
+	v.account = strings.TrimSpace(name)
+	return &v
+}
+
+// WithPreferredChain returns a client whose orders pick the chain of the root or intermediate named name when the ca offers it,
+// such as ISRG Root X1, the preferred chain of the account is used when it is empty.
+func (c *Client) WithPreferredChain(name string) *Client {
+	v := *c
+	v.preferredChain = strings.TrimSpace(name)
+	return &v
+}
+
+// WithMustStaple returns a client whose orders add the ocsp must staple extension when mustStaple is true,
+// the tls config which it returns staples the ocsp response either way.
+func (c *Client) WithMustStaple(mustStaple bool) *Client {
+	v := *c
+	v.mustStaple = mustStaple
+	return &v
 }
 
 func (c *Client) Obtain(ctx context.Context, domain string) (config *tls.Config, cancelAutoRenew func(), err error) {
+	cert, obtainErr := c.ObtainCertificate(ctx, domain)
+	if obtainErr != nil {
+		err = obtainErr
+		return
+	}
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	config, cancelAutoRenew, err = c.configure(ctx, strings.TrimSpace(domain), cert)
+	if err != nil {
+		err = fmt.Errorf("acmes: obtain failed, %v", err)
+		return
+	}
+	return
+}
+
+// ObtainCertificate obtains the certificate of domain like Obtain, but returns it as it is and does not renew it,
+// such as for writing it into files.
+func (c *Client) ObtainCertificate(ctx context.Context, domain string) (cert *Certificate, err error) {
 	domain = strings.TrimSpace(domain)
 	if domain == "" {
 		err = fmt.Errorf("acmes: obtain failed for domain is empty")
@@ -68,108 +126,286 @@ func (c *Client) Obtain(ctx context.Context, domain string) (config *tls.Config,
 	if ctx == nil {
 		ctx = context.TODO()
 	}
-	u := url.URL{}
-	u.Scheme = "https"
-	u.Host = c.host
-	u.Path = "/obtain"
-	resp, postErr := c.httpClient.Post(u.String(), "application/acme", bytes.NewReader([]byte(fmt.Sprintf("{\"domain\":\"%s\"}", domain))))
+	resp, postErr := c.post(ctx, "/v1/certificates", c.param(domain))
 	if postErr != nil {
 		err = fmt.Errorf("acmes: obtain failed, %v", postErr)
 		return
 	}
-	defer resp.Body.Close()
-	body, bodyErr := io.ReadAll(resp.Body)
-	if bodyErr != nil {
-		err = fmt.Errorf("acmes: obtain failed, %v", bodyErr)
+	cert = &Certificate{}
+	decodeErr := decodeResponse(resp, cert)
+	if decodeErr != nil {
+		cert = nil
+		err = fmt.Errorf("acmes: obtain failed, %w", decodeErr)
 		return
 	}
-	if resp.StatusCode != 200 {
-		handleErr := &HandleError{}
-		decodeErr := json.Unmarshal(body, handleErr)
-		if decodeErr != nil {
-			err = fmt.Errorf("acmes: obtain failed, %v", decodeErr)
-			return
-		}
-		err = fmt.Errorf("acmes: obtain failed, %v", handleErr.Cause)
+	return
+}
+
+// RenewCertificate renews the certificate of domain when it expired, otherwise acmes returns the stored one.
+func (c *Client) RenewCertificate(ctx context.Context, domain string) (cert *Certificate, err error) {
+	domain = strings.TrimSpace(domain)
+	if domain == "" {
+		err = fmt.Errorf("acmes: renew failed for domain is empty")
 		return
 	}
-	cert := &Certificate{}
-	decodeErr := json.Unmarshal(body, cert)
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	resp, postErr := c.post(ctx, certificatePath(domain)+"/renew", c.param(domain))
+	if postErr != nil {
+		err = fmt.Errorf("acmes: renew failed, %v", postErr)
+		return
+	}
+	cert = &Certificate{}
+	decodeErr := decodeResponse(resp, cert)
 	if decodeErr != nil {
-		err = fmt.Errorf("acmes: obtain failed, %v", decodeErr)
+		cert = nil
+		err = fmt.Errorf("acmes: renew failed, %w", decodeErr)
 		return
 	}
+	return
+}
+
+// configure creates tls config of cert which staples its ocsp response, and renews it automatically.
+func (c *Client) configure(ctx context.Context, domain string, cert *Certificate) (config *tls.Config, cancelAutoRenew func(), err error) {
 	certificate, certificateErr := tls.X509KeyPair(cert.Cert, cert.Key)
 	if certificateErr != nil {
-		err = fmt.Errorf("acmes: obtain failed, %v", certificateErr)
+		err = certificateErr
 		return
 	}
+	certificate.OCSPStaple = cert.OCSP
 	config = &tls.Config{
 		Certificates: []tls.Certificate{certificate},
 	}
-	cancelAutoRenew, err = c.autoRenew(ctx, domain, config, cert.NotAfter)
+	cancelAutoRenew, err = c.autoRenew(ctx, domain, config, cert.NotAfter, stapleAt(cert))
 	return
 }
 
-func (c *Client) autoRenew(ctx context.Context, domain string, config *tls.Config, notAfter time.Time) (cancelAutoRenew func(), err error) {
+// autoRenew renews the certificate when it expires, and refreshes it at once when acmes pushes an event of domain,
+// a revoked certificate is replaced by a newly obtained one.
+// When events can not be watched, it falls back to polling at the expiration, and watches again later.
+// The ocsp staple is refreshed at stapleAt as well, acmes has fetched a newer one by then.
+func (c *Client) autoRenew(ctx context.Context, domain string, config *tls.Config, notAfter time.Time, stapleAt time.Time) (cancelAutoRenew func(), err error) {
 	ctx, cancelAutoRenew = context.WithCancel(ctx)
-	go func(ctx context.Context, domain string, config *tls.Config, c *Client, notAfter time.Time) {
+	go func(ctx context.Context, domain string, config *tls.Config, c *Client, notAfter time.Time, stapleAt time.Time) {
+		var events <-chan *Event
+		var retry <-chan time.Time
 		for {
-			closed := false
+			if events == nil && retry == nil {
+				watched, watchErr := c.Watch(ctx, domain)
+				if watchErr != nil {
+					retry = time.After(watchRetryInterval)
+				} else {
+					events = watched
+				}
+			}
+			renewTimer := time.NewTimer(time.Until(notAfter))
+			var stapleTimer *time.Timer
+			var staple <-chan time.Time
+			if !stapleAt.IsZero() {
+				stapleTimer = time.NewTimer(time.Until(stapleAt))
+				staple = stapleTimer.C
+			}
 			select {
 			case <-ctx.Done():
-				closed = true
-				break
-			case <-time.After(notAfter.Sub(time.Now())):
-				notAfter = c.renew(domain, config)
-				break
+			case <-renewTimer.C:
+				notAfter, stapleAt = c.refresh(ctx, refreshRenew, domain, config)
+			case <-staple:
+				notAfter, stapleAt = c.refresh(ctx, refreshGet, domain, config)
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					retry = time.After(watchRetryInterval)
+					break
+				}
+				if event.Domain == domain {
+					notAfter, stapleAt = c.refresh(ctx, refreshOf(event), domain, config)
+				}
+			case <-retry:
+				retry = nil
 			}
-			if closed {
-				break
+			renewTimer.Stop()
+			if stapleTimer != nil {
+				stapleTimer.Stop()
+			}
+			if ctx.Err() != nil {
+				return
 			}
 		}
-	}(ctx, domain, config, c, notAfter)
+	}(ctx, domain, config, c, notAfter, stapleAt)
 	return
 }
 
-func (c *Client) renew(domain string, config *tls.Config) (notAfter time.Time) {
+// stapleRefreshBefore is how long before the next update of the ocsp response the staple is refreshed.
+const stapleRefreshBefore = 24 * time.Hour
+
+// stapleAt returns when the staple of cert is refreshed, it is zero when cert has none.
+func stapleAt(cert *Certificate) (at time.Time) {
+	if len(cert.OCSP) == 0 || cert.OCSPNextUpdate.IsZero() {
+		return
+	}
+	at = cert.OCSPNextUpdate.Add(-stapleRefreshBefore)
+	if earliest := time.Now().Add(time.Hour); at.Before(earliest) {
+		at = earliest
+	}
+	return
+}
+
+type requestParam struct {
+	Domain         string `json:"domain"`
+	Async          bool   `json:"async,omitempty"`
+	Account        string `json:"account,omitempty"`
+	PreferredChain string `json:"preferredChain,omitempty"`
+	MustStaple     bool   `json:"mustStaple,omitempty"`
+}
+
+func (c *Client) param(domain string) requestParam {
+	return requestParam{
+		Domain:         domain,
+		Account:        c.account,
+		PreferredChain: c.preferredChain,
+		MustStaple:     c.mustStaple,
+	}
+}
+
+// post sends the param to the path of acmes, and propagates the w3c trace context of ctx.
+func (c *Client) post(ctx context.Context, path string, param requestParam) (resp *http.Response, err error) {
+	body, encodeErr := json.Marshal(param)
+	if encodeErr != nil {
+		err = encodeErr
+		return
+	}
 	u := url.URL{}
 	u.Scheme = "https"
 	u.Host = c.host
-	u.Path = "/renew"
-	resp, postErr := c.httpClient.Post(u.String(), "application/acme", bytes.NewReader([]byte(fmt.Sprintf("{\"domain\":\"%s\"}", domain))))
-	if postErr != nil {
-		notAfter = time.Now().Add(60 * time.Second)
+	u.Path = path
+	u.RawQuery = c.query(nil).Encode()
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if requestErr != nil {
+		err = requestErr
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	resp, err = c.httpClient.Do(request)
+	return
+}
+
+// get reads the path of acmes with query which may be nil, and propagates the w3c trace context of ctx.
+func (c *Client) get(ctx context.Context, path string, query url.Values) (resp *http.Response, err error) {
+	u := url.URL{}
+	u.Scheme = "https"
+	u.Host = c.host
+	u.Path = path
+	u.RawQuery = c.query(query).Encode()
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if requestErr != nil {
+		err = requestErr
 		return
 	}
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(request.Header))
+	resp, err = c.httpClient.Do(request)
+	return
+}
+
+// query adds the account, preferred chain and must staple of c into query, routes of a domain take them in the query.
+func (c *Client) query(query url.Values) url.Values {
+	if c.account == "" && c.preferredChain == "" && !c.mustStaple {
+		return query
+	}
+	if query == nil {
+		query = url.Values{}
+	}
+	if c.account != "" {
+		query.Set("account", c.account)
+	}
+	if c.preferredChain != "" {
+		query.Set("preferredChain", c.preferredChain)
+	}
+	if c.mustStaple {
+		query.Set("mustStaple", "true")
+	}
+	return query
+}
+
+// decodeResponse decodes the body of resp into v when it succeeded, otherwise returns the *HandleError in body.
+func decodeResponse(resp *http.Response, v interface{}) (err error) {
 	defer resp.Body.Close()
 	body, bodyErr := io.ReadAll(resp.Body)
 	if bodyErr != nil {
-		notAfter = time.Now().Add(60 * time.Second)
+		err = bodyErr
 		return
 	}
-	if resp.StatusCode != 200 {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		handleErr := &HandleError{}
 		decodeErr := json.Unmarshal(body, handleErr)
-		if decodeErr != nil {
-			notAfter = time.Now().Add(60 * time.Second)
+		if decodeErr != nil || handleErr.Cause == "" {
+			err = fmt.Errorf("%s", resp.Status)
 			return
 		}
-		notAfter = time.Now().Add(60 * time.Second)
+		err = handleErr
+		return
+	}
+	err = json.Unmarshal(body, v)
+	return
+}
+
+type refreshMode int
+
+const (
+	// refreshGet gets the stored certificate.
+	refreshGet refreshMode = iota
+	// refreshRenew renews the certificate when it expired.
+	refreshRenew
+	// refreshObtain obtains a new certificate, acmes has none after it was revoked.
+	refreshObtain
+)
+
+// refreshOf returns how the certificate is refreshed after event, a revoked one is replaced by a new one.
+func refreshOf(event *Event) refreshMode {
+	if event.Kind == EventRevoked {
+		return refreshObtain
+	}
+	return refreshGet
+}
+
+// refresh gets, renews or obtains the certificate of domain by mode, then swaps the certificate and staple of config.
+// When it failed, it is tried again after a minute, or after the time acmes asks for when it is rate limited.
+func (c *Client) refresh(ctx context.Context, mode refreshMode, domain string, config *tls.Config) (notAfter time.Time, staple time.Time) {
+	notAfter = time.Now().Add(60 * time.Second)
+	var resp *http.Response
+	var err error
+	switch mode {
+	case refreshRenew:
+		resp, err = c.post(ctx, certificatePath(domain)+"/renew", c.param(domain))
+	case refreshObtain:
+		resp, err = c.post(ctx, "/v1/certificates", c.param(domain))
+	default:
+		resp, err = c.get(ctx, certificatePath(domain), nil)
+	}
+	if err != nil {
 		return
 	}
 	cert := &Certificate{}
-	decodeErr := json.Unmarshal(body, cert)
+	decodeErr := decodeResponse(resp, cert)
 	if decodeErr != nil {
-		notAfter = time.Now().Add(60 * time.Second)
+		handleErr := &HandleError{}
+		if errors.As(decodeErr, &handleErr) && handleErr.RetryAfter > 60 {
+			notAfter = time.Now().Add(time.Duration(handleErr.RetryAfter) * time.Second)
+		}
 		return
 	}
 	certificate, certificateErr := tls.X509KeyPair(cert.Cert, cert.Key)
 	if certificateErr != nil {
-		notAfter = time.Now().Add(60 * time.Second)
 		return
 	}
+	certificate.OCSPStaple = cert.OCSP
 	config.Certificates[0] = certificate
 	notAfter = cert.NotAfter
+	staple = stapleAt(cert)
 	return
 }
+
+func certificatePath(domain string) string {
+	return "/v1/certificates/" + domain
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	EventUpdated = "updated"
+	EventRevoked = "revoked"
+)
+
+// watchRetryInterval is how long to wait before subscribing again after the subscription was broken.
+const watchRetryInterval = 30 * time.Second
+
+type Event struct {
+	Kind     string    `json:"kind"`
+	Domain   string    `json:"domain"`
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Watch subscribes certificate events of domains, the channel is closed when ctx is done or the subscription is broken.
+func (c *Client) Watch(ctx context.Context, domains ...string) (events <-chan *Event, err error) {
+	if len(domains) == 0 {
+		err = fmt.Errorf("acmes: watch failed for domains are empty")
+		return
+	}
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	query := url.Values{}
+	for _, domain := range domains {
+		query.Add("domain", strings.TrimSpace(domain))
+	}
+	resp, getErr := c.get(ctx, "/v1/events", query)
+	if getErr != nil {
+		err = fmt.Errorf("acmes: watch failed, %v", getErr)
+		return
+	}
+	if resp.StatusCode != 200 {
+		err = fmt.Errorf("acmes: watch failed, %w", decodeResponse(resp, nil))
+		return
+	}
+	ch := make(chan *Event, 8)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+		scanner := bufio.NewScanner(resp.Body)
+		data := make([]string, 0, 1)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(line, "data:") {
+				data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+				continue
+			}
+			if line != "" || len(data) == 0 {
+				// comments, such as heartbeats, and event names are skipped, the kind is in data.
+				continue
+			}
+			event := &Event{}
+			decodeErr := json.Unmarshal([]byte(strings.Join(data, "\n")), event)
+			data = data[:0]
+			if decodeErr != nil {
+				continue
+			}
+			select {
+			case ch <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	events = ch
+	return
+}
@@ -2,9 +2,19 @@ module github.com/aacfactory/acmes/client
 
 go 1.21.6
 
-require github.com/aacfactory/afssl v1.12.0
+require (
+	github.com/aacfactory/afssl v1.12.0
+	go.opentelemetry.io/otel v1.21.0
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20230822172742-b8732ec3820d
+	google.golang.org/grpc v1.59.0
+	google.golang.org/protobuf v1.31.0
+)
 
 require (
+	github.com/golang/protobuf v1.5.3 // indirect
+	go.opentelemetry.io/otel/trace v1.21.0 // indirect
 	golang.org/x/crypto v0.17.0 // indirect
+	golang.org/x/net v0.14.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"github.com/aacfactory/acmes/client/acmespb"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+// GRPCClient calls acmes over grpc, it is for services which would rather not carry a http client.
+// Failures wrap *HandleError like Client.
+type GRPCClient struct {
+	conn           *grpc.ClientConn
+	raw            acmespb.AcmesClient
+	account        string
+	preferredChain string
+	mustStaple     bool
+}
+
+func NewGRPC(caPEM []byte, caKeyPem []byte, host string) (v *GRPCClient, err error) {
+	host = strings.TrimSpace(host)
+	if host == "" {
+		err = fmt.Errorf("acmes: host is empty")
+		return
+	}
+	tlsConfig, tlsErr := createTLSConfig(caPEM, caKeyPem)
+	if tlsErr != nil {
+		err = tlsErr
+		return
+	}
+	conn, dialErr := grpc.Dial(host, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if dialErr != nil {
+		err = fmt.Errorf("acmes: dial %s failed, %v", host, dialErr)
+		return
+	}
+	v = &GRPCClient{
+		conn: conn,
+		raw:  acmespb.NewAcmesClient(conn),
+	}
+	return
+}
+
+// WithAccount returns a client whose certificates are ordered by the named acme account of acmes,
+// it shares the connection of c, so only c needs to be closed.
+func (c *GRPCClient) WithAccount(name string) *GRPCClient {
+	v := *c
+	v.account = strings.TrimSpace(name)
+	return &v
+}
+
+// WithPreferredChain returns a client whose orders pick the chain of the root or intermediate named name when the ca offers it,
+// it shares the connection of c.
+func (c *GRPCClient) WithPreferredChain(name string) *GRPCClient {
+	v := *c
+	v.preferredChain = strings.TrimSpace(name)
+	return &v
+}
+
+// WithMustStaple returns a client whose orders add the ocsp must staple extension when mustStaple is true,
+// it shares the connection of c.
+func (c *GRPCClient) WithMustStaple(mustStaple bool) *GRPCClient {
+	v := *c
+	v.mustStaple = mustStaple
+	return &v
+}
+
+// Raw returns the generated client.
+func (c *GRPCClient) Raw() acmespb.AcmesClient {
+	return c.raw
+}
+
+func (c *GRPCClient) Obtain(ctx context.Context, domain string) (cert *Certificate, err error) {
+	resp, obtainErr := c.raw.Obtain(outgoing(ctx), &acmespb.ObtainRequest{Domain: strings.TrimSpace(domain), Account: c.account, PreferredChain: c.preferredChain, MustStaple: c.mustStaple})
+	if obtainErr != nil {
+		err = fmt.Errorf("acmes: obtain failed, %w", handleErrorOf(obtainErr))
+		return
+	}
+	cert = certificateOf(resp)
+	return
+}
+
+func (c *GRPCClient) Renew(ctx context.Context, domain string) (cert *Certificate, err error) {
+	resp, renewErr := c.raw.Renew(outgoing(ctx), &acmespb.RenewRequest{Domain: strings.TrimSpace(domain), Account: c.account, PreferredChain: c.preferredChain, MustStaple: c.mustStaple})
+	if renewErr != nil {
+		err = fmt.Errorf("acmes: renew failed, %w", handleErrorOf(renewErr))
+		return
+	}
+	cert = certificateOf(resp)
+	return
+}
+
+// Revoke revokes the certificate of domain, reason is the crl reason code of rfc 5280.
+func (c *GRPCClient) Revoke(ctx context.Context, domain string, reason uint) (err error) {
+	_, revokeErr := c.raw.Revoke(outgoing(ctx), &acmespb.RevokeRequest{Domain: strings.TrimSpace(domain), Reason: uint32(reason), Account: c.account})
+	if revokeErr != nil {
+		err = fmt.Errorf("acmes: revoke failed, %w", handleErrorOf(revokeErr))
+		return
+	}
+	return
+}
+
+func (c *GRPCClient) List(ctx context.Context) (certificates []CertificateSummary, err error) {
+	resp, listErr := c.raw.List(outgoing(ctx), &acmespb.ListRequest{Account: c.account})
+	if listErr != nil {
+		err = fmt.Errorf("acmes: list failed, %w", handleErrorOf(listErr))
+		return
+	}
+	certificates = make([]CertificateSummary, 0, len(resp.Certificates))
+	for _, summary := range resp.Certificates {
+		certificates = append(certificates, CertificateSummary{
+			Account:  summary.Account,
+			Domain:   summary.Domain,
+			NotAfter: summary.NotAfter.AsTime().Local(),
+		})
+	}
+	return
+}
+
+// Watch subscribes certificate events of domains, the channel is closed when ctx is done or the stream is broken.
+func (c *GRPCClient) Watch(ctx context.Context, domains ...string) (events <-chan *Event, err error) {
+	stream, watchErr := c.raw.Watch(outgoing(ctx), &acmespb.WatchRequest{Domains: domains})
+	if watchErr != nil {
+		err = fmt.Errorf("acmes: watch failed, %w", handleErrorOf(watchErr))
+		return
+	}
+	ch := make(chan *Event, 8)
+	go func() {
+		defer close(ch)
+		for {
+			event, recvErr := stream.Recv()
+			if recvErr != nil {
+				return
+			}
+			select {
+			case ch <- &Event{
+				Kind:     event.Kind,
+				Domain:   event.Domain,
+				NotAfter: event.NotAfter.AsTime().Local(),
+				Time:     event.Time.AsTime().Local(),
+			}:
+			case <-stream.Context().Done():
+				return
+			}
+		}
+	}()
+	events = ch
+	return
+}
+
+func (c *GRPCClient) Close() (err error) {
+	err = c.conn.Close()
+	return
+}
+
+// outgoing propagates the w3c trace context of ctx by grpc metadata.
+func outgoing(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	propagation.TraceContext{}.Inject(ctx, acmespb.MetadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+var grpcErrorCodes = map[codes.Code]string{
+	codes.InvalidArgument:    ErrorInvalidRequest,
+	codes.ResourceExhausted:  ErrorRateLimited,
+	codes.FailedPrecondition: ErrorChallengeFailed,
+	codes.NotFound:           ErrorNotFound,
+	codes.PermissionDenied:   ErrorForbidden,
+	codes.Unavailable:        ErrorUnavailable,
+	codes.DataLoss:           ErrorInvalidIssued,
+}
+
+// handleErrorOf converts the grpc status to *HandleError, the code is the reason of its error info.
+func handleErrorOf(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	handleErr := &HandleError{
+		Code:  ErrorInternal,
+		Cause: st.Message(),
+	}
+	if code, has := grpcErrorCodes[st.Code()]; has {
+		handleErr.Code = code
+	}
+	for _, detail := range st.Details() {
+		switch v := detail.(type) {
+		case *errdetails.ErrorInfo:
+			if v.Domain == "acmes" {
+				handleErr.Code = v.Reason
+			}
+		case *errdetails.RetryInfo:
+			handleErr.RetryAfter = int(v.RetryDelay.AsDuration().Seconds())
+		}
+	}
+	return handleErr
+}
+
+func certificateOf(v *acmespb.Certificate) *Certificate {
+	cert := &Certificate{
+		Resource:      v.Resource,
+		Cert:          v.Cert,
+		Leaf:          v.Leaf,
+		Intermediates: v.Intermediates,
+		Chain:         v.Chain,
+		Key:           v.Key,
+		NotAfter:      v.NotAfter.AsTime().Local(),
+		OCSP:          v.Ocsp,
+	}
+	if v.OcspNextUpdate != nil {
+		cert.OCSPNextUpdate = v.OcspNextUpdate.AsTime().Local()
+	}
+	return cert
+}
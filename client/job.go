@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Submit asks acmes to obtain the certificate of domain in background, and returns the id of the job at once.
+func (c *Client) Submit(ctx context.Context, domain string) (id string, err error) {
+	domain = strings.TrimSpace(domain)
+	if domain == "" {
+		err = fmt.Errorf("acmes: submit failed for domain is empty")
+		return
+	}
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	param := c.param(domain)
+	param.Async = true
+	resp, postErr := c.post(ctx, "/v1/certificates", param)
+	if postErr != nil {
+		err = fmt.Errorf("acmes: submit failed, %v", postErr)
+		return
+	}
+	job := &Job{}
+	decodeErr := decodeResponse(resp, job)
+	if decodeErr != nil {
+		err = fmt.Errorf("acmes: submit failed, %w", decodeErr)
+		return
+	}
+	id = job.Id
+	return
+}
+
+// Job returns the state of the job, the certificate is present when the job is issued.
+func (c *Client) Job(ctx context.Context, id string) (job *Job, err error) {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		err = fmt.Errorf("acmes: get job failed for id is empty")
+		return
+	}
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	resp, getErr := c.get(ctx, "/v1/jobs/"+id, nil)
+	if getErr != nil {
+		err = fmt.Errorf("acmes: get job failed, %v", getErr)
+		return
+	}
+	job = &Job{}
+	err = decodeResponse(resp, job)
+	if err != nil {
+		job = nil
+		err = fmt.Errorf("acmes: get job failed, %w", err)
+		return
+	}
+	return
+}
+
+// Wait polls the job every interval until it is finished or ctx is done,
+// then returns the tls config of the issued certificate like Obtain.
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration) (config *tls.Config, cancelAutoRenew func(), err error) {
+	if ctx == nil {
+		ctx = context.TODO()
+	}
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	for {
+		job, jobErr := c.Job(ctx, id)
+		if jobErr != nil {
+			err = jobErr
+			return
+		}
+		switch job.State {
+		case JobIssued:
+			if job.Certificate == nil {
+				err = fmt.Errorf("acmes: wait job %s failed for certificate is absent", id)
+				return
+			}
+			config, cancelAutoRenew, err = c.configure(ctx, job.Domain, job.Certificate)
+			if err != nil {
+				err = fmt.Errorf("acmes: wait job %s failed, %v", id, err)
+				return
+			}
+			return
+		case JobFailed:
+			err = fmt.Errorf("acmes: job %s failed, %w", id, &HandleError{Code: job.Code, Cause: job.Cause})
+			return
+		}
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("acmes: wait job %s failed, %v", id, ctx.Err())
+			return
+		case <-time.After(interval):
+		}
+	}
+}
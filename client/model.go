@@ -2,13 +2,71 @@ package client
 
 import "time"
 
+const (
+	ErrorInvalidRequest  = "invalid_request"
+	ErrorInvalidDomain   = "invalid_domain"
+	ErrorRateLimited     = "rate_limited"
+	ErrorChallengeFailed = "challenge_failed"
+	ErrorPreflightFailed = "preflight_failed"
+	ErrorInvalidIssued   = "invalid_certificate"
+	ErrorNotFound        = "not_found"
+	ErrorForbidden       = "forbidden"
+	ErrorUnavailable     = "unavailable"
+	ErrorInternal        = "internal"
+)
+
+// HandleError is returned by acmes when a request failed, use errors.As to read the code.
 type HandleError struct {
+	Code  string `json:"code"`
 	Cause string `json:"cause"`
+	// RetryAfter is the seconds to wait before trying again when it is rate limited.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func (e *HandleError) Error() string {
+	return e.Cause
 }
 
 type Certificate struct {
-	Resource []byte    `json:"resource"`
-	Cert     []byte    `json:"cert"`
+	Resource []byte `json:"resource"`
+	// Cert is the full chain, it equals Chain.
+	Cert []byte `json:"cert"`
+	// Leaf is the certificate of the domain.
+	Leaf []byte `json:"leaf"`
+	// Intermediates are the issuers of Leaf in the chain, without the root.
+	Intermediates []byte `json:"intermediates"`
+	// Chain is Leaf followed by Intermediates.
+	Chain    []byte    `json:"chain"`
 	Key      []byte    `json:"key"`
 	NotAfter time.Time `json:"notAfter"`
+	// OCSP is the der ocsp response of Leaf, Client staples it, it is empty when the ca has no ocsp responder.
+	OCSP []byte `json:"ocsp,omitempty"`
+	// OCSPNextUpdate is when OCSP is stale, acmes refreshes it before then, it is zero when OCSP is empty.
+	OCSPNextUpdate time.Time `json:"ocspNextUpdate"`
+}
+
+type CertificateSummary struct {
+	Account  string    `json:"account"`
+	Domain   string    `json:"domain"`
+	NotAfter time.Time `json:"notAfter"`
+}
+
+const (
+	JobPending    = "pending"
+	JobValidating = "validating"
+	JobIssued     = "issued"
+	JobFailed     = "failed"
+)
+
+type Job struct {
+	Id          string       `json:"id"`
+	Domain      string       `json:"domain"`
+	State       string       `json:"state"`
+	Cause       string       `json:"cause,omitempty"`
+	Code        string       `json:"code,omitempty"`
+	Certificate *Certificate `json:"certificate,omitempty"`
+}
+
+func (job *Job) Finished() bool {
+	return job.State == JobIssued || job.State == JobFailed
 }
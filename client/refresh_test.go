@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, domain string, notAfter time.Time) *Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, createErr := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		DNSNames:     []string{domain},
+	}, &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}}, &key.PublicKey, key)
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return &Certificate{
+		Cert:     certPEM,
+		Leaf:     certPEM,
+		Chain:    certPEM,
+		Key:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		NotAfter: notAfter,
+	}
+}
+
+func TestClient_refresh(t *testing.T) {
+	domain := "www.foo.com"
+	revoked := testCertificate(t, domain, time.Now().AddDate(0, 3, 0))
+	obtained := testCertificate(t, domain, time.Now().AddDate(0, 3, 1).Truncate(time.Second))
+	// acmes has no certificate of domain after it was revoked, until it is obtained again
+	server := httptest.NewTLSServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodPost && request.URL.Path == "/v1/certificates" {
+			param := requestParam{}
+			if err := json.NewDecoder(request.Body).Decode(&param); err != nil || param.Domain != domain {
+				writer.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(writer).Encode(obtained)
+			return
+		}
+		writer.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(writer).Encode(&HandleError{Code: ErrorNotFound, Cause: "not found"})
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	c := &Client{
+		host:       u.Host,
+		httpClient: server.Client(),
+	}
+	certificate, _ := tls.X509KeyPair(revoked.Cert, revoked.Key)
+	config := &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	mode := refreshOf(&Event{Kind: EventRevoked, Domain: domain})
+	if mode != refreshObtain {
+		t.Fatalf("refresh of revoked event is %d, not obtain", mode)
+	}
+	if refreshOf(&Event{Kind: EventUpdated, Domain: domain}) != refreshGet {
+		t.Fatal("refresh of updated event is not get")
+	}
+
+	// getting it fails, the revoked one is kept and tried again after a minute
+	notAfter, _ := c.refresh(context.TODO(), refreshGet, domain, config)
+	if string(config.Certificates[0].Certificate[0]) != string(certificate.Certificate[0]) || notAfter.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("failed refresh swapped the certificate or retries at %s", notAfter)
+	}
+
+	notAfter, _ = c.refresh(context.TODO(), mode, domain, config)
+	if !notAfter.Equal(obtained.NotAfter) {
+		t.Fatalf("next refresh is at %s, not %s", notAfter, obtained.NotAfter)
+	}
+	leaf, parseErr := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	if parseErr != nil || !leaf.NotAfter.Equal(obtained.NotAfter) {
+		t.Fatalf("obtained certificate is not swapped in, %v", parseErr)
+	}
+}
+
+func TestStapleAt(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name       string
+		ocsp       []byte
+		nextUpdate time.Time
+		// want is the offset from now, the one hour floor is relative to the call, so offsets are compared roughly
+		want time.Duration
+		zero bool
+	}{
+		{"missing response", nil, now.AddDate(0, 0, 7), 0, true},
+		{"missing next update", []byte("ocsp"), time.Time{}, 0, true},
+		{"a day before next update", []byte("ocsp"), now.Add(7 * 24 * time.Hour), 6 * 24 * time.Hour, false},
+		// the response is not parsed by the client, it is stapled as acmes returned it
+		{"unparseable response", []byte("not ocsp"), now.Add(48 * time.Hour), 24 * time.Hour, false},
+		{"one hour floor", []byte("ocsp"), now.Add(2 * time.Hour), time.Hour, false},
+		{"one hour floor of stale response", []byte("ocsp"), now.Add(-time.Hour), time.Hour, false},
+	}
+	for _, c := range cases {
+		at := stapleAt(&Certificate{OCSP: c.ocsp, OCSPNextUpdate: c.nextUpdate})
+		if c.zero {
+			if !at.IsZero() {
+				t.Errorf("%s: staple is refreshed at %s", c.name, at)
+			}
+			continue
+		}
+		if diff := at.Sub(now.Add(c.want)); diff < 0 || diff > time.Minute {
+			t.Errorf("%s: staple is refreshed at %s, want %s", c.name, at, now.Add(c.want))
+		}
+	}
+}
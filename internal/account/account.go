@@ -0,0 +1,371 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/lego"
+	"github.com/go-acme/lego/v4/registration"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Options struct {
+	// Store is the store of acmes, such as file:///some_dir_path.
+	Store string
+	// Email is the email of the account, accounts are kept by it in the store.
+	Email string
+	// Directory is the directory url of the ca, default is the production of let's encrypt.
+	Directory string
+}
+
+// accountDoesNotExist is the problem of the ca when no account has the key.
+const accountDoesNotExist = "urn:ietf:params:acme:error:accountDoesNotExist"
+
+// Info is the registration of an account at the ca.
+type Info struct {
+	Email     string   `json:"email"`
+	Directory string   `json:"directory"`
+	URI       string   `json:"uri"`
+	Status    string   `json:"status"`
+	Contact   []string `json:"contact"`
+	Orders    string   `json:"orders,omitempty"`
+}
+
+// Manager changes the acme account which acmes registered, every change is saved into the store,
+// keys are only changed while no acmes serve uses the store, it would keep using the old key.
+type Manager struct {
+	stores     store.Store
+	email      string
+	directory  string
+	httpClient *http.Client
+}
+
+func Open(options Options) (m *Manager, err error) {
+	email := strings.TrimSpace(options.Email)
+	if email == "" {
+		err = fmt.Errorf("acmes: open account failed, email is required")
+		return
+	}
+	storeURL := strings.TrimSpace(options.Store)
+	if storeURL == "" {
+		err = fmt.Errorf("acmes: open account failed, store is required")
+		return
+	}
+	directory := strings.TrimSpace(options.Directory)
+	if directory == "" {
+		directory = lego.LEDirectoryProduction
+	}
+	stores, storeErr := store.New(storeURL)
+	if storeErr != nil {
+		err = fmt.Errorf("acmes: open account failed, %v", storeErr)
+		return
+	}
+	m = &Manager{
+		stores:    stores,
+		email:     email,
+		directory: directory,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+	return
+}
+
+func (m *Manager) Close(ctx context.Context) (err error) {
+	err = m.stores.Close(ctx)
+	return
+}
+
+// Show queries the registration at the ca, and saves the current status and contacts.
+func (m *Manager) Show(ctx context.Context) (info *Info, err error) {
+	user, client, userErr := m.user(ctx)
+	if userErr != nil {
+		err = fmt.Errorf("acmes: show account failed, %v", userErr)
+		return
+	}
+	reg, queryErr := client.Registration.QueryRegistration()
+	if queryErr != nil {
+		err = fmt.Errorf("acmes: show account failed, %v", queryErr)
+		return
+	}
+	info, err = m.save(ctx, user, reg)
+	if err != nil {
+		err = fmt.Errorf("acmes: show account failed, %v", err)
+		return
+	}
+	return
+}
+
+// UpdateContacts replaces contacts of the account, emails without scheme are prefixed by mailto:.
+func (m *Manager) UpdateContacts(ctx context.Context, contacts []string) (info *Info, err error) {
+	user, _, userErr := m.user(ctx)
+	if userErr != nil {
+		err = fmt.Errorf("acmes: update account failed, %v", userErr)
+		return
+	}
+	request := acme.Account{
+		Contact: make([]string, 0, len(contacts)),
+	}
+	for _, contact := range contacts {
+		contact = strings.TrimSpace(contact)
+		if contact == "" {
+			continue
+		}
+		if !strings.Contains(contact, ":") {
+			contact = "mailto:" + contact
+		}
+		request.Contact = append(request.Contact, contact)
+	}
+	payload, encodeErr := json.Marshal(request)
+	if encodeErr != nil {
+		err = fmt.Errorf("acmes: update account failed, %v", encodeErr)
+		return
+	}
+	ca, caErr := newCAClient(m.httpClient, m.directory)
+	if caErr != nil {
+		err = fmt.Errorf("acmes: update account failed, %v", caErr)
+		return
+	}
+	reg := user.GetRegistration()
+	updated := acme.Account{}
+	err = ca.post(reg.URI, user.GetPrivateKey(), reg.URI, payload, &updated)
+	if err != nil {
+		err = fmt.Errorf("acmes: update account failed, %v", err)
+		return
+	}
+	info, err = m.save(ctx, user, &registration.Resource{URI: reg.URI, Body: updated})
+	if err != nil {
+		err = fmt.Errorf("acmes: update account failed, %v", err)
+		return
+	}
+	return
+}
+
+// RollOver replaces the key of the account by a new rsa 2048 key at the ca, then saves the new key.
+func (m *Manager) RollOver(ctx context.Context) (info *Info, err error) {
+	unlock, lockErr := m.lock(ctx)
+	if lockErr != nil {
+		err = fmt.Errorf("acmes: roll over account key failed, %v", lockErr)
+		return
+	}
+	defer unlock()
+	user, _, userErr := m.user(ctx)
+	if userErr != nil {
+		err = fmt.Errorf("acmes: roll over account key failed, %v", userErr)
+		return
+	}
+	key, keyErr := rsa.GenerateKey(rand.Reader, 2048)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: roll over account key failed, create key failed, %v", keyErr)
+		return
+	}
+	ca, caErr := newCAClient(m.httpClient, m.directory)
+	if caErr != nil {
+		err = fmt.Errorf("acmes: roll over account key failed, %v", caErr)
+		return
+	}
+	// the new key is saved before the ca knows it, so that it is not lost when saving it after the change fails
+	user.PendingKey = pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	if saveErr := m.stores.SaveUser(ctx, user); saveErr != nil {
+		err = fmt.Errorf("acmes: roll over account key failed, save pending key failed, %v", saveErr)
+		return
+	}
+	reg := user.GetRegistration()
+	err = ca.keyChange(reg.URI, user.GetPrivateKey(), key)
+	if err != nil {
+		problem := &acme.ProblemDetails{}
+		if errors.As(err, &problem) {
+			// the ca rejected the change, the current key is kept
+			user.PendingKey = nil
+			_ = m.stores.SaveUser(ctx, user)
+		}
+		err = fmt.Errorf("acmes: roll over account key failed, %v", err)
+		return
+	}
+	user.Key = user.PendingKey
+	user.PendingKey = nil
+	info, err = m.save(ctx, user, reg)
+	if err != nil {
+		err = fmt.Errorf("acmes: roll over account key failed, the key was changed at the ca and is kept as the pending key, it replaces the key at the next command, %v", err)
+		return
+	}
+	return
+}
+
+// Deactivate deactivates the account at the ca, it can not be used or activated again.
+func (m *Manager) Deactivate(ctx context.Context) (info *Info, err error) {
+	user, client, userErr := m.user(ctx)
+	if userErr != nil {
+		err = fmt.Errorf("acmes: deactivate account failed, %v", userErr)
+		return
+	}
+	err = client.Registration.DeleteRegistration()
+	if err != nil {
+		err = fmt.Errorf("acmes: deactivate account failed, %v", err)
+		return
+	}
+	reg := user.GetRegistration()
+	reg.Body.Status = acme.StatusDeactivated
+	info, err = m.save(ctx, user, reg)
+	if err != nil {
+		err = fmt.Errorf("acmes: deactivate account failed, %v", err)
+		return
+	}
+	return
+}
+
+// Recover finds the registration of keyPEM at the ca, and saves it as the account of the email,
+// such as after the store was lost.
+func (m *Manager) Recover(ctx context.Context, keyPEM []byte) (info *Info, err error) {
+	if _, parseErr := certcrypto.ParsePEMPrivateKey(keyPEM); parseErr != nil {
+		err = fmt.Errorf("acmes: recover account failed, %v", parseErr)
+		return
+	}
+	unlock, lockErr := m.lock(ctx)
+	if lockErr != nil {
+		err = fmt.Errorf("acmes: recover account failed, %v", lockErr)
+		return
+	}
+	defer unlock()
+	user := &store.User{
+		Email: m.email,
+		Key:   keyPEM,
+	}
+	client, clientErr := m.client(user)
+	if clientErr != nil {
+		err = fmt.Errorf("acmes: recover account failed, %v", clientErr)
+		return
+	}
+	reg, resolveErr := client.Registration.ResolveAccountByKey()
+	if resolveErr != nil {
+		err = fmt.Errorf("acmes: recover account failed, %v", resolveErr)
+		return
+	}
+	info, err = m.save(ctx, user, reg)
+	if err != nil {
+		err = fmt.Errorf("acmes: recover account failed, %v", err)
+		return
+	}
+	return
+}
+
+// lock holds the store exclusively, it fails while acmes serve uses the store.
+func (m *Manager) lock(ctx context.Context) (unlock func(), err error) {
+	unlock, err = m.stores.Lock(ctx, false)
+	if err != nil {
+		err = fmt.Errorf("stop acmes serve which uses the store first, %v", err)
+		return
+	}
+	return
+}
+
+// user returns the registered user of the email and its lego client.
+func (m *Manager) user(ctx context.Context) (user *store.User, client *lego.Client, err error) {
+	user, has, getErr := m.stores.GetUser(ctx, m.email)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if !has {
+		err = fmt.Errorf("%s is not in the store", m.email)
+		return
+	}
+	reg := user.GetRegistration()
+	if reg == nil || reg.URI == "" {
+		err = fmt.Errorf("%s is not registered", m.email)
+		return
+	}
+	if !user.RegisteredAt(m.directory) {
+		err = fmt.Errorf("%s is registered at another ca than %s", m.email, m.directory)
+		return
+	}
+	if len(user.PendingKey) > 0 {
+		err = m.settle(ctx, user)
+		if err != nil {
+			return
+		}
+	}
+	if user.GetPrivateKey() == nil {
+		err = fmt.Errorf("key of %s is invalid", m.email)
+		return
+	}
+	client, err = m.client(user)
+	return
+}
+
+// settle ends a roll over which was interrupted, its pending key replaces the key when the ca has it, otherwise it is dropped.
+func (m *Manager) settle(ctx context.Context, user *store.User) (err error) {
+	pending := &store.User{
+		Email:    user.Email,
+		Resource: user.Resource,
+		Key:      user.PendingKey,
+	}
+	if pending.GetPrivateKey() != nil {
+		client, clientErr := m.client(pending)
+		if clientErr != nil {
+			err = fmt.Errorf("settle pending key of %s failed, %v", m.email, clientErr)
+			return
+		}
+		reg, resolveErr := client.Registration.ResolveAccountByKey()
+		if resolveErr != nil {
+			problem := &acme.ProblemDetails{}
+			if !errors.As(resolveErr, &problem) || problem.Type != accountDoesNotExist {
+				err = fmt.Errorf("settle pending key of %s failed, %v", m.email, resolveErr)
+				return
+			}
+		} else if reg.URI == user.GetRegistration().URI {
+			user.Key = user.PendingKey
+		}
+	}
+	user.PendingKey = nil
+	err = m.stores.SaveUser(ctx, user)
+	if err != nil {
+		err = fmt.Errorf("settle pending key of %s failed, %v", m.email, err)
+		return
+	}
+	return
+}
+
+func (m *Manager) client(user *store.User) (client *lego.Client, err error) {
+	config := lego.NewConfig(user)
+	config.CADirURL = m.directory
+	config.HTTPClient = m.httpClient
+	client, err = lego.NewClient(config)
+	return
+}
+
+func (m *Manager) save(ctx context.Context, user *store.User, reg *registration.Resource) (info *Info, err error) {
+	content, encodeErr := json.Marshal(reg)
+	if encodeErr != nil {
+		err = encodeErr
+		return
+	}
+	user.Resource = content
+	user.Directory = m.directory
+	err = m.stores.SaveUser(ctx, user)
+	if err != nil {
+		return
+	}
+	info = &Info{
+		Email:     user.Email,
+		Directory: m.directory,
+		URI:       reg.URI,
+		Status:    reg.Body.Status,
+		Contact:   reg.Body.Contact,
+		Orders:    reg.Body.Orders,
+	}
+	return
+}
@@ -0,0 +1,158 @@
+package account
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/registration"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeCA answers key changes by keyChange, and finds the account by key when the key was accepted.
+type fakeCA struct {
+	srv       *httptest.Server
+	keyChange func(w http.ResponseWriter)
+	accepted  bool
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := &fakeCA{}
+	ca.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Replay-Nonce", "nonce")
+		switch r.URL.Path {
+		case "/directory":
+			_ = json.NewEncoder(w).Encode(acme.Directory{
+				NewNonceURL:   ca.srv.URL + "/nonce",
+				NewAccountURL: ca.srv.URL + "/new-account",
+				NewOrderURL:   ca.srv.URL + "/new-order",
+				KeyChangeURL:  ca.srv.URL + "/key-change",
+			})
+		case "/nonce":
+		case "/key-change":
+			ca.keyChange(w)
+		case "/new-account":
+			w.Header().Set("Content-Type", "application/json")
+			if !ca.accepted {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(acme.ProblemDetails{Type: accountDoesNotExist, Detail: "no account"})
+				return
+			}
+			w.Header().Set("Location", ca.srv.URL+"/acct/1")
+			_ = json.NewEncoder(w).Encode(acme.Account{Status: acme.StatusValid})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(ca.srv.Close)
+	return ca
+}
+
+func newTestManager(t *testing.T, ca *fakeCA) (m *Manager, oldKey []byte) {
+	stores, storeErr := store.NewFileStore(t.TempDir())
+	if storeErr != nil {
+		t.Fatal(storeErr)
+	}
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	oldKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	resource, _ := json.Marshal(&registration.Resource{URI: ca.srv.URL + "/acct/1", Body: acme.Account{Status: acme.StatusValid}})
+	if err := stores.SaveUser(context.TODO(), &store.User{Email: "foo@bar.com", Resource: resource, Key: oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	m = &Manager{
+		stores:     stores,
+		email:      "foo@bar.com",
+		directory:  ca.srv.URL + "/directory",
+		httpClient: ca.srv.Client(),
+	}
+	return
+}
+
+func storedUser(t *testing.T, m *Manager) *store.User {
+	user, has, err := m.stores.GetUser(context.TODO(), m.email)
+	if err != nil || !has {
+		t.Fatalf("user is not stored, %v", err)
+	}
+	return user
+}
+
+func TestManager_RollOver(t *testing.T) {
+	ca := newFakeCA(t)
+	m, oldKey := newTestManager(t, ca)
+
+	// the new key is pending in the store before the ca is asked to change it
+	var pending []byte
+	ca.keyChange = func(w http.ResponseWriter) {
+		pending = storedUser(t, m).PendingKey
+	}
+	if _, err := m.RollOver(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	user := storedUser(t, m)
+	if len(pending) == 0 || !bytes.Equal(user.Key, pending) || len(user.PendingKey) != 0 {
+		t.Fatal("new key was not pending before the change, or is not committed after it")
+	}
+
+	// a rejected change keeps the key and drops the pending one
+	current := user.Key
+	ca.keyChange = func(w http.ResponseWriter) {
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(acme.ProblemDetails{Type: "urn:ietf:params:acme:error:malformed", Detail: "conflict"})
+	}
+	if _, err := m.RollOver(context.TODO()); err == nil {
+		t.Fatal("rejected roll over succeeded")
+	}
+	user = storedUser(t, m)
+	if !bytes.Equal(user.Key, current) || len(user.PendingKey) != 0 {
+		t.Fatal("rejected roll over changed keys")
+	}
+	if bytes.Equal(current, oldKey) {
+		t.Fatal("key was not rolled over")
+	}
+
+	// the key is not changed while acmes serve holds the store
+	unlock, lockErr := m.stores.Lock(context.TODO(), true)
+	if lockErr != nil {
+		t.Fatal(lockErr)
+	}
+	defer unlock()
+	ca.keyChange = func(w http.ResponseWriter) {
+		t.Error("key change was sent while the store is held")
+	}
+	if _, err := m.RollOver(context.TODO()); err == nil {
+		t.Fatal("roll over succeeded while the store is held")
+	}
+}
+
+func TestManager_settle(t *testing.T) {
+	ca := newFakeCA(t)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	pending := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(newKey)})
+	for _, accepted := range []bool{false, true} {
+		m, oldKey := newTestManager(t, ca)
+		user := storedUser(t, m)
+		user.PendingKey = pending
+		if err := m.stores.SaveUser(context.TODO(), user); err != nil {
+			t.Fatal(err)
+		}
+		ca.accepted = accepted
+		settled, _, err := m.user(context.TODO())
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := oldKey
+		if accepted {
+			want = pending
+		}
+		if stored := storedUser(t, m); !bytes.Equal(settled.Key, want) || !bytes.Equal(stored.Key, want) || len(stored.PendingKey) != 0 {
+			t.Fatalf("pending key which the ca accepted %v is not settled", accepted)
+		}
+	}
+}
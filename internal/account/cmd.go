@@ -0,0 +1,187 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/server"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+)
+
+var Command = &cli.Command{
+	Name:        "account",
+	Usage:       "account {show|update|rollover|deactivate|recover} --config {config_path} --account {name} | --store {file:///some_dir_path} --email {email} --directory {directory_url}",
+	Description: "inspect and change the acme account which acmes registered, rollover and recover refuse to run while acmes serve uses the store",
+	ArgsUsage:   "",
+	Category:    "",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "show",
+			Usage: "show the registration status and contacts at the ca",
+			Action: run(func(ctx context.Context, c *cli.Context, m *Manager) (*Info, error) {
+				return m.Show(ctx)
+			}),
+			Flags: flags(),
+		},
+		{
+			Name:  "update",
+			Usage: "update --contact {email} --contact {email}, replace contacts of the account",
+			Action: run(func(ctx context.Context, c *cli.Context, m *Manager) (*Info, error) {
+				contacts := c.StringSlice("contact")
+				if len(contacts) == 0 {
+					return nil, fmt.Errorf("acmes: update account failed, contact is required")
+				}
+				return m.UpdateContacts(ctx, contacts)
+			}),
+			Flags: append(flags(), &cli.StringSliceFlag{
+				Name:  "contact",
+				Usage: "contact email or url, it can be repeated",
+			}),
+		},
+		{
+			Name:  "rollover",
+			Usage: "replace the account key by a new one, rfc 8555 key change",
+			Action: run(func(ctx context.Context, c *cli.Context, m *Manager) (*Info, error) {
+				return m.RollOver(ctx)
+			}),
+			Flags: flags(),
+		},
+		{
+			Name:  "deactivate",
+			Usage: "deactivate --yes, deactivate the account at the ca, it can not be undone",
+			Action: run(func(ctx context.Context, c *cli.Context, m *Manager) (*Info, error) {
+				if !c.Bool("yes") {
+					return nil, fmt.Errorf("acmes: deactivate account failed, it can not be undone, confirm it by --yes")
+				}
+				return m.Deactivate(ctx)
+			}),
+			Flags: append(flags(), &cli.BoolFlag{
+				Name:  "yes",
+				Usage: "confirm the deactivation",
+			}),
+		},
+		{
+			Name:  "recover",
+			Usage: "recover --key {key_path}, find the registration of the key at the ca and save it as the account of the email",
+			Action: run(func(ctx context.Context, c *cli.Context, m *Manager) (*Info, error) {
+				path := strings.TrimSpace(c.String("key"))
+				if path == "" {
+					return nil, fmt.Errorf("acmes: recover account failed, key is required")
+				}
+				keyPEM, readErr := os.ReadFile(path)
+				if readErr != nil {
+					return nil, fmt.Errorf("acmes: recover account failed, %v", readErr)
+				}
+				return m.Recover(ctx, keyPEM)
+			}),
+			Flags: append(flags(), &cli.StringFlag{
+				Name:  "key",
+				Value: "",
+				Usage: "pem file of the account key",
+			}),
+		},
+	},
+}
+
+// flags locate the account, every subcommand has them so that they can follow the subcommand.
+func flags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Value:   "",
+			Usage:   "config file of acmes serve, the account is located in it, flags which are set take precedence over it",
+			EnvVars: []string{"ACMES_CONFIG"},
+		},
+		&cli.StringFlag{
+			Name:  "account",
+			Value: "",
+			Usage: "name of the account in the config file, default is the default account",
+		},
+		&cli.StringFlag{
+			Name:    "store",
+			Value:   "",
+			Usage:   "store for certs",
+			EnvVars: []string{"ACMES_STORE"},
+		},
+		&cli.StringFlag{
+			Name:    "email",
+			Value:   "",
+			Usage:   "user email for acme",
+			EnvVars: []string{"ACMES_EMAIL"},
+		},
+		&cli.StringFlag{
+			Name:    "directory",
+			Value:   "",
+			Usage:   "directory url of the acme ca, default is the production of let's encrypt",
+			EnvVars: []string{"ACMES_DIRECTORY"},
+		},
+	}
+}
+
+// run opens the account by flags, runs fn and prints the account info.
+func run(fn func(ctx context.Context, c *cli.Context, m *Manager) (*Info, error)) cli.ActionFunc {
+	return func(c *cli.Context) (err error) {
+		options, optionsErr := optionsOf(c)
+		if optionsErr != nil {
+			err = optionsErr
+			return
+		}
+		m, openErr := Open(options)
+		if openErr != nil {
+			err = openErr
+			return
+		}
+		ctx := context.TODO()
+		defer func() {
+			_ = m.Close(ctx)
+		}()
+		info, fnErr := fn(ctx, c, m)
+		if fnErr != nil {
+			err = fnErr
+			return
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(info)
+		return
+	}
+}
+
+// optionsOf locates the account by the config file and the account name, or by flags alone.
+func optionsOf(c *cli.Context) (options Options, err error) {
+	path := strings.TrimSpace(c.String("config"))
+	if path == "" {
+		if c.IsSet("account") {
+			err = fmt.Errorf("acmes: open account failed, account is located in the config file, config is required")
+			return
+		}
+		options = Options{
+			Store:     c.String("store"),
+			Email:     c.String("email"),
+			Directory: c.String("directory"),
+		}
+		return
+	}
+	storeURL, acct, loadErr := server.LoadAccount(path, c.String("account"))
+	if loadErr != nil {
+		err = fmt.Errorf("acmes: open account failed, %v", loadErr)
+		return
+	}
+	options = Options{
+		Store:     storeURL,
+		Email:     acct.Email,
+		Directory: acct.Directory,
+	}
+	if c.IsSet("store") {
+		options.Store = c.String("store")
+	}
+	if c.IsSet("email") {
+		options.Email = c.String("email")
+	}
+	if c.IsSet("directory") {
+		options.Directory = c.String("directory")
+	}
+	return
+}
@@ -0,0 +1,163 @@
+package account
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-jose/go-jose/v3"
+	"io"
+	"net/http"
+)
+
+// caClient sends requests which lego does not offer, such as key change and contacts other than the email of the user.
+type caClient struct {
+	httpClient *http.Client
+	directory  acme.Directory
+}
+
+func newCAClient(httpClient *http.Client, directoryURL string) (c *caClient, err error) {
+	resp, getErr := httpClient.Get(directoryURL)
+	if getErr != nil {
+		err = fmt.Errorf("get directory %s failed, %v", directoryURL, getErr)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("get directory %s failed, %s", directoryURL, resp.Status)
+		return
+	}
+	c = &caClient{
+		httpClient: httpClient,
+	}
+	err = json.NewDecoder(resp.Body).Decode(&c.directory)
+	if err != nil {
+		err = fmt.Errorf("decode directory %s failed, %v", directoryURL, err)
+		return
+	}
+	return
+}
+
+// Nonce implements jose.NonceSource by the newNonce resource of the ca.
+func (c *caClient) Nonce() (nonce string, err error) {
+	resp, headErr := c.httpClient.Head(c.directory.NewNonceURL)
+	if headErr != nil {
+		err = fmt.Errorf("get nonce failed, %v", headErr)
+		return
+	}
+	_ = resp.Body.Close()
+	nonce = resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		err = fmt.Errorf("get nonce failed, no Replay-Nonce in response")
+		return
+	}
+	return
+}
+
+// post signs payload by key of the account at accountURL, and decodes the response into response which may be nil.
+func (c *caClient) post(url string, key crypto.PrivateKey, accountURL string, payload []byte, response interface{}) (err error) {
+	signer, signerErr := jose.NewSigner(jose.SigningKey{
+		Algorithm: algorithmOf(key),
+		Key:       jose.JSONWebKey{Key: key, KeyID: accountURL},
+	}, &jose.SignerOptions{
+		NonceSource:  c,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": url},
+	})
+	if signerErr != nil {
+		err = fmt.Errorf("create signer failed, %v", signerErr)
+		return
+	}
+	signed, signErr := signer.Sign(payload)
+	if signErr != nil {
+		err = fmt.Errorf("sign request failed, %v", signErr)
+		return
+	}
+	resp, postErr := c.httpClient.Post(url, "application/jose+json", bytes.NewReader([]byte(signed.FullSerialize())))
+	if postErr != nil {
+		err = fmt.Errorf("post %s failed, %v", url, postErr)
+		return
+	}
+	defer resp.Body.Close()
+	body, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		err = fmt.Errorf("post %s failed, %v", url, readErr)
+		return
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		problem := &acme.ProblemDetails{}
+		if json.Unmarshal(body, problem) != nil || problem.Type == "" {
+			err = fmt.Errorf("post %s failed, %s", url, resp.Status)
+			return
+		}
+		err = problem
+		return
+	}
+	if response != nil && len(body) > 0 {
+		err = json.Unmarshal(body, response)
+		if err != nil {
+			err = fmt.Errorf("decode response of %s failed, %v", url, err)
+			return
+		}
+	}
+	return
+}
+
+// keyChange replaces the key of the account at accountURL by newKey, as section 7.3.5 of rfc 8555.
+func (c *caClient) keyChange(accountURL string, oldKey crypto.PrivateKey, newKey crypto.PrivateKey) (err error) {
+	if c.directory.KeyChangeURL == "" {
+		err = fmt.Errorf("the ca does not support key change")
+		return
+	}
+	oldSigner, ok := oldKey.(crypto.Signer)
+	if !ok {
+		err = fmt.Errorf("the old key can not sign")
+		return
+	}
+	inner, innerErr := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  jose.JSONWebKey `json:"oldKey"`
+	}{
+		Account: accountURL,
+		OldKey:  jose.JSONWebKey{Key: oldSigner.Public()},
+	})
+	if innerErr != nil {
+		err = fmt.Errorf("encode key change failed, %v", innerErr)
+		return
+	}
+	// the inner jws is signed by the new key with its jwk embedded, and has no nonce
+	signer, signerErr := jose.NewSigner(jose.SigningKey{
+		Algorithm: algorithmOf(newKey),
+		Key:       newKey,
+	}, &jose.SignerOptions{
+		EmbedJWK:     true,
+		ExtraHeaders: map[jose.HeaderKey]interface{}{"url": c.directory.KeyChangeURL},
+	})
+	if signerErr != nil {
+		err = fmt.Errorf("create signer of the new key failed, %v", signerErr)
+		return
+	}
+	signed, signErr := signer.Sign(inner)
+	if signErr != nil {
+		err = fmt.Errorf("sign key change failed, %v", signErr)
+		return
+	}
+	err = c.post(c.directory.KeyChangeURL, oldKey, accountURL, []byte(signed.FullSerialize()), nil)
+	return
+}
+
+func algorithmOf(key crypto.PrivateKey) jose.SignatureAlgorithm {
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		if k.Curve == elliptic.P384() {
+			return jose.ES384
+		}
+		return jose.ES256
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		return jose.RS256
+	}
+	return ""
+}
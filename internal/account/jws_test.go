@@ -0,0 +1,89 @@
+package account
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"github.com/go-acme/lego/v4/acme"
+	"github.com/go-jose/go-jose/v3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCAClient_KeyChange(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	const accountPath = "/acct/1"
+	var srv *httptest.Server
+	changed := false
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/directory":
+			_ = json.NewEncoder(w).Encode(acme.Directory{
+				NewNonceURL:  srv.URL + "/nonce",
+				KeyChangeURL: srv.URL + "/key-change",
+			})
+		case "/nonce":
+			w.Header().Set("Replay-Nonce", "nonce-1")
+		case "/key-change":
+			body, _ := io.ReadAll(r.Body)
+			outer, parseErr := jose.ParseSigned(string(body))
+			if parseErr != nil {
+				t.Error(parseErr)
+				return
+			}
+			header := outer.Signatures[0].Protected
+			if header.KeyID != srv.URL+accountPath || header.Nonce != "nonce-1" || header.ExtraHeaders["url"] != srv.URL+"/key-change" {
+				t.Errorf("outer header is invalid, %+v", header)
+			}
+			innerContent, verifyErr := outer.Verify(&oldKey.PublicKey)
+			if verifyErr != nil {
+				t.Error(verifyErr)
+				return
+			}
+			inner, innerErr := jose.ParseSigned(string(innerContent))
+			if innerErr != nil {
+				t.Error(innerErr)
+				return
+			}
+			innerHeader := inner.Signatures[0].Protected
+			if innerHeader.JSONWebKey == nil || innerHeader.Nonce != "" || innerHeader.ExtraHeaders["url"] != srv.URL+"/key-change" {
+				t.Errorf("inner header is invalid, %+v", innerHeader)
+			}
+			payload, innerVerifyErr := inner.Verify(&newKey.PublicKey)
+			if innerVerifyErr != nil {
+				t.Error(innerVerifyErr)
+				return
+			}
+			change := struct {
+				Account string          `json:"account"`
+				OldKey  jose.JSONWebKey `json:"oldKey"`
+			}{}
+			if decodeErr := json.Unmarshal(payload, &change); decodeErr != nil {
+				t.Error(decodeErr)
+				return
+			}
+			if change.Account != srv.URL+accountPath || change.OldKey.Key.(*rsa.PublicKey).N.Cmp(oldKey.N) != 0 {
+				t.Errorf("key change payload is invalid, %s", payload)
+			}
+			changed = true
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ca, caErr := newCAClient(srv.Client(), srv.URL+"/directory")
+	if caErr != nil {
+		t.Fatal(caErr)
+	}
+	if err := ca.keyChange(srv.URL+accountPath, oldKey, newKey); err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("key change was not posted")
+	}
+}
@@ -0,0 +1,279 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aacfactory/acmes/client"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/aacfactory/logs"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultInterval = time.Hour
+	// retryInterval is how soon a failed certificate or a lost event stream is tried again.
+	retryInterval = time.Minute
+)
+
+// Agent keeps certificates of domains written to files, for software which can not embed the client.
+type Agent struct {
+	log      logs.Logger
+	client   *client.Client
+	interval time.Duration
+	targets  []*target
+}
+
+// target is a certificate of the config with its parsed modes and owner.
+type target struct {
+	CertificateConfig
+	uid      int
+	gid      int
+	mode     os.FileMode
+	keyMode  os.FileMode
+	notAfter time.Time
+	// reloading is true from a change of files until the hook succeeded, so that a failed hook is run again.
+	reloading bool
+}
+
+// New creates an agent by a validated config.
+func New(log logs.Logger, config *Config) (agent *Agent, err error) {
+	caPEM, caErr := os.ReadFile(config.CA)
+	if caErr != nil {
+		err = fmt.Errorf("acmes: create agent failed, %v", caErr)
+		return
+	}
+	caKeyPEM, caKeyErr := os.ReadFile(config.CAKey)
+	if caKeyErr != nil {
+		err = fmt.Errorf("acmes: create agent failed, %v", caKeyErr)
+		return
+	}
+	c, clientErr := client.New(caPEM, caKeyPEM, config.Host)
+	if clientErr != nil {
+		err = fmt.Errorf("acmes: create agent failed, %v", clientErr)
+		return
+	}
+	interval := config.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	agent = &Agent{
+		log:      log,
+		client:   c.WithAccount(config.Account).WithPreferredChain(config.PreferredChain).WithMustStaple(config.MustStaple),
+		interval: interval,
+		targets:  make([]*target, 0, len(config.Certificates)),
+	}
+	for _, cert := range config.Certificates {
+		t := &target{
+			CertificateConfig: cert,
+		}
+		t.Domain, err = store.NormalizeDomain(cert.Domain)
+		if err != nil {
+			err = fmt.Errorf("acmes: create agent failed, %v", err)
+			return
+		}
+		t.mode, _ = parseMode(cert.Mode, 0644)
+		t.keyMode, _ = parseMode(cert.KeyMode, 0600)
+		t.uid, t.gid, err = lookupOwner(cert.Owner, cert.Group)
+		if err != nil {
+			err = fmt.Errorf("acmes: create agent failed, %v", err)
+			return
+		}
+		agent.targets = append(agent.targets, t)
+	}
+	return
+}
+
+// Once writes every certificate and runs hooks of changed ones, it tries all of them and returns their failures.
+func (agent *Agent) Once(ctx context.Context) (err error) {
+	err = agent.sync(ctx, agent.targets)
+	return
+}
+
+// Run writes every certificate, then writes them again when acmes pushes an event of their domains,
+// when they expire and every interval, until ctx is done.
+func (agent *Agent) Run(ctx context.Context) (err error) {
+	domains := make([]string, 0, len(agent.targets))
+	for _, t := range agent.targets {
+		domains = append(domains, t.Domain)
+	}
+	pending := agent.targets
+	var events <-chan *client.Event
+	var retry <-chan time.Time
+	for {
+		syncErr := agent.sync(ctx, pending)
+		if events == nil && retry == nil {
+			watched, watchErr := agent.client.Watch(ctx, domains...)
+			if watchErr != nil {
+				agent.log.Warn().Cause(watchErr).Message("acmes: agent watch events failed")
+				retry = time.After(retryInterval)
+			} else {
+				events = watched
+			}
+		}
+		next := time.Now().Add(agent.interval)
+		if syncErr != nil {
+			next = time.Now().Add(retryInterval)
+		}
+		for _, t := range agent.targets {
+			if !t.notAfter.IsZero() && t.notAfter.Before(next) {
+				next = t.notAfter
+			}
+		}
+		// a certificate which acmes has not renewed yet is asked for again after a while rather than at once
+		if earliest := time.Now().Add(retryInterval); next.Before(earliest) {
+			next = earliest
+		}
+		timer := time.NewTimer(time.Until(next))
+		pending = nil
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+			pending = agent.targets
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				retry = time.After(retryInterval)
+				break
+			}
+			for _, t := range agent.targets {
+				// events carry the domain as serve keeps it, which targets are normalized to
+				if t.Domain == event.Domain {
+					pending = append(pending, t)
+				}
+			}
+		case <-retry:
+			retry = nil
+		}
+		timer.Stop()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// sync writes certificates of targets, then runs each distinct hook of the changed ones once,
+// so that a server of several domains is reloaded once.
+func (agent *Agent) sync(ctx context.Context, targets []*target) (err error) {
+	failures := make([]error, 0, 1)
+	hooks := make(map[string][]*target)
+	order := make([]string, 0, 1)
+	for _, t := range targets {
+		changed, writeErr := agent.write(ctx, t)
+		if writeErr != nil {
+			agent.log.Warn().Cause(writeErr).Message(fmt.Sprintf("acmes: agent write certificate of %s failed", t.Domain))
+			failures = append(failures, fmt.Errorf("%s: %v", t.Domain, writeErr))
+			continue
+		}
+		if changed {
+			agent.log.Info().Message(fmt.Sprintf("acmes: agent wrote certificate of %s, it expires at %s", t.Domain, t.notAfter.Format(time.RFC3339)))
+			t.reloading = t.Reload != "" || t.Signal != ""
+		}
+		if !t.reloading {
+			continue
+		}
+		key := t.Reload + "\x00" + t.Signal + "\x00" + t.PidFile
+		if _, has := hooks[key]; !has {
+			hooks[key] = make([]*target, 0, 1)
+			order = append(order, key)
+		}
+		hooks[key] = append(hooks[key], t)
+	}
+	for _, key := range order {
+		reloaded := hooks[key]
+		if hookErr := runHook(ctx, reloaded[0]); hookErr != nil {
+			agent.log.Warn().Cause(hookErr).Message(fmt.Sprintf("acmes: agent reload after %s failed", reloaded[0].Domain))
+			failures = append(failures, fmt.Errorf("%s: %v", reloaded[0].Domain, hookErr))
+			continue
+		}
+		for _, t := range reloaded {
+			t.reloading = false
+		}
+	}
+	if len(failures) > 0 {
+		err = fmt.Errorf("acmes: agent failed, %v", errors.Join(failures...))
+		return
+	}
+	return
+}
+
+// write gets the certificate of t, renews it when it expired, and writes its files.
+func (agent *Agent) write(ctx context.Context, t *target) (changed bool, err error) {
+	cert, obtainErr := agent.client.ObtainCertificate(ctx, t.Domain)
+	if obtainErr != nil {
+		err = obtainErr
+		return
+	}
+	if !time.Now().Before(cert.NotAfter) {
+		cert, err = agent.client.RenewCertificate(ctx, t.Domain)
+		if err != nil {
+			return
+		}
+	}
+	chain := cert.Chain
+	if len(chain) == 0 {
+		chain = cert.Cert
+	}
+	files := []struct {
+		path    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{t.Cert, cert.Leaf, t.mode},
+		{t.Chain, cert.Intermediates, t.mode},
+		{t.FullChain, chain, t.mode},
+		{t.Key, cert.Key, t.keyMode},
+		{t.Combined, append(append(make([]byte, 0, len(chain)+len(cert.Key)), chain...), cert.Key...), t.keyMode},
+	}
+	for _, file := range files {
+		if file.path == "" {
+			continue
+		}
+		fileChanged, writeErr := writeFile(file.path, file.content, file.mode, t.uid, t.gid)
+		if writeErr != nil {
+			err = writeErr
+			return
+		}
+		changed = changed || fileChanged
+	}
+	t.notAfter = cert.NotAfter
+	return
+}
+
+// runHook runs the reload command of t, then sends its signal to the process in its pid file.
+func runHook(ctx context.Context, t *target) (err error) {
+	if reload := strings.TrimSpace(t.Reload); reload != "" {
+		output, runErr := exec.CommandContext(ctx, "sh", "-c", reload).CombinedOutput()
+		if runErr != nil {
+			err = fmt.Errorf("run %s failed, %v, %s", reload, runErr, strings.TrimSpace(string(output)))
+			return
+		}
+	}
+	if t.Signal == "" {
+		return
+	}
+	content, readErr := os.ReadFile(t.PidFile)
+	if readErr != nil {
+		err = fmt.Errorf("read pid file failed, %v", readErr)
+		return
+	}
+	pid, parseErr := strconv.Atoi(strings.TrimSpace(string(content)))
+	if parseErr != nil {
+		err = fmt.Errorf("pid file %s is invalid", t.PidFile)
+		return
+	}
+	process, findErr := os.FindProcess(pid)
+	if findErr != nil {
+		err = fmt.Errorf("find process %d failed, %v", pid, findErr)
+		return
+	}
+	if signalErr := process.Signal(signals[strings.TrimPrefix(strings.ToUpper(t.Signal), "SIG")]); signalErr != nil {
+		err = fmt.Errorf("send %s to %d failed, %v", t.Signal, pid, signalErr)
+		return
+	}
+	return
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"github.com/aacfactory/logs"
+	"github.com/urfave/cli/v2"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var Command = &cli.Command{
+	Name:        "agent",
+	Usage:       "agent --config {config_path} [--once]",
+	Description: "obtain certificates of domains from acmes, write them into files and reload the software which reads them, keep them renewed unless --once",
+	ArgsUsage:   "",
+	Category:    "",
+	Action: func(c *cli.Context) (err error) {
+		config, loadErr := loadConfig(strings.TrimSpace(c.String("config")))
+		if loadErr != nil {
+			err = loadErr
+			return
+		}
+		err = config.Validate()
+		if err != nil {
+			return
+		}
+		logLevel := logs.InfoLevel
+		switch strings.ToLower(strings.TrimSpace(c.String("level"))) {
+		case "debug":
+			logLevel = logs.DebugLevel
+		case "warn":
+			logLevel = logs.WarnLevel
+		case "error":
+			logLevel = logs.ErrorLevel
+		}
+		log, logErr := logs.New(logs.WithConsoleWriterFormatter(logs.TextFormatter), logs.WithLevel(logLevel))
+		if logErr != nil {
+			err = fmt.Errorf("acmes: create log failed, %v", logErr)
+			return
+		}
+		defer func() {
+			logCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_ = log.Shutdown(logCtx)
+			cancel()
+		}()
+		agent, agentErr := New(log, config)
+		if agentErr != nil {
+			err = agentErr
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		if c.Bool("once") {
+			err = agent.Once(ctx)
+			return
+		}
+		err = agent.Run(ctx)
+		return
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Required: true,
+			Name:     "config",
+			Value:    "",
+			Usage:    "yaml or toml config file of the agent",
+			EnvVars:  []string{"ACMES_AGENT_CONFIG"},
+		},
+		&cli.BoolFlag{
+			Name:    "once",
+			Usage:   "write certificates once and exit, non-zero when one failed, such as for cron",
+			EnvVars: []string{"ACMES_AGENT_ONCE"},
+		},
+		&cli.StringFlag{
+			Name:    "level",
+			Value:   "info",
+			Usage:   "log level, debug, info, warn or error",
+			EnvVars: []string{"ACMES_AGENT_LEVEL"},
+		},
+	},
+}
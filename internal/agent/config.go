@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/aacfactory/acmes/internal/store"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type Config struct {
+	// Host is the address of acmes, such as acmes.foo.com:443.
+	Host string `yaml:"host" toml:"host"`
+	// CA and CAKey sign the client certificate of the agent, they are the ones of acmes serve.
+	CA    string `yaml:"ca" toml:"ca"`
+	CAKey string `yaml:"caKey" toml:"caKey"`
+	// Account is the name of the acme account, acmes picks it by the domain when it is empty.
+	Account        string `yaml:"account" toml:"account"`
+	PreferredChain string `yaml:"preferredChain" toml:"preferredChain"`
+	MustStaple     bool   `yaml:"mustStaple" toml:"mustStaple"`
+	// Interval is how often certificates are checked when no event arrives, default is 1h.
+	Interval     time.Duration       `yaml:"interval" toml:"interval"`
+	Certificates []CertificateConfig `yaml:"certificates" toml:"certificates"`
+}
+
+// CertificateConfig is where the certificate of a domain is written, and how the software which reads it is told.
+type CertificateConfig struct {
+	Domain string `yaml:"domain" toml:"domain"`
+	// Cert is the path of the leaf.
+	Cert string `yaml:"cert" toml:"cert"`
+	// Chain is the path of the intermediates.
+	Chain string `yaml:"chain" toml:"chain"`
+	// FullChain is the path of the leaf followed by the intermediates, such as ssl_certificate of nginx.
+	FullChain string `yaml:"fullChain" toml:"fullChain"`
+	// Key is the path of the private key.
+	Key string `yaml:"key" toml:"key"`
+	// Combined is the path of the full chain followed by the key, such as crt of haproxy.
+	Combined string `yaml:"combined" toml:"combined"`
+	// Owner and Group are names or ids which own the files, they are kept as the agent when they are empty.
+	Owner string `yaml:"owner" toml:"owner"`
+	Group string `yaml:"group" toml:"group"`
+	// Mode is the octal mode of certificate files, default is 0644.
+	Mode string `yaml:"mode" toml:"mode"`
+	// KeyMode is the octal mode of the key and the combined file, default is 0600.
+	KeyMode string `yaml:"keyMode" toml:"keyMode"`
+	// Reload is run by sh -c after files were changed, such as systemctl reload nginx.
+	Reload string `yaml:"reload" toml:"reload"`
+	// Signal is sent to the process in PidFile after files were changed, such as HUP.
+	Signal  string `yaml:"signal" toml:"signal"`
+	PidFile string `yaml:"pidFile" toml:"pidFile"`
+}
+
+// signals are the ones which a reload can send, USR1 and USR2 are added on unix.
+var signals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+}
+
+// loadConfig reads the config file, the format is toml when the extension is .toml, otherwise yaml.
+func loadConfig(path string) (config *Config, err error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		err = fmt.Errorf("acmes: read agent config failed, %v", readErr)
+		return
+	}
+	config = &Config{}
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		meta, decodeErr := toml.NewDecoder(bytes.NewReader(content)).Decode(config)
+		if decodeErr != nil {
+			err = fmt.Errorf("acmes: decode agent config %s failed, %v", path, decodeErr)
+			return
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			err = fmt.Errorf("acmes: decode agent config %s failed, unknown field %s", path, undecoded[0].String())
+			return
+		}
+		return
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	decodeErr := decoder.Decode(config)
+	if decodeErr != nil && !errors.Is(decodeErr, io.EOF) {
+		err = fmt.Errorf("acmes: decode agent config %s failed, %v", path, decodeErr)
+		return
+	}
+	return
+}
+
+// Validate reports all invalid fields at once.
+func (config *Config) Validate() (err error) {
+	problems := make([]string, 0, 1)
+	if strings.TrimSpace(config.Host) == "" {
+		problems = append(problems, "host is required")
+	}
+	if strings.TrimSpace(config.CA) == "" || strings.TrimSpace(config.CAKey) == "" {
+		problems = append(problems, "ca and caKey are required")
+	}
+	if config.Interval < 0 {
+		problems = append(problems, "interval can not be negative")
+	}
+	if len(config.Certificates) == 0 {
+		problems = append(problems, "certificates are required")
+	}
+	domains := make(map[string]struct{}, len(config.Certificates))
+	for i, cert := range config.Certificates {
+		if strings.TrimSpace(cert.Domain) == "" {
+			problems = append(problems, fmt.Sprintf("domain of certificates[%d] is required", i))
+			continue
+		}
+		domain, domainErr := store.NormalizeDomain(cert.Domain)
+		if domainErr != nil {
+			problems = append(problems, fmt.Sprintf("certificates[%d] is invalid, %v", i, domainErr))
+			continue
+		}
+		if _, has := domains[domain]; has {
+			problems = append(problems, fmt.Sprintf("domain %s is duplicated", domain))
+		}
+		domains[domain] = struct{}{}
+		if cert.Cert == "" && cert.Chain == "" && cert.FullChain == "" && cert.Key == "" && cert.Combined == "" {
+			problems = append(problems, fmt.Sprintf("%s has no file to write", domain))
+		}
+		if _, modeErr := parseMode(cert.Mode, 0644); modeErr != nil {
+			problems = append(problems, fmt.Sprintf("mode of %s is invalid, %v", domain, modeErr))
+		}
+		if _, modeErr := parseMode(cert.KeyMode, 0600); modeErr != nil {
+			problems = append(problems, fmt.Sprintf("keyMode of %s is invalid, %v", domain, modeErr))
+		}
+		if _, _, ownerErr := lookupOwner(cert.Owner, cert.Group); ownerErr != nil {
+			problems = append(problems, fmt.Sprintf("owner of %s is invalid, %v", domain, ownerErr))
+		}
+		if (cert.Signal == "") != (cert.PidFile == "") {
+			problems = append(problems, fmt.Sprintf("signal and pidFile of %s must be set together", domain))
+		}
+		if cert.Signal != "" {
+			if _, has := signals[strings.TrimPrefix(strings.ToUpper(cert.Signal), "SIG")]; !has {
+				problems = append(problems, fmt.Sprintf("signal %s of %s is not supported", cert.Signal, domain))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("acmes: agent config is invalid, %s", strings.Join(problems, "; "))
+		return
+	}
+	return
+}
+
+func parseMode(value string, defaultMode os.FileMode) (mode os.FileMode, err error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		mode = defaultMode
+		return
+	}
+	n, parseErr := strconv.ParseUint(value, 8, 32)
+	if parseErr != nil || n > 0777 {
+		err = fmt.Errorf("%s is not an octal file mode", value)
+		return
+	}
+	mode = os.FileMode(n)
+	return
+}
+
+// lookupOwner returns ids of owner and group by names or ids, they are -1 when they are empty, which keeps them as they are.
+func lookupOwner(owner string, group string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+	if owner = strings.TrimSpace(owner); owner != "" {
+		u, lookupErr := user.Lookup(owner)
+		if lookupErr != nil {
+			u, lookupErr = user.LookupId(owner)
+		}
+		if lookupErr != nil {
+			err = fmt.Errorf("user %s was not found", owner)
+			return
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if group = strings.TrimSpace(group); group != "" {
+		g, lookupErr := user.LookupGroup(group)
+		if lookupErr != nil {
+			g, lookupErr = user.LookupGroupId(group)
+		}
+		if lookupErr != nil {
+			err = fmt.Errorf("group %s was not found", group)
+			return
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// writeFile replaces path by content atomically, a temp file in the same dir is written, synced and renamed over path,
+// so that readers never see a half written file. changed is false when path has content already,
+// its mode and owner are still corrected.
+func writeFile(path string, content []byte, mode os.FileMode, uid int, gid int) (changed bool, err error) {
+	current, readErr := os.ReadFile(path)
+	if readErr == nil && bytes.Equal(current, content) {
+		err = chmodAndChown(path, mode, uid, gid)
+		return
+	}
+	dir := filepath.Dir(path)
+	if mkdirErr := os.MkdirAll(dir, 0755); mkdirErr != nil {
+		err = fmt.Errorf("create dir of %s failed, %v", path, mkdirErr)
+		return
+	}
+	tmp, createErr := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if createErr != nil {
+		err = fmt.Errorf("create temp file of %s failed, %v", path, createErr)
+		return
+	}
+	tmpPath := tmp.Name()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpPath)
+		}
+	}()
+	_, writeErr := tmp.Write(content)
+	if writeErr == nil {
+		writeErr = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if writeErr != nil {
+		err = fmt.Errorf("write %s failed, %v", path, writeErr)
+		return
+	}
+	if closeErr != nil {
+		err = fmt.Errorf("write %s failed, %v", path, closeErr)
+		return
+	}
+	err = chmodAndChown(tmpPath, mode, uid, gid)
+	if err != nil {
+		return
+	}
+	if renameErr := os.Rename(tmpPath, path); renameErr != nil {
+		err = fmt.Errorf("rename temp file to %s failed, %v", path, renameErr)
+		return
+	}
+	// the rename is durable once the dir is synced, it is not supported everywhere
+	if d, openErr := os.Open(dir); openErr == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	changed = true
+	return
+}
+
+func chmodAndChown(path string, mode os.FileMode, uid int, gid int) (err error) {
+	if chmodErr := os.Chmod(path, mode); chmodErr != nil {
+		err = fmt.Errorf("chmod %s failed, %v", path, chmodErr)
+		return
+	}
+	if uid < 0 && gid < 0 {
+		return
+	}
+	if chownErr := os.Chown(path, uid, gid); chownErr != nil {
+		err = fmt.Errorf("chown %s failed, %v", path, chownErr)
+		return
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tls", "www.foo.com.key")
+	changed, err := writeFile(path, []byte("key"), 0600, -1, -1)
+	if err != nil || !changed {
+		t.Fatalf("first write is not changed, %v", err)
+	}
+	changed, err = writeFile(path, []byte("key"), 0640, -1, -1)
+	if err != nil || changed {
+		t.Fatalf("same content is changed, %v", err)
+	}
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode is %o, not corrected", info.Mode().Perm())
+	}
+	changed, err = writeFile(path, []byte("new key"), 0600, -1, -1)
+	if err != nil || !changed {
+		t.Fatalf("new content is not changed, %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	if string(content) != "new key" {
+		t.Fatalf("content is %s", content)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Fatalf("temp files are left, %d entries", len(entries))
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := CertificateConfig{Domain: "www.foo.com", FullChain: "/etc/nginx/www.crt", Key: "/etc/nginx/www.key", Signal: "HUP", PidFile: "/run/nginx.pid"}
+	newConfig := func(certs ...CertificateConfig) *Config {
+		return &Config{
+			Host:         "acmes.foo.com:443",
+			CA:           "ca.crt",
+			CAKey:        "ca.key",
+			Certificates: append([]CertificateConfig{valid}, certs...),
+		}
+	}
+	if err := newConfig().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := newConfig(CertificateConfig{Domain: "Bücher.example.", Key: "a.key"}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		cert    CertificateConfig
+		problem string
+	}{
+		{"empty domain", CertificateConfig{Key: "a.key"}, "domain of certificates[1] is required"},
+		{"invalid domain", CertificateConfig{Domain: "foo..com", Key: "a.key"}, "certificates[1] is invalid"},
+		{"duplicated", CertificateConfig{Domain: "WWW.foo.com.", Key: "a.key"}, "domain www.foo.com is duplicated"},
+		{"no file", CertificateConfig{Domain: "api.foo.com"}, "api.foo.com has no file to write"},
+		{"mode", CertificateConfig{Domain: "db.foo.com", Key: "db.key", KeyMode: "0999"}, "keyMode of db.foo.com is invalid"},
+		{"signal without pid", CertificateConfig{Domain: "db.foo.com", Key: "db.key", Signal: "HUP"}, "signal and pidFile of db.foo.com must be set together"},
+		{"unknown signal", CertificateConfig{Domain: "db.foo.com", Key: "db.key", Signal: "FOO", PidFile: "/run/db.pid"}, "signal FOO of db.foo.com is not supported"},
+	}
+	for _, c := range cases {
+		err := newConfig(c.cert).Validate()
+		if err == nil {
+			t.Errorf("%s: config is valid", c.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%s: %v, want %q", c.name, err, c.problem)
+		}
+	}
+}
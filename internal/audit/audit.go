@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Operation string
+
+const (
+	Obtain = Operation("obtain")
+	Renew  = Operation("renew")
+	Revoke = Operation("revoke")
+)
+
+const (
+	ResultSucceed = "succeed"
+	ResultFailed  = "failed"
+)
+
+type Record struct {
+	Time         time.Time `json:"time"`
+	Client       string    `json:"client"`
+	ClientSerial string    `json:"clientSerial"`
+	RemoteAddr   string    `json:"remoteAddr"`
+	Operation    Operation `json:"operation"`
+	Domains      []string  `json:"domains"`
+	Result       string    `json:"result"`
+	Serial       string    `json:"serial,omitempty"`
+	Cause        string    `json:"cause,omitempty"`
+}
+
+type Filter struct {
+	Since     time.Time
+	Until     time.Time
+	Client    string
+	Domain    string
+	Operation string
+	Result    string
+}
+
+func (f *Filter) Match(record *Record) (ok bool) {
+	if !f.Since.IsZero() && record.Time.Before(f.Since) {
+		return
+	}
+	if !f.Until.IsZero() && record.Time.After(f.Until) {
+		return
+	}
+	if f.Client != "" && f.Client != record.Client && f.Client != record.ClientSerial {
+		return
+	}
+	if f.Operation != "" && f.Operation != string(record.Operation) {
+		return
+	}
+	if f.Result != "" && f.Result != record.Result {
+		return
+	}
+	if f.Domain != "" {
+		matched := false
+		for _, domain := range record.Domains {
+			if strings.EqualFold(domain, f.Domain) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// Log is an append-only log of certificate operations, records are written in json lines.
+type Log interface {
+	Append(ctx context.Context, record *Record) (err error)
+	Query(ctx context.Context, filter Filter) (records []*Record, err error)
+}
+
+// New creates an audit log by target,
+// store writes records into the store, file:///some_path/audit.jsonl writes records into the file.
+func New(target string, stores store.Store) (v Log, err error) {
+	target = strings.TrimSpace(target)
+	switch {
+	case target == "store":
+		if stores == nil {
+			err = fmt.Errorf("acmes: audit log requires store")
+			return
+		}
+		v = &storeLog{
+			stores: stores,
+		}
+	case strings.HasPrefix(target, "file://"):
+		u, urlErr := url.Parse(target)
+		if urlErr != nil {
+			err = fmt.Errorf("acmes: parse audit log url failed, %v", urlErr)
+			return
+		}
+		path, pathErr := store.FilePath(u)
+		if pathErr != nil {
+			err = fmt.Errorf("acmes: parse audit log url failed, %v", pathErr)
+			return
+		}
+		v, err = newFileLog(path)
+	default:
+		err = fmt.Errorf("acmes: audit log %s is not support", target)
+	}
+	return
+}
+
+func encode(record *Record) (line []byte, err error) {
+	line, err = json.Marshal(record)
+	if err != nil {
+		err = fmt.Errorf("acmes: encode audit record failed, %v", err)
+		return
+	}
+	line = append(line, '\n')
+	return
+}
+
+func decode(reader io.Reader, filter Filter) (records []*Record, err error) {
+	records = make([]*Record, 0, 8)
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		record := &Record{}
+		decodeErr := json.Unmarshal(line, record)
+		if decodeErr != nil {
+			err = fmt.Errorf("acmes: decode audit record failed, %v", decodeErr)
+			return
+		}
+		if filter.Match(record) {
+			records = append(records, record)
+		}
+	}
+	if scanErr := scanner.Err(); scanErr != nil {
+		err = fmt.Errorf("acmes: read audit records failed, %v", scanErr)
+		return
+	}
+	return
+}
+
+type storeLog struct {
+	stores store.Store
+}
+
+func (l *storeLog) Append(ctx context.Context, record *Record) (err error) {
+	line, encodeErr := encode(record)
+	if encodeErr != nil {
+		err = encodeErr
+		return
+	}
+	err = l.stores.AppendAudit(ctx, line)
+	return
+}
+
+func (l *storeLog) Query(ctx context.Context, filter Filter) (records []*Record, err error) {
+	reader, has, readErr := l.stores.ReadAudit(ctx)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if !has {
+		return
+	}
+	defer reader.Close()
+	records, err = decode(reader, filter)
+	return
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+	"time"
+)
+
+var Command = &cli.Command{
+	Name:        "audit",
+	Usage:       "audit --audit {store|file:///some_path/audit.jsonl} --store {file:///some_dir_path} --since 24h --domain {domain} --client {client} --operation {operation} --result {result}",
+	Description: "query audit records of certificate operations",
+	ArgsUsage:   "",
+	Category:    "",
+	Action: func(c *cli.Context) (err error) {
+		filter := Filter{
+			Client:    strings.TrimSpace(c.String("client")),
+			Domain:    strings.TrimSpace(c.String("domain")),
+			Operation: strings.TrimSpace(c.String("operation")),
+			Result:    strings.TrimSpace(c.String("result")),
+		}
+		filter.Since, err = parseTime(c.String("since"))
+		if err != nil {
+			return
+		}
+		filter.Until, err = parseTime(c.String("until"))
+		if err != nil {
+			return
+		}
+		target := strings.TrimSpace(c.String("audit"))
+		var stores store.Store
+		if target == "store" {
+			stores, err = store.New(strings.TrimSpace(c.String("store")))
+			if err != nil {
+				return
+			}
+		}
+		log, logErr := New(target, stores)
+		if logErr != nil {
+			err = logErr
+			return
+		}
+		records, queryErr := log.Query(context.TODO(), filter)
+		if queryErr != nil {
+			err = queryErr
+			return
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		for _, record := range records {
+			err = encoder.Encode(record)
+			if err != nil {
+				return
+			}
+		}
+		return
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Required: true,
+			Name:     "audit",
+			Value:    "",
+			Usage:    "audit log, store or file:///some_path/audit.jsonl",
+			EnvVars:  []string{"ACMES_AUDIT"},
+		},
+		&cli.StringFlag{
+			Name:    "store",
+			Value:   "",
+			Usage:   "store for certs, required when audit is store",
+			EnvVars: []string{"ACMES_STORE"},
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Value: "",
+			Usage: "records since the time, RFC3339 time or duration ago such as 24h",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Value: "",
+			Usage: "records until the time, RFC3339 time or duration ago such as 1h",
+		},
+		&cli.StringFlag{
+			Name:  "client",
+			Value: "",
+			Usage: "client common name or serial",
+		},
+		&cli.StringFlag{
+			Name:  "domain",
+			Value: "",
+			Usage: "domain of certificate",
+		},
+		&cli.StringFlag{
+			Name:  "operation",
+			Value: "",
+			Usage: "operation, obtain, renew or revoke",
+		},
+		&cli.StringFlag{
+			Name:  "result",
+			Value: "",
+			Usage: "result, succeed or failed",
+		},
+	},
+}
+
+func parseTime(v string) (t time.Time, err error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return
+	}
+	d, parseDurationErr := time.ParseDuration(v)
+	if parseDurationErr == nil {
+		t = time.Now().Add(-d)
+		return
+	}
+	t, err = time.Parse(time.RFC3339, v)
+	if err != nil {
+		err = fmt.Errorf("acmes: %s is neither RFC3339 time nor duration", v)
+		return
+	}
+	return
+}
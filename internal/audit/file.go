@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+func newFileLog(path string) (v *fileLog, err error) {
+	path = strings.TrimSpace(path)
+	if path == "" {
+		err = fmt.Errorf("acmes: new audit file log failed for path is empty")
+		return
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		err = fmt.Errorf("acmes: new audit file log failed for path is invalid")
+		return
+	}
+	v = &fileLog{
+		mutex: sync.Mutex{},
+		path:  path,
+	}
+	return
+}
+
+type fileLog struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func (l *fileLog) Append(_ context.Context, record *Record) (err error) {
+	line, encodeErr := encode(record)
+	if encodeErr != nil {
+		err = encodeErr
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	file, openErr := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if openErr != nil {
+		err = fmt.Errorf("acmes: append audit record failed, %v", openErr)
+		return
+	}
+	_, writeErr := file.Write(line)
+	closeErr := file.Close()
+	if writeErr != nil {
+		err = fmt.Errorf("acmes: append audit record failed, %v", writeErr)
+		return
+	}
+	if closeErr != nil {
+		err = fmt.Errorf("acmes: append audit record failed, %v", closeErr)
+		return
+	}
+	return
+}
+
+func (l *fileLog) Query(_ context.Context, filter Filter) (records []*Record, err error) {
+	file, openErr := os.Open(l.path)
+	if openErr != nil {
+		if os.IsNotExist(openErr) {
+			return
+		}
+		err = fmt.Errorf("acmes: query audit records failed, %v", openErr)
+		return
+	}
+	defer file.Close()
+	records, err = decode(file, filter)
+	return
+}
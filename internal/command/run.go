@@ -1,6 +1,11 @@
 package command
 
 import (
+	"github.com/aacfactory/acmes/internal/account"
+	"github.com/aacfactory/acmes/internal/agent"
+	"github.com/aacfactory/acmes/internal/audit"
+	"github.com/aacfactory/acmes/internal/export"
+	"github.com/aacfactory/acmes/internal/preflight"
 	"github.com/aacfactory/acmes/internal/server"
 	"github.com/aacfactory/acmes/internal/ssl"
 	"github.com/urfave/cli/v2"
@@ -39,6 +44,11 @@ func Run() error {
 		Commands: []*cli.Command{
 			ssl.Command,
 			server.Command,
+			audit.Command,
+			preflight.Command,
+			account.Command,
+			export.Command,
+			agent.Command,
 		},
 		Authors: []*cli.Author{
 			{
@@ -0,0 +1,111 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+)
+
+var Command = &cli.Command{
+	Name:        "export",
+	Usage:       "export --store {file:///some_dir_path} --email {email} --format {pem|der|pkcs12|secret} --out {file_path} {domain}",
+	Description: "export a stored certificate as combined pem, der, pkcs#12 or a kubernetes tls secret",
+	ArgsUsage:   "{domain}",
+	Category:    "",
+	Action: func(c *cli.Context) (err error) {
+		if strings.TrimSpace(c.Args().First()) == "" {
+			err = fmt.Errorf("acmes: export failed, domain is required")
+			return
+		}
+		domain, domainErr := store.NormalizeDomain(c.Args().First())
+		if domainErr != nil {
+			err = fmt.Errorf("acmes: export failed, %v", domainErr)
+			return
+		}
+		stores, storeErr := store.New(strings.TrimSpace(c.String("store")))
+		if storeErr != nil {
+			err = storeErr
+			return
+		}
+		ctx := context.TODO()
+		defer func() {
+			_ = stores.Close(ctx)
+		}()
+		email := strings.TrimSpace(c.String("email"))
+		cert, has, getErr := stores.GetUserCertificate(ctx, email, domain)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		if !has {
+			err = fmt.Errorf("acmes: export failed, certificate of %s was not obtained by %s", domain, email)
+			return
+		}
+		content, _, encodeErr := Encode(domain, cert, Options{
+			Format:    c.String("format"),
+			Password:  c.String("password"),
+			Name:      c.String("name"),
+			Namespace: c.String("namespace"),
+		})
+		if encodeErr != nil {
+			err = encodeErr
+			return
+		}
+		out := strings.TrimSpace(c.String("out"))
+		if out == "" {
+			_, err = os.Stdout.Write(content)
+			return
+		}
+		err = os.WriteFile(out, content, 0600)
+		if err != nil {
+			err = fmt.Errorf("acmes: export failed, %v", err)
+			return
+		}
+		return
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Required: true,
+			Name:     "store",
+			Value:    "",
+			Usage:    "store for certs",
+			EnvVars:  []string{"ACMES_STORE"},
+		},
+		&cli.StringFlag{
+			Required: true,
+			Name:     "email",
+			Value:    "",
+			Usage:    "email of the acme account which obtained the certificate",
+			EnvVars:  []string{"ACMES_EMAIL"},
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: FormatPEM,
+			Usage: "pem (chain and key), der (leaf), pkcs12 or secret (kubernetes.io/tls yaml)",
+		},
+		&cli.StringFlag{
+			Name:    "password",
+			Value:   "",
+			Usage:   "password of the pkcs12 keystore",
+			EnvVars: []string{"ACMES_EXPORT_PASSWORD"},
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Value: "",
+			Usage: "name of the secret, default is derived from the domain",
+		},
+		&cli.StringFlag{
+			Name:  "namespace",
+			Value: "",
+			Usage: "namespace of the secret",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Value: "",
+			Usage: "file to write, it is written with mode 0600, default is stdout",
+		},
+	},
+}
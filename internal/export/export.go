@@ -0,0 +1,163 @@
+package export
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"gopkg.in/yaml.v3"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+)
+
+const (
+	// FormatPEM is the chain followed by the key in pem, such as for haproxy.
+	FormatPEM = "pem"
+	// FormatDER is the leaf in der, it has no key.
+	FormatDER = "der"
+	// FormatPKCS12 is the key and the chain in a pkcs#12 keystore encrypted by the password, java reads it as a keystore.
+	FormatPKCS12 = "pkcs12"
+	// FormatSecret is a kubernetes.io/tls secret in yaml.
+	FormatSecret = "secret"
+)
+
+// Formats are the names of supported formats.
+var Formats = []string{FormatPEM, FormatDER, FormatPKCS12, FormatSecret}
+
+var contentTypes = map[string]string{
+	FormatPEM:    "application/x-pem-file",
+	FormatDER:    "application/pkix-cert",
+	FormatPKCS12: "application/x-pkcs12",
+	FormatSecret: "application/yaml",
+}
+
+type Options struct {
+	// Format is one of Formats.
+	Format string
+	// Password encrypts the pkcs#12 keystore, it is required by FormatPKCS12.
+	Password string
+	// Name is the name of the secret, it is derived from the domain when it is empty, such as wildcard.foo.com of *.foo.com.
+	Name string
+	// Namespace is the namespace of the secret, it is omitted when it is empty.
+	Namespace string
+}
+
+// IsFormat reports whether format is supported, it is case insensitive like Encode.
+func IsFormat(format string) bool {
+	_, has := contentTypes[strings.ToLower(strings.TrimSpace(format))]
+	return has
+}
+
+// Encode encodes cert of domain in the format of options, contentType is the media type of content.
+func Encode(domain string, cert *store.Certificate, options Options) (content []byte, contentType string, err error) {
+	format := strings.ToLower(strings.TrimSpace(options.Format))
+	contentType, has := contentTypes[format]
+	if !has {
+		err = fmt.Errorf("acmes: export failed, format %s is not supported, it is one of %s", options.Format, strings.Join(Formats, ", "))
+		return
+	}
+	chain := cert.Chain
+	if len(chain) == 0 {
+		chain = cert.Cert
+	}
+	switch format {
+	case FormatPEM:
+		content = append(append(make([]byte, 0, len(chain)+len(cert.Key)), chain...), cert.Key...)
+	case FormatDER:
+		block, _ := pem.Decode(chain)
+		if block == nil {
+			err = fmt.Errorf("acmes: export failed, certificate of %s is not pem", domain)
+			return
+		}
+		content = block.Bytes
+	case FormatPKCS12:
+		content, err = encodePKCS12(chain, cert.Key, options.Password)
+		if err != nil {
+			err = fmt.Errorf("acmes: export failed, %v", err)
+			return
+		}
+	case FormatSecret:
+		content, err = encodeSecret(domain, chain, cert.Key, options)
+		if err != nil {
+			err = fmt.Errorf("acmes: export failed, %v", err)
+			return
+		}
+	}
+	return
+}
+
+func encodePKCS12(chain []byte, keyPEM []byte, password string) (content []byte, err error) {
+	if password == "" {
+		err = fmt.Errorf("password is required by pkcs12")
+		return
+	}
+	key, keyErr := certcrypto.ParsePEMPrivateKey(keyPEM)
+	if keyErr != nil {
+		err = keyErr
+		return
+	}
+	certificates, parseErr := certcrypto.ParsePEMBundle(chain)
+	if parseErr != nil {
+		err = parseErr
+		return
+	}
+	var intermediates []*x509.Certificate
+	if len(certificates) > 1 {
+		intermediates = certificates[1:]
+	}
+	content, err = pkcs12.Modern.Encode(key, certificates[0], intermediates, password)
+	return
+}
+
+type secret struct {
+	APIVersion string         `yaml:"apiVersion"`
+	Kind       string         `yaml:"kind"`
+	Metadata   secretMetadata `yaml:"metadata"`
+	Type       string         `yaml:"type"`
+	Data       secretData     `yaml:"data"`
+}
+
+type secretMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type secretData struct {
+	Cert string `yaml:"tls.crt"`
+	Key  string `yaml:"tls.key"`
+}
+
+func encodeSecret(domain string, chain []byte, key []byte, options Options) (content []byte, err error) {
+	name := strings.TrimSpace(options.Name)
+	if name == "" {
+		name = strings.ToLower(strings.ReplaceAll(domain, "*", "wildcard"))
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 4096))
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	err = encoder.Encode(&secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: secretMetadata{
+			Name:      name,
+			Namespace: strings.TrimSpace(options.Namespace),
+		},
+		Type: "kubernetes.io/tls",
+		Data: secretData{
+			Cert: base64.StdEncoding.EncodeToString(chain),
+			Key:  base64.StdEncoding.EncodeToString(key),
+		},
+	})
+	if err != nil {
+		return
+	}
+	err = encoder.Close()
+	if err != nil {
+		return
+	}
+	content = buf.Bytes()
+	return
+}
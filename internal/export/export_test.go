@@ -0,0 +1,98 @@
+package export
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/aacfactory/acmes/internal/store"
+	"gopkg.in/yaml.v3"
+	"math/big"
+	"software.sslmate.com/src/go-pkcs12"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) *store.Certificate {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, caErr := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if caErr != nil {
+		t.Fatal(caErr)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, leafErr := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "*.foo.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(0, 3, 0),
+		DNSNames:     []string{"*.foo.com"},
+	}, caTemplate, &key.PublicKey, caKey)
+	if leafErr != nil {
+		t.Fatal(leafErr)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	return store.NewCertificate(nil, chain, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), time.Now().AddDate(0, 3, 0))
+}
+
+func TestEncode(t *testing.T) {
+	cert := testCertificate(t)
+
+	combined, _, pemErr := Encode("*.foo.com", cert, Options{Format: FormatPEM})
+	if pemErr != nil || !bytes.HasPrefix(combined, cert.Chain) || !bytes.HasSuffix(combined, cert.Key) {
+		t.Fatalf("pem is invalid, %v", pemErr)
+	}
+
+	der, _, derErr := Encode("*.foo.com", cert, Options{Format: FormatDER})
+	if derErr != nil {
+		t.Fatal(derErr)
+	}
+	if leaf, parseErr := x509.ParseCertificate(der); parseErr != nil || leaf.Subject.CommonName != "*.foo.com" {
+		t.Fatalf("der is not the leaf, %v", parseErr)
+	}
+
+	if _, _, err := Encode("*.foo.com", cert, Options{Format: FormatPKCS12}); err == nil {
+		t.Fatal("pkcs12 without password is exported")
+	}
+	pfx, _, pfxErr := Encode("*.foo.com", cert, Options{Format: FormatPKCS12, Password: "secret"})
+	if pfxErr != nil {
+		t.Fatal(pfxErr)
+	}
+	_, leaf, intermediates, decodeErr := pkcs12.DecodeChain(pfx, "secret")
+	if decodeErr != nil || leaf.Subject.CommonName != "*.foo.com" || len(intermediates) != 1 {
+		t.Fatalf("pkcs12 is invalid, %v", decodeErr)
+	}
+
+	content, contentType, secretErr := Encode("*.foo.com", cert, Options{Format: FormatSecret, Namespace: "web"})
+	if secretErr != nil || contentType != "application/yaml" {
+		t.Fatal(secretErr)
+	}
+	decoded := secret{}
+	if err := yaml.Unmarshal(content, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	crt, _ := base64.StdEncoding.DecodeString(decoded.Data.Cert)
+	if decoded.Type != "kubernetes.io/tls" || decoded.Metadata.Name != "wildcard.foo.com" || decoded.Metadata.Namespace != "web" || !bytes.Equal(crt, cert.Chain) {
+		t.Fatalf("secret is invalid\n%s", content)
+	}
+
+	if _, _, err := Encode("*.foo.com", cert, Options{Format: "jks"}); err == nil {
+		t.Fatal("unknown format is exported")
+	}
+	if !IsFormat(" PEM") || IsFormat("jks") {
+		t.Fatal("format is not checked like Encode")
+	}
+}
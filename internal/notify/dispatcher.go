@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/aacfactory/logs"
+	"sync"
+	"time"
+)
+
+func NewDispatcher(log logs.Logger, notifiers []Notifier, cooldown time.Duration) *Dispatcher {
+	if cooldown < 0 {
+		cooldown = 0
+	}
+	return &Dispatcher{
+		log:       log,
+		notifiers: notifiers,
+		cooldown:  cooldown,
+		mutex:     sync.Mutex{},
+		sent:      make(map[string]sent),
+	}
+}
+
+type sent struct {
+	at          time.Time
+	fingerprint string
+}
+
+// Dispatcher sends events to all notifiers in background.
+// Events of the same kind for the same domain are sent at most once per cooldown,
+// and issued or expiring events of the same certificate are sent only once.
+type Dispatcher struct {
+	log       logs.Logger
+	notifiers []Notifier
+	cooldown  time.Duration
+	mutex     sync.Mutex
+	sent      map[string]sent
+	sending   sync.WaitGroup
+}
+
+// Reset replaces notifiers and cooldown, sent history is kept.
+func (d *Dispatcher) Reset(notifiers []Notifier, cooldown time.Duration) {
+	if cooldown < 0 {
+		cooldown = 0
+	}
+	d.mutex.Lock()
+	d.notifiers = notifiers
+	d.cooldown = cooldown
+	d.mutex.Unlock()
+}
+
+func (d *Dispatcher) Notify(event *Event) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	notifiers := d.notifiers
+	d.mutex.Unlock()
+	if len(notifiers) == 0 {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if !d.accept(event) {
+		if d.log.DebugEnabled() {
+			d.log.Debug().Message(fmt.Sprintf("acmes: %s notification of %s was suppressed", event.Kind, event.Domain))
+		}
+		return
+	}
+	for _, notifier := range notifiers {
+		d.sending.Add(1)
+		go func(notifier Notifier) {
+			defer d.sending.Done()
+			ctx, cancel := context.WithTimeout(context.TODO(), 30*time.Second)
+			defer cancel()
+			err := notifier.Notify(ctx, event)
+			if err != nil {
+				d.log.Warn().Cause(err).Message(fmt.Sprintf("acmes: send %s notification of %s by %s failed", event.Kind, event.Domain, notifier.Name()))
+			}
+		}(notifier)
+	}
+}
+
+// Close waits for notifications which are being sent until ctx is done.
+func (d *Dispatcher) Close(ctx context.Context) (err error) {
+	done := make(chan struct{})
+	go func() {
+		d.sending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("acmes: close notification dispatcher failed, %v", ctx.Err())
+	}
+	return
+}
+
+func (d *Dispatcher) accept(event *Event) (ok bool) {
+	key := fmt.Sprintf("%s:%s:%s", event.Kind, event.Email, event.Domain)
+	fingerprint := ""
+	if event.Kind == Issued || event.Kind == Expiring {
+		fingerprint = event.NotAfter.Format(time.RFC3339)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	last, has := d.sent[key]
+	if has {
+		if fingerprint != "" && last.fingerprint == fingerprint {
+			return
+		}
+		if event.Time.Sub(last.at) < d.cooldown {
+			return
+		}
+	}
+	d.sent[key] = sent{
+		at:          event.Time,
+		fingerprint: fingerprint,
+	}
+	ok = true
+	return
+}
@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/aacfactory/logs"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mutex  sync.Mutex
+	events []Event
+}
+
+func (r *recorder) Name() string {
+	return "recorder"
+}
+
+func (r *recorder) Notify(_ context.Context, event *Event) (err error) {
+	r.mutex.Lock()
+	r.events = append(r.events, *event)
+	r.mutex.Unlock()
+	return
+}
+
+func TestDispatcher_Notify(t *testing.T) {
+	log, logErr := logs.New(logs.WithConsoleWriterFormatter(logs.TextFormatter), logs.WithLevel(logs.ErrorLevel))
+	if logErr != nil {
+		t.Fatal(logErr)
+	}
+	r := &recorder{}
+	d := NewDispatcher(log, []Notifier{r}, time.Hour)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := now.AddDate(0, 3, 0)
+	events := []struct {
+		name  string
+		event Event
+		sent  bool
+	}{
+		{"first issued", Event{Kind: Issued, Domain: "www.foo.com", NotAfter: notAfter, Time: now}, true},
+		{"same certificate after cooldown", Event{Kind: Issued, Domain: "www.foo.com", NotAfter: notAfter, Time: now.Add(2 * time.Hour)}, false},
+		{"new certificate within cooldown", Event{Kind: Issued, Domain: "www.foo.com", NotAfter: notAfter.Add(time.Hour), Time: now.Add(time.Minute)}, false},
+		{"new certificate after cooldown", Event{Kind: Issued, Domain: "www.foo.com", NotAfter: notAfter.Add(time.Hour), Time: now.Add(3 * time.Hour)}, true},
+		{"other domain", Event{Kind: Issued, Domain: "api.foo.com", NotAfter: notAfter, Time: now}, true},
+		{"first failure", Event{Kind: RenewFailed, Domain: "www.foo.com", Cause: "timeout", Time: now}, true},
+		{"failure within cooldown", Event{Kind: RenewFailed, Domain: "www.foo.com", Cause: "timeout", Time: now.Add(59 * time.Minute)}, false},
+		{"failure when cooldown expired", Event{Kind: RenewFailed, Domain: "www.foo.com", Cause: "timeout", Time: now.Add(time.Hour)}, true},
+	}
+	for _, e := range events {
+		before := len(r.events)
+		event := e.event
+		d.Notify(&event)
+		if err := d.Close(context.TODO()); err != nil {
+			t.Fatal(err)
+		}
+		if sent := len(r.events) > before; sent != e.sent {
+			t.Errorf("%s: sent is %v, want %v", e.name, sent, e.sent)
+		}
+	}
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	var received Event
+	var contentType string
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		contentType = request.Header.Get("Content-Type")
+		if err := json.NewDecoder(request.Body).Decode(&received); err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	notifier, newErr := New("webhook+" + server.URL + "/hook")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	event := &Event{Kind: RenewFailed, Email: "foo@bar.com", Domain: "www.foo.com", Cause: "timeout", Time: time.Now().Truncate(time.Second)}
+	if err := notifier.Notify(context.TODO(), event); err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/json" || received.Kind != RenewFailed || received.Email != event.Email ||
+		received.Domain != event.Domain || received.Cause != event.Cause || !received.Time.Equal(event.Time) {
+		t.Fatalf("payload is %+v, %s", received, contentType)
+	}
+}
+
+func TestSlack_Notify(t *testing.T) {
+	received := map[string]string{}
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		_ = json.NewDecoder(request.Body).Decode(&received)
+		writer.WriteHeader(status)
+	}))
+	defer server.Close()
+	notifier, newErr := New("slack+" + server.URL + "/services/x")
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	event := &Event{Kind: Expiring, Domain: "www.foo.com", NotAfter: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	if err := notifier.Notify(context.TODO(), event); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 1 || received["text"] != event.String() {
+		t.Fatalf("payload is %v", received)
+	}
+	status = http.StatusInternalServerError
+	if err := notifier.Notify(context.TODO(), event); err == nil {
+		t.Fatal("failed response is not an error")
+	}
+}
+
+// serveSMTP accepts one plain smtp session without extensions, and returns its envelope and data.
+func serveSMTP(ln net.Listener) <-chan []string {
+	lines := make(chan []string, 1)
+	go func() {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			close(lines)
+			return
+		}
+		defer conn.Close()
+		received := make([]string, 0, 8)
+		reader := bufio.NewReader(conn)
+		_, _ = io.WriteString(conn, "220 localhost\r\n")
+		data := false
+		for {
+			line, readErr := reader.ReadString('\n')
+			if readErr != nil {
+				break
+			}
+			line = strings.TrimRight(line, "\r\n")
+			if data {
+				if line == "." {
+					data = false
+					_, _ = io.WriteString(conn, "250 ok\r\n")
+					continue
+				}
+				received = append(received, line)
+				continue
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO", "HELO":
+				_, _ = io.WriteString(conn, "250 localhost\r\n")
+			case "DATA":
+				data = true
+				_, _ = io.WriteString(conn, "354 go ahead\r\n")
+			case "QUIT":
+				_, _ = io.WriteString(conn, "221 bye\r\n")
+				lines <- received
+				return
+			default:
+				received = append(received, line)
+				_, _ = io.WriteString(conn, "250 ok\r\n")
+			}
+		}
+		lines <- received
+	}()
+	return lines
+}
+
+func TestSMTP_Notify(t *testing.T) {
+	ln, listenErr := net.Listen("tcp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	defer ln.Close()
+	lines := serveSMTP(ln)
+	notifier, newErr := New("smtp://" + ln.Addr().String() + "?from=" + url.QueryEscape("acmes@foo.com") + "&to=" + url.QueryEscape("a@foo.com,b@foo.com"))
+	if newErr != nil {
+		t.Fatal(newErr)
+	}
+	event := &Event{Kind: Issued, Domain: "www.foo.com", NotAfter: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Time: time.Now()}
+	if err := notifier.Notify(context.TODO(), event); err != nil {
+		t.Fatal(err)
+	}
+	received := strings.Join(<-lines, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<acmes@foo.com>",
+		"RCPT TO:<a@foo.com>",
+		"RCPT TO:<b@foo.com>",
+		"To: a@foo.com, b@foo.com",
+		"Subject: [acmes] issued www.foo.com",
+		event.String(),
+	} {
+		if !strings.Contains(received, want) {
+			t.Errorf("%q is not sent\n%s", want, received)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func newSMTP(u *url.URL) (v *SMTP, err error) {
+	host := u.Hostname()
+	if host == "" {
+		err = fmt.Errorf("acmes: smtp notifier host is empty")
+		return
+	}
+	port := u.Port()
+	if port == "" {
+		port = "587"
+		if u.Scheme == "smtps" {
+			port = "465"
+		}
+	}
+	from := strings.TrimSpace(u.Query().Get("from"))
+	if from == "" {
+		err = fmt.Errorf("acmes: smtp notifier from is empty")
+		return
+	}
+	to := make([]string, 0, 1)
+	for _, item := range strings.Split(u.Query().Get("to"), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			to = append(to, item)
+		}
+	}
+	if len(to) == 0 {
+		err = fmt.Errorf("acmes: smtp notifier to is empty")
+		return
+	}
+	var auth smtp.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = smtp.PlainAuth("", u.User.Username(), password, host)
+	}
+	v = &SMTP{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		implicit: u.Scheme == "smtps",
+		auth:     auth,
+		from:     from,
+		to:       to,
+	}
+	return
+}
+
+type SMTP struct {
+	addr     string
+	host     string
+	implicit bool
+	auth     smtp.Auth
+	from     string
+	to       []string
+}
+
+func (s *SMTP) Name() string {
+	return "smtp"
+}
+
+func (s *SMTP) Notify(ctx context.Context, event *Event) (err error) {
+	err = s.send(ctx, event)
+	if err != nil {
+		err = fmt.Errorf("acmes: smtp notify failed, %v", err)
+		return
+	}
+	return
+}
+
+func (s *SMTP) send(ctx context.Context, event *Event) (err error) {
+	dialer := &net.Dialer{}
+	var conn net.Conn
+	if s.implicit {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: &tls.Config{ServerName: s.host}}).DialContext(ctx, "tcp", s.addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.addr)
+	}
+	if err != nil {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, clientErr := smtp.NewClient(conn, s.host)
+	if clientErr != nil {
+		_ = conn.Close()
+		err = clientErr
+		return
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok && !s.implicit {
+		err = client.StartTLS(&tls.Config{ServerName: s.host})
+		if err != nil {
+			return
+		}
+	}
+	if s.auth != nil {
+		err = client.Auth(s.auth)
+		if err != nil {
+			return
+		}
+	}
+	err = client.Mail(s.from)
+	if err != nil {
+		return
+	}
+	for _, to := range s.to {
+		err = client.Rcpt(to)
+		if err != nil {
+			return
+		}
+	}
+	w, dataErr := client.Data()
+	if dataErr != nil {
+		err = dataErr
+		return
+	}
+	msg := bytes.NewBuffer(nil)
+	_, _ = fmt.Fprintf(msg, "From: %s\r\n", s.from)
+	_, _ = fmt.Fprintf(msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	_, _ = fmt.Fprintf(msg, "Subject: [acmes] %s %s\r\n", event.Kind, event.Domain)
+	_, _ = fmt.Fprintf(msg, "Date: %s\r\n", event.Time.Format(time.RFC1123Z))
+	_, _ = fmt.Fprintf(msg, "Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	_, _ = fmt.Fprintf(msg, "%s\r\n", event.String())
+	_, err = w.Write(msg.Bytes())
+	if err != nil {
+		return
+	}
+	err = w.Close()
+	if err != nil {
+		return
+	}
+	err = client.Quit()
+	return
+}
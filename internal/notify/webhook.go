@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type Webhook struct {
+	url string
+}
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+func (w *Webhook) Notify(ctx context.Context, event *Event) (err error) {
+	body, encodeErr := json.Marshal(event)
+	if encodeErr != nil {
+		err = fmt.Errorf("acmes: webhook notify failed, %v", encodeErr)
+		return
+	}
+	err = post(ctx, w.url, body)
+	if err != nil {
+		err = fmt.Errorf("acmes: webhook notify failed, %v", err)
+		return
+	}
+	return
+}
+
+type Slack struct {
+	url string
+}
+
+func (s *Slack) Name() string {
+	return "slack"
+}
+
+func (s *Slack) Notify(ctx context.Context, event *Event) (err error) {
+	body, encodeErr := json.Marshal(map[string]string{
+		"text": event.String(),
+	})
+	if encodeErr != nil {
+		err = fmt.Errorf("acmes: slack notify failed, %v", encodeErr)
+		return
+	}
+	err = post(ctx, s.url, body)
+	if err != nil {
+		err = fmt.Errorf("acmes: slack notify failed, %v", err)
+		return
+	}
+	return
+}
+
+func post(ctx context.Context, url string, body []byte) (err error) {
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if requestErr != nil {
+		err = requestErr
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+	resp, postErr := http.DefaultClient.Do(request)
+	if postErr != nil {
+		err = postErr
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		content, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("%s responded %s, %s", url, resp.Status, string(content))
+		return
+	}
+	return
+}
@@ -0,0 +1,76 @@
+package preflight
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/urfave/cli/v2"
+	"os"
+	"strings"
+)
+
+var Command = &cli.Command{
+	Name:        "check",
+	Usage:       "check --provider {provider} --resolver 8.8.8.8:53 --caa-identity letsencrypt.org --nameserver {nameserver} {domain}",
+	Description: "check caa records and authoritative nameservers of domain before ordering",
+	ArgsUsage:   "{domain}",
+	Category:    "",
+	Action: func(c *cli.Context) (err error) {
+		domain := strings.TrimSpace(c.Args().First())
+		if domain == "" {
+			err = fmt.Errorf("acmes: check failed, domain is required")
+			return
+		}
+		checker, checkerErr := New(Options{
+			Resolvers:     c.StringSlice("resolver"),
+			CAAIdentities: c.StringSlice("caa-identity"),
+			Provider:      c.String("provider"),
+			Nameservers:   c.StringSlice("nameserver"),
+			Timeout:       c.Duration("timeout"),
+		})
+		if checkerErr != nil {
+			err = checkerErr
+			return
+		}
+		report := checker.Check(context.TODO(), domain)
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			return
+		}
+		if reportErr := report.Err(); reportErr != nil {
+			err = cli.Exit(fmt.Sprintf("acmes: check %s failed, %v", report.Domain, reportErr), 1)
+			return
+		}
+		return
+	},
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "provider",
+			Value:   "",
+			Usage:   "dns provider for acme",
+			EnvVars: []string{"ACMES_DNS_PROVIDER"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "resolver",
+			Usage:   "recursive nameserver such as 8.8.8.8:53, it can be repeated, nameservers of /etc/resolv.conf are used when it is absent",
+			EnvVars: []string{"ACMES_DNS_RESOLVERS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "caa-identity",
+			Usage:   "identity of the ca in caa records, it can be repeated, default is letsencrypt.org",
+			EnvVars: []string{"ACMES_CAA_IDENTITIES"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "nameserver",
+			Usage:   "substring of authoritative nameservers of the provider such as awsdns-, it can be repeated, well-known ones of the provider are used when it is absent",
+			EnvVars: []string{"ACMES_DNS_NAMESERVERS"},
+		},
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Value: 0,
+			Usage: "timeout of each dns query, default is 5s",
+		},
+	},
+}
@@ -0,0 +1,385 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+// DefaultCAAIdentity is the caa identity of let's encrypt, which is the ca of acmes.
+const DefaultCAAIdentity = "letsencrypt.org"
+
+// providerNameservers are substrings of authoritative nameservers of well-known lego providers.
+var providerNameservers = map[string][]string{
+	"alidns":       {"alidns.com", "hichina.com"},
+	"azure":        {"azure-dns."},
+	"azuredns":     {"azure-dns."},
+	"cloudflare":   {"ns.cloudflare.com"},
+	"digitalocean": {"digitalocean.com"},
+	"dnspod":       {"dnspod.net", "dnsv2.com", "dnsv3.com", "dnsv4.com", "dnsv5.com"},
+	"gandiv5":      {"gandi.net"},
+	"gcloud":       {"googledomains.com"},
+	"godaddy":      {"domaincontrol.com"},
+	"hetzner":      {"hetzner.com", "your-dns.com"},
+	"huaweicloud":  {"hwclouds-dns."},
+	"linode":       {"linode.com"},
+	"namecheap":    {"registrar-servers.com"},
+	"namesilo":     {"dnsowl.com"},
+	"ovh":          {"ovh.net"},
+	"route53":      {"awsdns-"},
+	"tencentcloud": {"dnspod.net", "dnsv2.com", "dnsv3.com", "dnsv4.com", "dnsv5.com"},
+	"vultr":        {"vultr.com"},
+}
+
+type Options struct {
+	// Resolvers are recursive nameservers, such as 8.8.8.8:53, nameservers of /etc/resolv.conf are used when it is empty.
+	Resolvers []string
+	// CAAIdentities are identities of the ca in caa records, DefaultCAAIdentity is used when it is empty.
+	CAAIdentities []string
+	// Provider is the name of the lego dns provider.
+	Provider string
+	// Nameservers are substrings of authoritative nameservers of zones served by the provider,
+	// well-known ones of Provider are used when it is empty.
+	Nameservers []string
+	// Alias returns the domain whose _acme-challenge record is written for domain, and the provider which writes it,
+	// the domain itself and Provider are used when it is nil.
+	Alias func(domain string) (name string, provider string)
+	// Timeout of each dns query, default is 5 seconds.
+	Timeout time.Duration
+}
+
+const (
+	CheckCAA         = "caa"
+	CheckNameservers = "nameservers"
+)
+
+type Result struct {
+	Check   string `json:"check"`
+	Ok      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Message string `json:"message"`
+}
+
+type Report struct {
+	Domain  string    `json:"domain"`
+	Results []*Result `json:"results"`
+}
+
+func (report *Report) Ok() bool {
+	for _, result := range report.Results {
+		if !result.Ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Err returns messages of failed checks, or nil when all are ok.
+func (report *Report) Err() error {
+	messages := make([]string, 0, 1)
+	for _, result := range report.Results {
+		if !result.Ok {
+			messages = append(messages, result.Message)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// Checker checks that a domain can be issued by dns-01 before ordering, so that orders fail fast with actionable causes.
+type Checker struct {
+	resolvers   []string
+	identities  []string
+	provider    string
+	nameservers []string
+	alias       func(domain string) (name string, provider string)
+	client      *dns.Client
+}
+
+func New(options Options) (checker *Checker, err error) {
+	resolvers := dns01.ParseNameservers(options.Resolvers)
+	if len(resolvers) == 0 {
+		config, configErr := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if configErr != nil || len(config.Servers) == 0 {
+			err = fmt.Errorf("acmes: create preflight checker failed, no resolvers")
+			return
+		}
+		resolvers = dns01.ParseNameservers(config.Servers)
+	}
+	identities := make([]string, 0, len(options.CAAIdentities))
+	for _, identity := range options.CAAIdentities {
+		identity = strings.ToLower(strings.TrimSpace(identity))
+		if identity != "" {
+			identities = append(identities, identity)
+		}
+	}
+	if len(identities) == 0 {
+		identities = append(identities, DefaultCAAIdentity)
+	}
+	provider := strings.ToLower(strings.TrimSpace(options.Provider))
+	nameservers := make([]string, 0, len(options.Nameservers))
+	for _, nameserver := range options.Nameservers {
+		nameserver = strings.ToLower(strings.TrimSpace(nameserver))
+		if nameserver != "" {
+			nameservers = append(nameservers, nameserver)
+		}
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	checker = &Checker{
+		resolvers:   resolvers,
+		identities:  identities,
+		provider:    provider,
+		nameservers: nameservers,
+		alias:       options.Alias,
+		client: &dns.Client{
+			Timeout: timeout,
+		},
+	}
+	return
+}
+
+// Check runs all checks of domain, failures are in the report rather than an error.
+func (checker *Checker) Check(ctx context.Context, domain string) (report *Report) {
+	domain = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	report = &Report{
+		Domain: domain,
+		Results: []*Result{
+			checker.checkCAA(ctx, domain),
+			checker.checkNameservers(ctx, domain),
+		},
+	}
+	return
+}
+
+// checkCAA climbs from domain to the top level domain, the first name which has caa records decides whether the ca may issue.
+func (checker *Checker) checkCAA(ctx context.Context, domain string) (result *Result) {
+	result = &Result{
+		Check: CheckCAA,
+	}
+	wildcard := strings.HasPrefix(domain, "*.")
+	name := strings.TrimPrefix(domain, "*.")
+	for name != "" {
+		msg, queryErr := checker.query(ctx, name, dns.TypeCAA)
+		if queryErr != nil {
+			result.Message = fmt.Sprintf("resolve caa of %s failed, %v", name, queryErr)
+			return
+		}
+		records := make([]*dns.CAA, 0, 1)
+		for _, rr := range msg.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			checker.evaluateCAA(result, name, wildcard, records)
+			return
+		}
+		dot := strings.IndexByte(name, '.')
+		if dot < 0 {
+			break
+		}
+		name = name[dot+1:]
+	}
+	result.Ok = true
+	result.Message = fmt.Sprintf("no caa records of %s, any ca may issue", domain)
+	return
+}
+
+func (checker *Checker) evaluateCAA(result *Result, name string, wildcard bool, records []*dns.CAA) {
+	issues := make([]string, 0, 1)
+	wilds := make([]string, 0, 1)
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issues = append(issues, record.Value)
+		case "issuewild":
+			wilds = append(wilds, record.Value)
+		case "iodef", "contactemail", "contactphone":
+		default:
+			if record.Flag&128 != 0 {
+				result.Message = fmt.Sprintf("caa of %s has critical tag %s which is unknown, no ca may issue, remove it", name, record.Tag)
+				return
+			}
+		}
+	}
+	tag := "issue"
+	values := issues
+	if wildcard && len(wilds) > 0 {
+		tag = "issuewild"
+		values = wilds
+	}
+	if len(values) == 0 {
+		result.Ok = true
+		result.Message = fmt.Sprintf("caa of %s does not restrict issuance", name)
+		return
+	}
+	allowed := make([]string, 0, len(values))
+	for _, value := range values {
+		identity := strings.ToLower(strings.TrimSpace(strings.SplitN(value, ";", 2)[0]))
+		if identity == "" {
+			continue
+		}
+		for _, expected := range checker.identities {
+			if identity == expected {
+				result.Ok = true
+				result.Message = fmt.Sprintf("caa of %s allows %s", name, identity)
+				return
+			}
+		}
+		allowed = append(allowed, identity)
+	}
+	if len(allowed) == 0 {
+		result.Message = fmt.Sprintf("caa of %s forbids any ca to issue, add `%s. CAA 0 %s \"%s\"`", name, name, tag, checker.identities[0])
+		return
+	}
+	result.Message = fmt.Sprintf("caa of %s allows %s but not %s, add `%s. CAA 0 %s \"%s\"`",
+		name, strings.Join(allowed, ", "), checker.identities[0], name, tag, checker.identities[0])
+}
+
+// checkNameservers finds the zone of the challenge record of domain, following its alias and cnames,
+// and checks that it is served by the provider, otherwise the provider creates the record in a zone which the ca never queries.
+func (checker *Checker) checkNameservers(ctx context.Context, domain string) (result *Result) {
+	result = &Result{
+		Check: CheckNameservers,
+	}
+	name, provider := strings.TrimPrefix(domain, "*."), checker.provider
+	if checker.alias != nil {
+		name, provider = checker.alias(name)
+	}
+	fqdn, cnameErr := checker.followCNAME(ctx, dns.Fqdn("_acme-challenge."+name))
+	if cnameErr != nil {
+		result.Message = cnameErr.Error()
+		return
+	}
+	zone, zoneErr := checker.findZone(ctx, fqdn)
+	if zoneErr != nil {
+		result.Message = fmt.Sprintf("find zone of %s failed, %v", fqdn, zoneErr)
+		return
+	}
+	msg, queryErr := checker.query(ctx, zone, dns.TypeNS)
+	if queryErr != nil {
+		result.Message = fmt.Sprintf("resolve nameservers of zone %s failed, %v", zone, queryErr)
+		return
+	}
+	hosts := make([]string, 0, 2)
+	for _, rr := range msg.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			hosts = append(hosts, strings.ToLower(ns.Ns))
+		}
+	}
+	if len(hosts) == 0 {
+		result.Message = fmt.Sprintf("zone %s has no nameservers, check the delegation at the registrar", zone)
+		return
+	}
+	expects := providerNameservers[provider]
+	if provider == checker.provider && len(checker.nameservers) > 0 {
+		expects = checker.nameservers
+	}
+	if len(expects) == 0 {
+		result.Ok = true
+		result.Skipped = true
+		result.Message = fmt.Sprintf("zone %s is served by %s, nameservers of provider %s are unknown, set them to check", zone, strings.Join(hosts, ", "), provider)
+		return
+	}
+	for _, host := range hosts {
+		for _, expected := range expects {
+			if strings.Contains(host, expected) {
+				result.Ok = true
+				result.Message = fmt.Sprintf("zone %s of %s is served by %s", zone, fqdn, strings.Join(hosts, ", "))
+				return
+			}
+		}
+	}
+	result.Message = fmt.Sprintf("zone %s of %s is served by %s, not by nameservers of provider %s (%s), delegate the zone to the provider or set a challenge alias",
+		zone, fqdn, strings.Join(hosts, ", "), provider, strings.Join(expects, ", "))
+	return
+}
+
+// followCNAME returns the last target of cnames of fqdn, which is where the ca reads the challenge record.
+func (checker *Checker) followCNAME(ctx context.Context, fqdn string) (target string, err error) {
+	target = fqdn
+	for i := 0; i < 16; i++ {
+		msg, queryErr := checker.query(ctx, target, dns.TypeCNAME)
+		if queryErr != nil {
+			err = fmt.Errorf("resolve cname of %s failed, %v", target, queryErr)
+			return
+		}
+		next := ""
+		for _, rr := range msg.Answer {
+			if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, target) {
+				next = strings.ToLower(cname.Target)
+			}
+		}
+		if next == "" {
+			return
+		}
+		target = next
+	}
+	err = fmt.Errorf("cnames of %s are too deep", fqdn)
+	return
+}
+
+// findZone walks up fqdn until a name answers its soa, which is the zone of fqdn, it stops when ctx is done.
+// Names which are cnames are skipped, because a cname can not be at the apex of a zone.
+func (checker *Checker) findZone(ctx context.Context, fqdn string) (zone string, err error) {
+	for _, index := range dns.Split(fqdn) {
+		name := fqdn[index:]
+		msg, queryErr := checker.query(ctx, name, dns.TypeSOA)
+		if queryErr != nil {
+			err = fmt.Errorf("resolve soa of %s failed, %v", name, queryErr)
+			return
+		}
+		if msg == nil || msg.Rcode != dns.RcodeSuccess {
+			continue
+		}
+		cname := false
+		for _, rr := range msg.Answer {
+			if _, ok := rr.(*dns.CNAME); ok {
+				cname = true
+			}
+		}
+		if cname {
+			continue
+		}
+		for _, rr := range msg.Answer {
+			if soa, ok := rr.(*dns.SOA); ok {
+				zone = strings.ToLower(soa.Hdr.Name)
+				return
+			}
+		}
+	}
+	err = fmt.Errorf("no soa is found for %s", fqdn)
+	return
+}
+
+// query asks resolvers in turn until one answers, and retries over tcp when the answer is truncated.
+func (checker *Checker) query(ctx context.Context, name string, rtype uint16) (msg *dns.Msg, err error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), rtype)
+	m.SetEdns0(4096, false)
+	for _, resolver := range checker.resolvers {
+		msg, _, err = checker.client.ExchangeContext(ctx, m, resolver)
+		if err == nil && msg.Truncated {
+			tcp := &dns.Client{Net: "tcp", Timeout: checker.client.Timeout}
+			msg, _, err = tcp.ExchangeContext(ctx, m, resolver)
+		}
+		if err != nil {
+			continue
+		}
+		if msg.Rcode != dns.RcodeSuccess && msg.Rcode != dns.RcodeNameError {
+			err = fmt.Errorf("%s answered %s", resolver, dns.RcodeToString[msg.Rcode])
+			continue
+		}
+		return
+	}
+	return
+}
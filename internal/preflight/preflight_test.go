@@ -0,0 +1,155 @@
+package preflight_test
+
+import (
+	"context"
+	"github.com/aacfactory/acmes/internal/preflight"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve answers records of zone by an in-process dns server, and returns its address.
+func serve(t *testing.T, records []string) string {
+	rrs := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		rr, rrErr := dns.NewRR(record)
+		if rrErr != nil {
+			t.Fatal(rrErr)
+		}
+		rrs = append(rrs, rr)
+	}
+	conn, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	srv := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			question := r.Question[0]
+			for _, rr := range rrs {
+				if strings.EqualFold(rr.Header().Name, question.Name) && rr.Header().Rrtype == question.Qtype {
+					m.Answer = append(m.Answer, rr)
+				}
+			}
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	t.Cleanup(func() {
+		_ = srv.Shutdown()
+	})
+	return conn.LocalAddr().String()
+}
+
+func check(t *testing.T, addr string, domain string) map[string]*preflight.Result {
+	return checkAlias(t, addr, domain, nil)
+}
+
+func checkAlias(t *testing.T, addr string, domain string, alias func(domain string) (name string, provider string)) map[string]*preflight.Result {
+	checker, checkerErr := preflight.New(preflight.Options{
+		Resolvers: []string{addr},
+		Provider:  "route53",
+		Alias:     alias,
+	})
+	if checkerErr != nil {
+		t.Fatal(checkerErr)
+	}
+	report := checker.Check(context.TODO(), domain)
+	results := make(map[string]*preflight.Result)
+	for _, result := range report.Results {
+		t.Log(result.Check, result.Ok, result.Message)
+		results[result.Check] = result
+	}
+	return results
+}
+
+func TestChecker_Check(t *testing.T) {
+	addr := serve(t, []string{
+		"ok.test. 60 IN SOA ns-1.awsdns-01.com. admin.ok.test. 1 7200 900 1209600 60",
+		"ok.test. 60 IN NS ns-1.awsdns-01.com.",
+		"ok.test. 60 IN CAA 0 issue \"letsencrypt.org\"",
+		"other.test. 60 IN SOA ns1.example.net. admin.other.test. 1 7200 900 1209600 60",
+		"other.test. 60 IN NS ns1.example.net.",
+		"other.test. 60 IN CAA 0 issue \"pki.goog\"",
+		"other.test. 60 IN CAA 0 issuewild \"letsencrypt.org\"",
+		"_acme-challenge.delegated.other.test. 60 IN CNAME _acme-challenge.ok.test.",
+	})
+
+	results := check(t, addr, "www.ok.test")
+	if !results[preflight.CheckCAA].Ok || !results[preflight.CheckNameservers].Ok {
+		t.Fatal("www.ok.test should pass")
+	}
+
+	results = check(t, addr, "www.other.test")
+	if results[preflight.CheckCAA].Ok {
+		t.Fatal("caa of other.test should forbid letsencrypt.org")
+	}
+	if results[preflight.CheckNameservers].Ok {
+		t.Fatal("other.test should not be served by route53")
+	}
+
+	results = check(t, addr, "*.other.test")
+	if !results[preflight.CheckCAA].Ok {
+		t.Fatal("issuewild of other.test should allow letsencrypt.org")
+	}
+
+	results = check(t, addr, "delegated.other.test")
+	if !results[preflight.CheckNameservers].Ok {
+		t.Fatal("cname of delegated.other.test should be followed into ok.test")
+	}
+
+	results = checkAlias(t, addr, "www.other.test", func(domain string) (name string, provider string) {
+		return "ok.test", "route53"
+	})
+	if !results[preflight.CheckNameservers].Ok {
+		t.Fatal("alias of www.other.test should be checked")
+	}
+}
+
+func TestChecker_CheckDeadline(t *testing.T) {
+	// a resolver which answers everything but soa, so that the zone of the challenge is never found
+	conn, listenErr := net.ListenPacket("udp", "127.0.0.1:0")
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	srv := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			if r.Question[0].Qtype == dns.TypeSOA {
+				return
+			}
+			m := new(dns.Msg)
+			m.SetReply(r)
+			_ = w.WriteMsg(m)
+		}),
+	}
+	go func() {
+		_ = srv.ActivateAndServe()
+	}()
+	defer func() {
+		_ = srv.Shutdown()
+	}()
+	checker, checkerErr := preflight.New(preflight.Options{
+		Resolvers: []string{conn.LocalAddr().String()},
+		Provider:  "route53",
+	})
+	if checkerErr != nil {
+		t.Fatal(checkerErr)
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+	defer cancel()
+	beg := time.Now()
+	report := checker.Check(ctx, "www.ok.test")
+	if elapsed := time.Since(beg); elapsed > time.Second {
+		t.Fatalf("check took %s, the deadline of ctx is not kept", elapsed)
+	}
+	if report.Ok() {
+		t.Fatal("check without soa passed")
+	}
+}
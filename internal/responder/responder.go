@@ -0,0 +1,249 @@
+package responder
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name is the dns provider name of the responder.
+const Name = "embedded"
+
+type Options struct {
+	// Zones are zones delegated to acmes by NS records, such as acme.foo.com,
+	// names in them without records are answered NXDOMAIN.
+	// Names of challenge records are answered as zones of their own when they are in none of Zones,
+	// which fits NS records delegating _acme-challenge.{domain} itself.
+	Zones []string
+	// Nameserver is the host name of acmes in NS records, such as ns.acme.foo.com.
+	Nameserver string
+	// TTL of answers in seconds, default is 10.
+	TTL uint32
+}
+
+// Responder is an authoritative dns server which answers TXT records of pending dns-01 challenges,
+// it is a lego challenge.Provider, so that challenges need no api of a dns provider.
+type Responder struct {
+	zones      []string
+	nameserver string
+	ttl        uint32
+	mutex      sync.RWMutex
+	records    map[string][]string
+	servers    []*dns.Server
+	addr       string
+}
+
+func New(options Options) (v *Responder) {
+	zones := make([]string, 0, len(options.Zones))
+	for _, zone := range options.Zones {
+		zone = strings.TrimSpace(zone)
+		if zone != "" {
+			zones = append(zones, strings.ToLower(dns.Fqdn(zone)))
+		}
+	}
+	nameserver := strings.TrimSpace(options.Nameserver)
+	if nameserver != "" {
+		nameserver = strings.ToLower(dns.Fqdn(nameserver))
+	}
+	ttl := options.TTL
+	if ttl == 0 {
+		ttl = 10
+	}
+	v = &Responder{
+		zones:      zones,
+		nameserver: nameserver,
+		ttl:        ttl,
+		records:    make(map[string][]string),
+	}
+	return
+}
+
+// Listen serves udp and tcp at the same port of addr, such as :53, a port of 0 picks a free one.
+func (r *Responder) Listen(addr string) (err error) {
+	ln, lnErr := net.Listen("tcp", addr)
+	if lnErr != nil {
+		err = fmt.Errorf("acmes: dns responder listen tcp %s failed, %v", addr, lnErr)
+		return
+	}
+	r.addr = ln.Addr().String()
+	conn, connErr := net.ListenPacket("udp", r.addr)
+	if connErr != nil {
+		_ = ln.Close()
+		err = fmt.Errorf("acmes: dns responder listen udp %s failed, %v", r.addr, connErr)
+		return
+	}
+	// Listen returns once both servers are started, so that Shutdown right after it stops them rather than failing as not started
+	started := make(chan error, 2)
+	notify := func() {
+		started <- nil
+	}
+	r.servers = []*dns.Server{
+		{PacketConn: conn, Handler: r, NotifyStartedFunc: notify},
+		{Listener: ln, Handler: r, NotifyStartedFunc: notify},
+	}
+	for _, srv := range r.servers {
+		go func(srv *dns.Server) {
+			if serveErr := srv.ActivateAndServe(); serveErr != nil {
+				started <- serveErr
+			}
+		}(srv)
+	}
+	for range r.servers {
+		if startErr := <-started; startErr != nil && err == nil {
+			err = fmt.Errorf("acmes: dns responder serve %s failed, %v", r.addr, startErr)
+		}
+	}
+	if err != nil {
+		_ = r.Shutdown(context.Background())
+		_ = ln.Close()
+		_ = conn.Close()
+	}
+	return
+}
+
+// Addr is the address which Listen bound.
+func (r *Responder) Addr() string {
+	return r.addr
+}
+
+func (r *Responder) Shutdown(ctx context.Context) (err error) {
+	for _, srv := range r.servers {
+		shutdownErr := srv.ShutdownContext(ctx)
+		if shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+	return
+}
+
+// Present adds the TXT record of the challenge, the name follows cnames of _acme-challenge.{domain},
+// so that a cname into a zone delegated to acmes works.
+func (r *Responder) Present(domain, _, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	fqdn := strings.ToLower(info.EffectiveFQDN)
+	r.mutex.Lock()
+	r.records[fqdn] = append(r.records[fqdn], info.Value)
+	r.mutex.Unlock()
+	return nil
+}
+
+func (r *Responder) CleanUp(domain, _, keyAuth string) error {
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	fqdn := strings.ToLower(info.EffectiveFQDN)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	values := r.records[fqdn]
+	for i, value := range values {
+		if value == info.Value {
+			values = append(values[:i], values[i+1:]...)
+			break
+		}
+	}
+	if len(values) == 0 {
+		delete(r.records, fqdn)
+		return nil
+	}
+	r.records[fqdn] = values
+	return nil
+}
+
+func (r *Responder) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if len(req.Question) != 1 {
+		m.Rcode = dns.RcodeFormatError
+		_ = w.WriteMsg(m)
+		return
+	}
+	question := req.Question[0]
+	name := strings.ToLower(question.Name)
+	r.mutex.RLock()
+	values, has := r.records[name]
+	values = append([]string(nil), values...)
+	nonTerminal := !has && r.hasChildren(name)
+	r.mutex.RUnlock()
+	apex := r.apexOf(name, has)
+	if apex == "" {
+		m.Rcode = dns.RcodeRefused
+		_ = w.WriteMsg(m)
+		return
+	}
+	m.Authoritative = true
+	switch {
+	case has && (question.Qtype == dns.TypeTXT || question.Qtype == dns.TypeANY):
+		for _, value := range values {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: r.header(question.Name, dns.TypeTXT),
+				Txt: []string{value},
+			})
+		}
+	case name == apex && question.Qtype == dns.TypeSOA:
+		m.Answer = append(m.Answer, r.soa(apex))
+	case name == apex && question.Qtype == dns.TypeNS && r.nameserver != "":
+		m.Answer = append(m.Answer, &dns.NS{
+			Hdr: r.header(apex, dns.TypeNS),
+			Ns:  r.nameserver,
+		})
+	case !has && !nonTerminal && name != apex:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns, r.soa(apex))
+	default:
+		m.Ns = append(m.Ns, r.soa(apex))
+	}
+	_ = w.WriteMsg(m)
+}
+
+// apexOf returns the zone of name, it is the longest one of zones which contains name,
+// or name itself when it has records and is in no zone, or empty when acmes is not authoritative for name.
+func (r *Responder) apexOf(name string, has bool) (apex string) {
+	for _, zone := range r.zones {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(apex) {
+			apex = zone
+		}
+	}
+	if apex == "" && has {
+		apex = name
+	}
+	return
+}
+
+// hasChildren reports whether name is an empty non-terminal, such as www.foo.com of _acme-challenge.www.foo.com.
+func (r *Responder) hasChildren(name string) bool {
+	for fqdn := range r.records {
+		if strings.HasSuffix(fqdn, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Responder) header(name string, rrtype uint16) dns.RR_Header {
+	return dns.RR_Header{
+		Name:   name,
+		Rrtype: rrtype,
+		Class:  dns.ClassINET,
+		Ttl:    r.ttl,
+	}
+}
+
+func (r *Responder) soa(apex string) *dns.SOA {
+	ns := r.nameserver
+	if ns == "" {
+		ns = apex
+	}
+	return &dns.SOA{
+		Hdr:     r.header(apex, dns.TypeSOA),
+		Ns:      ns,
+		Mbox:    "hostmaster." + apex,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  r.ttl,
+	}
+}
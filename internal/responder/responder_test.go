@@ -0,0 +1,92 @@
+package responder_test
+
+import (
+	"context"
+	"github.com/aacfactory/acmes/internal/responder"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"testing"
+)
+
+func query(t *testing.T, network string, addr string, name string, rtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, rtype)
+	client := &dns.Client{Net: network}
+	msg, _, err := client.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func TestResponder(t *testing.T) {
+	// challenge records are not cnames in tests, skip following them by system resolvers
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	r := responder.New(responder.Options{
+		Zones:      []string{"acme.foo.test"},
+		Nameserver: "ns.acme.foo.test",
+	})
+	err := r.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown(context.TODO())
+
+	keyAuth := "token.thumbprint"
+	fqdn, value := dns01.GetRecord("www.acme.foo.test", keyAuth)
+	if err = r.Present("www.acme.foo.test", "token", keyAuth); err != nil {
+		t.Fatal(err)
+	}
+	for _, network := range []string{"udp", "tcp"} {
+		msg := query(t, network, r.Addr(), fqdn, dns.TypeTXT)
+		if !msg.Authoritative || len(msg.Answer) != 1 || msg.Answer[0].(*dns.TXT).Txt[0] != value {
+			t.Fatal(network, "txt of", fqdn, "is not answered", msg)
+		}
+	}
+	zone, zoneErr := dns01.FindZoneByFqdnCustom(fqdn, []string{r.Addr()})
+	if zoneErr != nil || zone != "acme.foo.test." {
+		t.Fatal("zone of", fqdn, "should be acme.foo.test.", zone, zoneErr)
+	}
+	if msg := query(t, "udp", r.Addr(), "www.acme.foo.test.", dns.TypeTXT); msg.Rcode != dns.RcodeSuccess {
+		t.Fatal("empty non-terminal should not be NXDOMAIN", msg)
+	}
+	if msg := query(t, "udp", r.Addr(), "other.acme.foo.test.", dns.TypeTXT); msg.Rcode != dns.RcodeNameError {
+		t.Fatal("absent name should be NXDOMAIN", msg)
+	}
+	if msg := query(t, "udp", r.Addr(), "www.bar.test.", dns.TypeTXT); msg.Rcode != dns.RcodeRefused {
+		t.Fatal("name out of zones should be refused", msg)
+	}
+
+	// a record out of zones is a zone of its own, such as when _acme-challenge.{domain} is delegated by NS
+	outFqdn, _ := dns01.GetRecord("www.bar.test", keyAuth)
+	if err = r.Present("www.bar.test", "token", keyAuth); err != nil {
+		t.Fatal(err)
+	}
+	if msg := query(t, "udp", r.Addr(), outFqdn, dns.TypeSOA); len(msg.Answer) != 1 {
+		t.Fatal("soa of", outFqdn, "is not answered", msg)
+	}
+
+	if err = r.CleanUp("www.acme.foo.test", "token", keyAuth); err != nil {
+		t.Fatal(err)
+	}
+	if msg := query(t, "udp", r.Addr(), fqdn, dns.TypeTXT); len(msg.Answer) != 0 {
+		t.Fatal("txt of", fqdn, "should be cleaned up", msg)
+	}
+}
+
+func TestResponder_Shutdown(t *testing.T) {
+	// shutdown right after listen, as serve does when a later step fails, frees the port
+	r := responder.New(responder.Options{Zones: []string{"acme.foo.test"}})
+	if err := r.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	addr := r.Addr()
+	if err := r.Shutdown(context.TODO()); err != nil {
+		t.Fatal(err)
+	}
+	again := responder.New(responder.Options{Zones: []string{"acme.foo.test"}})
+	if err := again.Listen(addr); err != nil {
+		t.Fatalf("port is not freed, %v", err)
+	}
+	_ = again.Shutdown(context.TODO())
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/lego"
+	"sort"
+	"strings"
+)
+
+// account is an acme account with its lego client, certificates of the account are kept by its email in the store.
+type account struct {
+	name      string
+	email     string
+	directory string
+	client    *lego.Client
+	domains   []string
+	// preferredChain is the chain of orders which name no chain.
+	preferredChain string
+	// clients are common names of client certificates which can use the account, nil is every client.
+	clients map[string]struct{}
+}
+
+// allows is true when who can use the account.
+func (acct *account) allows(who requester) bool {
+	if acct.clients == nil {
+		return true
+	}
+	_, has := acct.clients[who.client]
+	return has
+}
+
+// accounts picks the account of requests, the default one is the first.
+type accounts struct {
+	list   []*account
+	byName map[string]*account
+	// suffixes are accounts by their domains, the longest suffix is first.
+	suffixes []accountSuffix
+}
+
+type accountSuffix struct {
+	suffix  string
+	account *account
+}
+
+// createAccounts creates lego clients of all accounts, accounts which are not in the store are registered at their ca.
+func createAccounts(configs []AccountConfig, provider challenge.Provider, dnsConfig DNSConfig, stores store.Store) (v *accounts, err error) {
+	v = &accounts{
+		list:     make([]*account, 0, len(configs)),
+		byName:   make(map[string]*account),
+		suffixes: make([]accountSuffix, 0, 1),
+	}
+	for _, config := range configs {
+		client, clientErr := createAcme(config, provider, dnsConfig, stores)
+		if clientErr != nil {
+			err = clientErr
+			return
+		}
+		directory := config.Directory
+		if directory == "" {
+			directory = lego.LEDirectoryProduction
+		}
+		acct := &account{
+			name:           config.Name,
+			email:          config.Email,
+			directory:      directory,
+			client:         client,
+			domains:        make([]string, 0, len(config.Domains)),
+			preferredChain: strings.TrimSpace(config.PreferredChain),
+		}
+		for _, client := range config.Clients {
+			if client = strings.TrimSpace(client); client == "" {
+				continue
+			}
+			if acct.clients == nil {
+				acct.clients = make(map[string]struct{})
+			}
+			acct.clients[client] = struct{}{}
+		}
+		for _, domain := range config.Domains {
+			suffix, suffixErr := normalizeSuffix(domain)
+			if suffixErr != nil {
+				err = fmt.Errorf("acmes: create acme account %s failed, %v", config.Name, suffixErr)
+				return
+			}
+			acct.domains = append(acct.domains, suffix)
+			v.suffixes = append(v.suffixes, accountSuffix{suffix: suffix, account: acct})
+		}
+		v.list = append(v.list, acct)
+		v.byName[acct.name] = acct
+	}
+	sort.SliceStable(v.suffixes, func(i, j int) bool {
+		return len(v.suffixes[i].suffix) > len(v.suffixes[j].suffix)
+	})
+	return
+}
+
+// pick returns the account named name, or the account whose domains match domain when name is empty,
+// or the default account when none matches.
+func (a *accounts) pick(name string, domain string) (v *account, err error) {
+	name = strings.TrimSpace(name)
+	if name != "" {
+		has := false
+		v, has = a.byName[name]
+		if !has {
+			err = newError(ErrorInvalidRequest, "account %s is not configured", name)
+			return
+		}
+		return
+	}
+	domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "*.")
+	for _, item := range a.suffixes {
+		if matchSuffix(domain, item.suffix) {
+			v = item.account
+			return
+		}
+	}
+	v = a.list[0]
+	return
+}
+
+// ofJob returns the account of job, jobs saved before accounts were named only have the email.
+func (a *accounts) ofJob(job *store.Job) (v *account, err error) {
+	if job.Account != "" {
+		v, err = a.pick(job.Account, job.Domain)
+		return
+	}
+	for _, acct := range a.list {
+		if acct.email == job.Email {
+			v = acct
+			return
+		}
+	}
+	err = newError(ErrorInvalidRequest, "account of %s is not configured", job.Email)
+	return
+}
+
+// emails returns emails of all accounts.
+func (a *accounts) emails() []string {
+	emails := make([]string, 0, len(a.list))
+	for _, acct := range a.list {
+		emails = append(emails, acct.email)
+	}
+	return emails
+}
@@ -9,26 +9,73 @@ import (
 	"encoding/pem"
 	"fmt"
 	"github.com/aacfactory/acmes/internal/store"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
-	"github.com/go-acme/lego/v4/providers/dns"
 	"github.com/go-acme/lego/v4/registration"
+	"strings"
 )
 
-func createAcme(email string, dnsProvider string, stores store.Store) (client *lego.Client, err error) {
+// keyTypes are key types of certificates by their names in config.
+var keyTypes = map[string]certcrypto.KeyType{
+	"RSA2048": certcrypto.RSA2048,
+	"RSA3072": certcrypto.RSA3072,
+	"RSA4096": certcrypto.RSA4096,
+	"RSA8192": certcrypto.RSA8192,
+	"EC256":   certcrypto.EC256,
+	"EC384":   certcrypto.EC384,
+}
+
+// parseKeyType returns the key type of name, RSA2048 when name is empty.
+func parseKeyType(name string) (keyType certcrypto.KeyType, err error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		keyType = certcrypto.RSA2048
+		return
+	}
+	keyType, has := keyTypes[name]
+	if !has {
+		err = fmt.Errorf("%s is not one of RSA2048, RSA3072, RSA4096, RSA8192, EC256 and EC384", name)
+		return
+	}
+	return
+}
+
+// createAcme creates the lego client of the account, and registers the account at the ca when it is not in the store,
+// or when the stored one is registered at another ca, such as after the directory was changed.
+// The provider is shared by clients of all accounts, so that pending challenges are tracked in one place.
+func createAcme(account AccountConfig, provider challenge.Provider, dnsConfig DNSConfig, stores store.Store) (client *lego.Client, err error) {
+	email := account.Email
+	directory := account.Directory
+	if directory == "" {
+		directory = lego.LEDirectoryProduction
+	}
 	user, hasUser, getUserErr := stores.GetUser(context.TODO(), email)
 	if getUserErr != nil {
-		err = fmt.Errorf("acmes: create acme client failed, %v", getUserErr)
+		err = fmt.Errorf("acmes: create acme client of %s failed, %v", account.Name, getUserErr)
 		return
 	}
+	if hasUser && !user.RegisteredAt(directory) {
+		hasUser = false
+	}
+	if hasUser && user.Directory == "" {
+		user.Directory = directory
+		if saveErr := stores.SaveUser(context.TODO(), user); saveErr != nil {
+			err = fmt.Errorf("acmes: create acme client of %s failed, %v", account.Name, saveErr)
+			return
+		}
+	}
 	if !hasUser {
 		key, keyErr := rsa.GenerateKey(rand.Reader, 2048)
 		if keyErr != nil {
-			err = fmt.Errorf("acmes: create acme client failed, create user private key failed, %v", keyErr)
+			err = fmt.Errorf("acmes: create acme client of %s failed, create user private key failed, %v", account.Name, keyErr)
 			return
 		}
 		user = &store.User{
-			Email:    email,
-			Resource: nil,
+			Email:     email,
+			Directory: directory,
+			Resource:  nil,
 			Key: pem.EncodeToMemory(&pem.Block{
 				Type:    "RSA PRIVATE KEY",
 				Headers: nil,
@@ -36,37 +83,53 @@ func createAcme(email string, dnsProvider string, stores store.Store) (client *l
 			}),
 		}
 	}
+	keyType, keyTypeErr := parseKeyType(account.KeyType)
+	if keyTypeErr != nil {
+		err = fmt.Errorf("acmes: create acme client of %s failed, %v", account.Name, keyTypeErr)
+		return
+	}
 	config := lego.NewConfig(user)
+	config.CADirURL = directory
+	config.Certificate.KeyType = keyType
 	client, err = lego.NewClient(config)
 	if err != nil {
-		err = fmt.Errorf("acmes: create acme failed, %v", err)
-		return
-	}
-	provider, providerErr := dns.NewDNSChallengeProviderByName(dnsProvider)
-	if providerErr != nil {
-		err = fmt.Errorf("acmes: create acme failed, %v", providerErr)
+		err = fmt.Errorf("acmes: create acme client of %s failed, %v", account.Name, err)
 		return
 	}
-	setProviderErr := client.Challenge.SetDNS01Provider(provider)
+	resolvers := dns01.ParseNameservers(dnsConfig.Resolvers)
+	setProviderErr := client.Challenge.SetDNS01Provider(provider,
+		dns01.CondOption(len(resolvers) > 0, dns01.AddRecursiveNameservers(resolvers)),
+		dns01.CondOption(dnsConfig.Propagation.DisableAuthoritativeCheck, dns01.WrapPreCheck(checkAtResolvers(resolvers))),
+	)
 	if setProviderErr != nil {
-		err = fmt.Errorf("acmes: create acme failed, %v", setProviderErr)
+		err = fmt.Errorf("acmes: create acme client of %s failed, %v", account.Name, setProviderErr)
 		return
 	}
 	if !hasUser {
-		userRegistration, registerErr := client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		var userRegistration *registration.Resource
+		var registerErr error
+		if account.EAB.KID != "" {
+			userRegistration, registerErr = client.Registration.RegisterWithExternalAccountBinding(registration.RegisterEABOptions{
+				TermsOfServiceAgreed: true,
+				Kid:                  account.EAB.KID,
+				HmacEncoded:          account.EAB.HMACKey,
+			})
+		} else {
+			userRegistration, registerErr = client.Registration.Register(registration.RegisterOptions{TermsOfServiceAgreed: true})
+		}
 		if registerErr != nil {
-			err = fmt.Errorf("acmes: create acme failed, %v", registerErr)
+			err = fmt.Errorf("acmes: register acme account %s failed, %v", account.Name, registerErr)
 			return
 		}
 		userRegistrationContent, userRegistrationErr := json.Marshal(userRegistration)
 		if userRegistrationErr != nil {
-			err = fmt.Errorf("acmes: create acme failed, %v", registerErr)
+			err = fmt.Errorf("acmes: register acme account %s failed, %v", account.Name, userRegistrationErr)
 			return
 		}
 		user.Resource = userRegistrationContent
 		saveErr := stores.SaveUser(context.TODO(), user)
 		if saveErr != nil {
-			err = fmt.Errorf("acmes: create acme failed, %v", saveErr)
+			err = fmt.Errorf("acmes: register acme account %s failed, %v", account.Name, saveErr)
 		}
 		return
 	}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"github.com/aacfactory/acmes/internal/export"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CertificateSummary struct {
+	Account  string    `json:"account"`
+	Domain   string    `json:"domain"`
+	NotAfter time.Time `json:"notAfter"`
+}
+
+// route matches routes of the v1 api, name is the route template which names the span.
+//
+//	POST   /v1/certificates                  obtain, {"domain": "www.foo.com", "async": false, "account": "", "preferredChain": "", "mustStaple": false}
+//	GET    /v1/certificates                  list obtained certificates of all accounts, or of the account query param
+//	GET    /v1/certificates/{domain}         get an obtained certificate, in json or the format query param
+//	POST   /v1/certificates/{domain}/renew   renew, the chain is in the preferredChain query param, must staple in the mustStaple one
+//	DELETE /v1/certificates/{domain}         revoke, the crl reason is in the reason query param
+//	GET    /v1/jobs/{id}                     get an async obtain job
+//	GET    /v1/events?domain={domain}        stream certificate events
+//
+// Routes of a domain take the acme account in the account query param, it is picked by the domain when it is absent.
+func (handler *Handler) route(request *http.Request) (name string, fn routeFunc) {
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/v1"), "/"), "/")
+	method := request.Method
+	switch {
+	case segments[0] == "certificates" && len(segments) == 1 && method == http.MethodPost:
+		name = "POST /v1/certificates"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			param, paramErr := decodeParam(request, "application/json")
+			if paramErr != nil {
+				err = paramErr
+				return
+			}
+			err = handler.serveObtain(writer, request, param, "/v1/jobs/")
+			return
+		}
+	case segments[0] == "certificates" && len(segments) == 1 && method == http.MethodGet:
+		name = "GET /v1/certificates"
+		fn = handler.serveCertificates
+	case segments[0] == "certificates" && len(segments) == 2 && method == http.MethodGet:
+		name = "GET /v1/certificates/{domain}"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			err = handler.serveCertificate(writer, request, segments[1])
+			return
+		}
+	case segments[0] == "certificates" && len(segments) == 2 && method == http.MethodDelete:
+		name = "DELETE /v1/certificates/{domain}"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			err = handler.serveRevoke(writer, request, strings.TrimSpace(segments[1]))
+			return
+		}
+	case segments[0] == "certificates" && len(segments) == 3 && segments[2] == "renew" && method == http.MethodPost:
+		name = "POST /v1/certificates/{domain}/renew"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			query := request.URL.Query()
+			mustStaple := false
+			if value := strings.TrimSpace(query.Get("mustStaple")); value != "" {
+				var parseErr error
+				mustStaple, parseErr = strconv.ParseBool(value)
+				if parseErr != nil {
+					err = newError(ErrorInvalidRequest, "mustStaple %s is not a bool", value)
+					return
+				}
+			}
+			err = handler.serveRenew(writer, request, strings.TrimSpace(segments[1]), query.Get("account"), orderOptions{
+				preferredChain: strings.TrimSpace(query.Get("preferredChain")),
+				mustStaple:     mustStaple,
+			})
+			return
+		}
+	case segments[0] == "jobs" && len(segments) == 2 && method == http.MethodGet:
+		name = "GET /v1/jobs/{id}"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			err = handler.serveJob(writer, request, segments[1])
+			return
+		}
+	case segments[0] == "events" && len(segments) == 1 && method == http.MethodGet:
+		name = "GET /v1/events"
+		fn = handler.serveEvents
+	}
+	return
+}
+
+// legacyRoute matches routes before the v1 api, they are kept for clients which are not upgraded.
+//
+//	POST /obtain      obtain, content type is application/acme
+//	POST /renew       renew, content type is application/acme
+//	GET  /jobs/{id}   get an async obtain job
+//	GET  /events      stream certificate events
+func (handler *Handler) legacyRoute(request *http.Request) (name string, fn routeFunc) {
+	path := request.URL.Path
+	method := request.Method
+	switch {
+	case path == "/obtain" && method == http.MethodPost:
+		name = "POST /obtain"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			param, paramErr := decodeParam(request, "application/acme")
+			if paramErr != nil {
+				err = paramErr
+				return
+			}
+			err = handler.serveObtain(writer, request, param, "/jobs/")
+			return
+		}
+	case path == "/renew" && method == http.MethodPost:
+		name = "POST /renew"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			param, paramErr := decodeParam(request, "application/acme")
+			if paramErr != nil {
+				err = paramErr
+				return
+			}
+			err = handler.serveRenew(writer, request, param.Domain, param.Account, param.options())
+			return
+		}
+	case strings.HasPrefix(path, "/jobs/") && method == http.MethodGet:
+		name = "GET /jobs/{id}"
+		fn = func(writer http.ResponseWriter, request *http.Request) (err error) {
+			err = handler.serveJob(writer, request, strings.TrimPrefix(path, "/jobs/"))
+			return
+		}
+	case path == "/events" && method == http.MethodGet:
+		name = "GET /events"
+		fn = handler.serveEvents
+	}
+	return
+}
+
+func (handler *Handler) serveCertificates(writer http.ResponseWriter, request *http.Request) (err error) {
+	summaries, listErr := handler.listCertificates(request.Context(), requesterOf(request), request.URL.Query().Get("account"))
+	if listErr != nil {
+		err = listErr
+		return
+	}
+	writeJSON(writer, http.StatusOK, summaries)
+	return
+}
+
+// listCertificates returns certificates of the named account, or of all accounts which who can use when name is empty.
+func (handler *Handler) listCertificates(ctx context.Context, who requester, name string) (summaries []CertificateSummary, err error) {
+	accounts := make([]*account, 0, len(handler.accounts.list))
+	for _, acct := range handler.accounts.list {
+		if acct.allows(who) {
+			accounts = append(accounts, acct)
+		}
+	}
+	if name = strings.TrimSpace(name); name != "" {
+		acct, pickErr := handler.accounts.pick(name, "")
+		if pickErr != nil {
+			err = pickErr
+			return
+		}
+		if !acct.allows(who) {
+			err = newError(ErrorForbidden, "client %s can not use account %s", who.client, acct.name)
+			return
+		}
+		accounts = []*account{acct}
+	}
+	summaries = make([]CertificateSummary, 0, 8)
+	for _, acct := range accounts {
+		domains, listErr := handler.stores.ListUserCertificates(ctx, acct.email)
+		if listErr != nil {
+			err = listErr
+			return
+		}
+		for _, domain := range domains {
+			cert, has, getErr := handler.stores.GetUserCertificate(ctx, acct.email, domain)
+			if getErr != nil {
+				err = getErr
+				return
+			}
+			if !has {
+				continue
+			}
+			summaries = append(summaries, CertificateSummary{
+				Account:  acct.name,
+				Domain:   domain,
+				NotAfter: cert.NotAfter,
+			})
+		}
+	}
+	return
+}
+
+func (handler *Handler) serveCertificate(writer http.ResponseWriter, request *http.Request, domain string) (err error) {
+	domain, acct, pickErr := handler.pickAccount(requesterOf(request), request.URL.Query().Get("account"), domain)
+	if pickErr != nil {
+		err = pickErr
+		return
+	}
+	cert, has, getErr := handler.stores.GetUserCertificate(request.Context(), acct.email, domain)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if !has {
+		err = newError(ErrorNotFound, "certificate of %s was not obtained", domain)
+		return
+	}
+	format := strings.TrimSpace(request.URL.Query().Get("format"))
+	if format == "" || format == "json" {
+		writeJSON(writer, http.StatusOK, cert)
+		return
+	}
+	if !export.IsFormat(format) {
+		err = newError(ErrorInvalidRequest, "format %s is not supported, it is one of json, %s", format, strings.Join(export.Formats, ", "))
+		return
+	}
+	content, contentType, encodeErr := export.Encode(domain, cert, export.Options{
+		Format:    format,
+		Password:  request.Header.Get(exportPasswordHeader),
+		Name:      request.URL.Query().Get("name"),
+		Namespace: request.URL.Query().Get("namespace"),
+	})
+	if encodeErr != nil {
+		err = newError(ErrorInvalidRequest, "%v", encodeErr)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write(content)
+	return
+}
+
+// exportPasswordHeader carries the password of a pkcs12 export, it is not in the query so that it is not logged.
+const exportPasswordHeader = "Acmes-Export-Password"
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/audit"
+	"github.com/aacfactory/acmes/internal/store"
+	"net/http"
+	"time"
+)
+
+// requester is who sent the request, the client is identified by its client cert.
+type requester struct {
+	client       string
+	clientSerial string
+	remoteAddr   string
+}
+
+func requesterOf(request *http.Request) (v requester) {
+	v = requesterOfConn(request.RemoteAddr, request.TLS)
+	return
+}
+
+func requesterOfConn(remoteAddr string, state *tls.ConnectionState) (v requester) {
+	v.remoteAddr = remoteAddr
+	if state != nil && len(state.PeerCertificates) > 0 {
+		peer := state.PeerCertificates[0]
+		v.client = peer.Subject.CommonName
+		v.clientSerial = peer.SerialNumber.Text(16)
+	}
+	return
+}
+
+func (handler *Handler) audit(who requester, operation audit.Operation, domain string, cert *store.Certificate, err error) {
+	if handler.audits == nil {
+		return
+	}
+	record := &audit.Record{
+		Time:         time.Now(),
+		Client:       who.client,
+		ClientSerial: who.clientSerial,
+		RemoteAddr:   who.remoteAddr,
+		Operation:    operation,
+		Domains:      []string{domain},
+		Result:       audit.ResultSucceed,
+	}
+	if err != nil {
+		record.Result = audit.ResultFailed
+		record.Cause = err.Error()
+	}
+	if cert != nil {
+		record.Serial = certificateSerial(cert.Cert)
+	}
+	appendErr := handler.audits.Append(context.TODO(), record)
+	if appendErr != nil {
+		handler.log.Error().Cause(appendErr).Message(fmt.Sprintf("acmes: audit %s %s failed", operation, domain))
+	}
+}
+
+func certificateSerial(certPEM []byte) string {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return ""
+	}
+	cert, parseErr := x509.ParseCertificate(block.Bytes)
+	if parseErr != nil {
+		return ""
+	}
+	return cert.SerialNumber.Text(16)
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// background runs jobs until shutdown.
+type background struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	group  sync.WaitGroup
+}
+
+func newBackground() *background {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &background{
+		ctx:    ctx,
+		cancel: cancel,
+		group:  sync.WaitGroup{},
+	}
+}
+
+// run runs job in a goroutine, running is true until job returns.
+func (b *background) run(running *atomic.Bool, job func(ctx context.Context)) {
+	running.Store(true)
+	b.group.Add(1)
+	go func() {
+		defer b.group.Done()
+		defer running.Store(false)
+		job(b.ctx)
+	}()
+}
+
+// stop cancels jobs, and waits for them to return until ctx is done.
+func (b *background) stop(ctx context.Context) (err error) {
+	b.cancel()
+	err = waitGroup(ctx, &b.group)
+	return
+}
+
+func waitGroup(ctx context.Context, group *sync.WaitGroup) (err error) {
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/responder"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/cpu/goacmedns"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/providers/dns"
+	"github.com/go-acme/lego/v4/providers/dns/acmedns"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acmeDNSProvider is the name of the acme-dns provider of lego, its accounts are kept in the store instead of a json file.
+const acmeDNSProvider = "acme-dns"
+
+// providerTTLEnv are environment variables of ttl of lego providers, which are read when the provider is created.
+var providerTTLEnv = map[string]string{
+	"alidns":       "ALICLOUD_TTL",
+	"azure":        "AZURE_TTL",
+	"azuredns":     "AZURE_TTL",
+	"cloudflare":   "CLOUDFLARE_TTL",
+	"digitalocean": "DO_TTL",
+	"dnspod":       "DNSPOD_TTL",
+	"gandiv5":      "GANDIV5_TTL",
+	"gcloud":       "GCE_TTL",
+	"godaddy":      "GODADDY_TTL",
+	"hetzner":      "HETZNER_TTL",
+	"linode":       "LINODE_TTL",
+	"namecheap":    "NAMECHEAP_TTL",
+	"namesilo":     "NAMESILO_TTL",
+	"ovh":          "OVH_TTL",
+	"pdns":         "PDNS_TTL",
+	"rfc2136":      "RFC2136_TTL",
+	"route53":      "AWS_TTL",
+	"tencentcloud": "TENCENTCLOUD_TTL",
+	"vultr":        "VULTR_TTL",
+}
+
+// supportsTTL reports whether the ttl of provider can be set by config, the embedded dns server takes it directly.
+func supportsTTL(provider string) bool {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	_, has := providerTTLEnv[provider]
+	return has || provider == responder.Name
+}
+
+// setProviderTTL sets the ttl env var of provider, it must be called before the provider is created.
+// restore puts the env var back once the provider is created, so that the ttl does not leak into other providers of the same name.
+func setProviderTTL(provider string, ttl int) (restore func(), err error) {
+	restore = func() {}
+	name, has := providerTTLEnv[strings.ToLower(strings.TrimSpace(provider))]
+	if !has || ttl <= 0 {
+		return
+	}
+	previous, had := os.LookupEnv(name)
+	err = setProviderEnv(map[string]string{name: strconv.Itoa(ttl)})
+	if err != nil {
+		return
+	}
+	restore = func() {
+		if had {
+			_ = os.Setenv(name, previous)
+			return
+		}
+		_ = os.Unsetenv(name)
+	}
+	return
+}
+
+// newDNSProvider creates the lego dns provider by name, its credentials are read from environment variables.
+// The provider named embedded is the dns server of acmes, it is nil when dns.embedded.port is not set.
+func newDNSProvider(name string, stores store.Store, embedded *responder.Responder) (provider challenge.Provider, err error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == responder.Name {
+		if embedded == nil {
+			err = fmt.Errorf("%s: dns.embedded.port is required", responder.Name)
+			return
+		}
+		provider = embedded
+		return
+	}
+	if name == acmeDNSProvider {
+		base := os.Getenv(acmedns.EnvAPIBase)
+		if base == "" {
+			err = fmt.Errorf("acme-dns: %s is required", acmedns.EnvAPIBase)
+			return
+		}
+		provider, err = acmedns.NewDNSProviderClient(goacmedns.NewClient(base), &challengeAccounts{
+			provider: acmeDNSProvider,
+			stores:   stores,
+			pending:  make(map[string]goacmedns.Account),
+		})
+		return
+	}
+	provider, err = dns.NewDNSChallengeProviderByName(name)
+	return
+}
+
+// createChallengeProvider creates the provider of dns.provider, and providers of aliases which route challenges of their domains.
+// Env of each alias is set right before its provider is created, because lego providers read credentials at creation.
+func createChallengeProvider(config DNSConfig, stores store.Store, embedded *responder.Responder) (provider challenge.Provider, aliases *challengeAliases, err error) {
+	restore, ttlErr := setProviderTTL(config.Provider, config.TTL)
+	if ttlErr != nil {
+		err = ttlErr
+		return
+	}
+	main, mainErr := newDNSProvider(config.Provider, stores, embedded)
+	restore()
+	if mainErr != nil {
+		err = fmt.Errorf("acmes: create dns provider %s failed, %v", config.Provider, mainErr)
+		return
+	}
+	aliases = &challengeAliases{
+		provider: strings.ToLower(config.Provider),
+		dns:      main,
+		aliases:  make([]*challengeAlias, 0, len(config.Aliases)),
+	}
+	for _, item := range config.Aliases {
+		domain, domainErr := normalizeSuffix(item.Domain)
+		if domainErr != nil {
+			err = fmt.Errorf("acmes: create dns alias of %s failed, %v", item.Domain, domainErr)
+			return
+		}
+		alias := &challengeAlias{
+			domain:   domain,
+			provider: aliases.provider,
+			dns:      main,
+		}
+		if item.Alias != "" {
+			alias.alias, err = normalizeSuffix(item.Alias)
+			if err != nil {
+				err = fmt.Errorf("acmes: create dns alias of %s failed, %v", item.Domain, err)
+				return
+			}
+		}
+		if item.Provider != "" {
+			err = setProviderEnv(item.Env)
+			if err != nil {
+				return
+			}
+			restore, err = setProviderTTL(item.Provider, item.TTL)
+			if err != nil {
+				return
+			}
+			alias.provider = strings.ToLower(item.Provider)
+			alias.dns, err = newDNSProvider(item.Provider, stores, embedded)
+			restore()
+			if err != nil {
+				err = fmt.Errorf("acmes: create dns provider %s of alias %s failed, %v", item.Provider, item.Domain, err)
+				return
+			}
+		}
+		aliases.aliases = append(aliases.aliases, alias)
+	}
+	// the longest suffix wins
+	sort.SliceStable(aliases.aliases, func(i, j int) bool {
+		return len(aliases.aliases[i].domain) > len(aliases.aliases[j].domain)
+	})
+	provider = main
+	if len(aliases.aliases) == 0 {
+		return
+	}
+	provider = aliases
+	for _, p := range aliases.providers() {
+		if _, ok := p.(sequentialProvider); ok {
+			provider = &sequentialAliases{
+				challengeAliases: aliases,
+			}
+			break
+		}
+	}
+	return
+}
+
+type challengeAlias struct {
+	domain   string
+	alias    string
+	provider string
+	dns      challenge.Provider
+}
+
+// challengeAliases presents the challenge of a domain by the provider of its alias, or by the main provider when it has no alias.
+type challengeAliases struct {
+	provider string
+	dns      challenge.Provider
+	aliases  []*challengeAlias
+}
+
+// resolve returns the domain whose _acme-challenge record is written for domain, and the provider which writes it.
+func (a *challengeAliases) resolve(domain string) (name string, provider string, p challenge.Provider) {
+	name = strings.TrimPrefix(domain, "*.")
+	for _, alias := range a.aliases {
+		if matchSuffix(name, alias.domain) {
+			if alias.alias != "" {
+				name = alias.alias
+			}
+			provider = alias.provider
+			p = alias.dns
+			return
+		}
+	}
+	provider = a.provider
+	p = a.dns
+	return
+}
+
+func (a *challengeAliases) Present(domain, token, keyAuth string) error {
+	name, _, p := a.resolve(domain)
+	return p.Present(name, token, keyAuth)
+}
+
+func (a *challengeAliases) CleanUp(domain, token, keyAuth string) error {
+	name, _, p := a.resolve(domain)
+	return p.CleanUp(name, token, keyAuth)
+}
+
+// Timeout is the longest one of all providers, so that the slowest zone is waited for.
+func (a *challengeAliases) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+	for _, p := range a.providers() {
+		if pt, ok := p.(challenge.ProviderTimeout); ok {
+			pTimeout, pInterval := pt.Timeout()
+			if pTimeout > timeout {
+				timeout = pTimeout
+			}
+			if pInterval > interval {
+				interval = pInterval
+			}
+		}
+	}
+	return
+}
+
+func (a *challengeAliases) providers() []challenge.Provider {
+	providers := make([]challenge.Provider, 0, len(a.aliases)+1)
+	providers = append(providers, a.dns)
+	for _, alias := range a.aliases {
+		providers = append(providers, alias.dns)
+	}
+	return providers
+}
+
+type sequentialAliases struct {
+	*challengeAliases
+}
+
+func (a *sequentialAliases) Sequential() (interval time.Duration) {
+	for _, p := range a.providers() {
+		if sp, ok := p.(sequentialProvider); ok && sp.Sequential() > interval {
+			interval = sp.Sequential()
+		}
+	}
+	return
+}
+
+// challengeAccounts keeps accounts of a challenge service per domain in the store, it implements goacmedns.Storage.
+type challengeAccounts struct {
+	provider string
+	stores   store.Store
+	mutex    sync.Mutex
+	pending  map[string]goacmedns.Account
+}
+
+func (s *challengeAccounts) Put(domain string, account goacmedns.Account) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.pending[domain] = account
+	return nil
+}
+
+func (s *challengeAccounts) Save() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for domain, account := range s.pending {
+		content, encodeErr := json.Marshal(account)
+		if encodeErr != nil {
+			err = fmt.Errorf("acmes: save %s account of %s failed, %v", s.provider, domain, encodeErr)
+			return
+		}
+		err = s.stores.SaveChallengeAccount(context.TODO(), &store.ChallengeAccount{
+			Provider: s.provider,
+			Domain:   domain,
+			Account:  content,
+		})
+		if err != nil {
+			return
+		}
+		delete(s.pending, domain)
+	}
+	return
+}
+
+func (s *challengeAccounts) Fetch(domain string) (account goacmedns.Account, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if pending, has := s.pending[domain]; has {
+		account = pending
+		return
+	}
+	stored, has, getErr := s.stores.GetChallengeAccount(context.TODO(), s.provider, domain)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if !has {
+		err = goacmedns.ErrDomainNotFound
+		return
+	}
+	err = json.Unmarshal(stored.Account, &account)
+	if err != nil {
+		err = fmt.Errorf("acmes: decode %s account of %s failed, %v", s.provider, domain, err)
+		return
+	}
+	return
+}
+
+// FetchAll returns accounts which can be read, the storage interface has no way to report failures here.
+func (s *challengeAccounts) FetchAll() map[string]goacmedns.Account {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	accounts := make(map[string]goacmedns.Account)
+	stored, _ := s.stores.ListChallengeAccounts(context.TODO(), s.provider)
+	for _, item := range stored {
+		account := goacmedns.Account{}
+		if json.Unmarshal(item.Account, &account) == nil {
+			accounts[item.Domain] = account
+		}
+	}
+	for domain, account := range s.pending {
+		accounts[domain] = account
+	}
+	return accounts
+}
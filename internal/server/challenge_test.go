@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/cpu/goacmedns"
+	"github.com/go-acme/lego/v4/challenge"
+	"testing"
+)
+
+type namedProvider string
+
+func (p namedProvider) Present(domain, token, keyAuth string) error {
+	return nil
+}
+
+func (p namedProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+func TestChallengeAliases_resolve(t *testing.T) {
+	main, books, shop := namedProvider("main"), namedProvider("books"), namedProvider("shop")
+	aliases := &challengeAliases{
+		provider: "cloudflare",
+		dns:      main,
+		// sorted by createChallengeProvider, the longest suffix first
+		aliases: []*challengeAlias{
+			{domain: "shop.xn--bcher-kva.example", alias: "shop.bar.net", provider: "route53", dns: shop},
+			{domain: "xn--bcher-kva.example", provider: "acme-dns", dns: books},
+		},
+	}
+	cases := []struct {
+		domain   string
+		name     string
+		provider string
+		dns      challenge.Provider
+	}{
+		{"www.foo.com", "www.foo.com", "cloudflare", main},
+		{"*.foo.com", "foo.com", "cloudflare", main},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example", "acme-dns", books},
+		{"*.www.xn--bcher-kva.example", "www.xn--bcher-kva.example", "acme-dns", books},
+		{"shop.xn--bcher-kva.example", "shop.bar.net", "route53", shop},
+		{"www.shop.xn--bcher-kva.example", "shop.bar.net", "route53", shop},
+		{"noxn--bcher-kva.example", "noxn--bcher-kva.example", "cloudflare", main},
+	}
+	for _, c := range cases {
+		name, provider, p := aliases.resolve(c.domain)
+		if name != c.name || provider != c.provider || p != c.dns {
+			t.Errorf("%s resolves to %s of %s (%v), want %s of %s (%v)", c.domain, name, provider, p, c.name, c.provider, c.dns)
+		}
+	}
+}
+
+func TestChallengeAccounts(t *testing.T) {
+	stores, storeErr := store.NewFileStore(t.TempDir())
+	if storeErr != nil {
+		t.Fatal(storeErr)
+	}
+	accounts := &challengeAccounts{
+		provider: acmeDNSProvider,
+		stores:   stores,
+		pending:  make(map[string]goacmedns.Account),
+	}
+	if _, err := accounts.Fetch("www.foo.com"); !errors.Is(err, goacmedns.ErrDomainNotFound) {
+		t.Fatalf("account of an unknown domain is fetched, %v", err)
+	}
+	www := goacmedns.Account{FullDomain: "www.auth.acme-dns.io", SubDomain: "www", Username: "www"}
+	api := goacmedns.Account{FullDomain: "api.auth.acme-dns.io", SubDomain: "api", Username: "api"}
+	_ = accounts.Put("www.foo.com", www)
+	if got, err := accounts.Fetch("www.foo.com"); err != nil || got != www {
+		t.Fatalf("pending account is %v, %v", got, err)
+	}
+	if err := accounts.Save(); err != nil {
+		t.Fatal(err)
+	}
+	_ = accounts.Put("api.foo.com", api)
+	// accounts are read from the store once they are saved
+	reloaded := &challengeAccounts{
+		provider: acmeDNSProvider,
+		stores:   stores,
+		pending:  make(map[string]goacmedns.Account),
+	}
+	cases := []struct {
+		accounts *challengeAccounts
+		domain   string
+		want     goacmedns.Account
+		has      bool
+	}{
+		{accounts, "www.foo.com", www, true},
+		{accounts, "api.foo.com", api, true},
+		{accounts, "foo.com", goacmedns.Account{}, false},
+		{reloaded, "www.foo.com", www, true},
+		{reloaded, "api.foo.com", goacmedns.Account{}, false},
+	}
+	for i, c := range cases {
+		got, err := c.accounts.Fetch(c.domain)
+		if !c.has {
+			if !errors.Is(err, goacmedns.ErrDomainNotFound) {
+				t.Errorf("%d: account of %s is %v, %v", i, c.domain, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%d: account of %s is %v, want %v, %v", i, c.domain, got, c.want, err)
+		}
+	}
+	if all := accounts.FetchAll(); len(all) != 2 || all["www.foo.com"] != www || all["api.foo.com"] != api {
+		t.Fatalf("all accounts are %v", all)
+	}
+}
@@ -3,46 +3,68 @@ package server
 import (
 	"github.com/urfave/cli/v2"
 	"strings"
+	"time"
 )
 
 var Command = &cli.Command{
 	Name:        "serve",
-	Usage:       "serve --port 443 --ca {ca_path} --cakey {ca_key_path} --level info --email {email} --store {file:///some_dir_path} --provider {provider}",
+	Usage:       "serve --config {config_path} | --port 443 --ca {ca_path} --cakey {ca_key_path} --level info --email {email} --store {file:///some_dir_path} --provider {provider}",
 	Description: "run acmes http server",
 	ArgsUsage:   "",
 	Category:    "",
-	Action: func(c *cli.Context) error {
-		return serve(options{
-			port:         c.Int("port"),
-			ca:           strings.TrimSpace(c.String("ca")),
-			key:          strings.TrimSpace(c.String("cakey")),
-			level:        strings.TrimSpace(c.String("level")),
-			logFormatter: strings.TrimSpace(c.String("formatter")),
-			store:        strings.TrimSpace(c.String("store")),
-			email:        strings.TrimSpace(c.String("email")),
-			provider:     strings.TrimSpace(c.String("provider")),
-		})
+	Action: func(c *cli.Context) (err error) {
+		path := strings.TrimSpace(c.String("config"))
+		load := func() (config *Config, err error) {
+			config = &Config{}
+			mergeFlags(c, config, true)
+			if path != "" {
+				err = loadConfig(path, config)
+				if err != nil {
+					return
+				}
+			}
+			mergeFlags(c, config, false)
+			err = config.Validate()
+			return
+		}
+		config, loadErr := load()
+		if loadErr != nil {
+			err = loadErr
+			return
+		}
+		err = serve(config, path, load)
+		return
 	},
 	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "config",
+			Value:   "",
+			Usage:   "yaml or toml config file, flags and env vars which are set take precedence over it",
+			EnvVars: []string{"ACMES_CONFIG"},
+		},
 		&cli.IntFlag{
 			Name:    "port",
 			Value:   80,
 			Usage:   "port for http server",
 			EnvVars: []string{"ACMES_PORT"},
 		},
+		&cli.IntFlag{
+			Name:    "grpc-port",
+			Value:   0,
+			Usage:   "port for grpc server, disabled when 0",
+			EnvVars: []string{"ACMES_GRPC_PORT"},
+		},
 		&cli.StringFlag{
-			Required: true,
-			Name:     "ca",
-			Value:    "",
-			Usage:    "ca file for http server",
-			EnvVars:  []string{"ACMES_CA"},
+			Name:    "ca",
+			Value:   "",
+			Usage:   "ca file for http server",
+			EnvVars: []string{"ACMES_CA"},
 		},
 		&cli.StringFlag{
-			Required: true,
-			Name:     "cakey",
-			Value:    "",
-			Usage:    "ca key file for http server",
-			EnvVars:  []string{"ACMES_CAKEY"},
+			Name:    "cakey",
+			Value:   "",
+			Usage:   "ca key file for http server",
+			EnvVars: []string{"ACMES_CAKEY"},
 		},
 		&cli.StringFlag{
 			Name:    "level",
@@ -57,25 +79,224 @@ var Command = &cli.Command{
 			EnvVars: []string{"ACMES_LOG_FMT"},
 		},
 		&cli.StringFlag{
-			Required: true,
-			Name:     "store",
-			Value:    "",
-			Usage:    "store for certs",
-			EnvVars:  []string{"ACMES_STORE"},
+			Name:    "store",
+			Value:   "",
+			Usage:   "store for certs",
+			EnvVars: []string{"ACMES_STORE"},
+		},
+		&cli.StringFlag{
+			Name:    "email",
+			Value:   "",
+			Usage:   "user email for acme",
+			EnvVars: []string{"ACMES_EMAIL"},
+		},
+		&cli.StringFlag{
+			Name:    "directory",
+			Value:   "",
+			Usage:   "directory url of the acme ca, default is the production of let's encrypt",
+			EnvVars: []string{"ACMES_DIRECTORY"},
+		},
+		&cli.StringFlag{
+			Name:    "key-type",
+			Value:   "",
+			Usage:   "key type of certificates, one of RSA2048, RSA3072, RSA4096, RSA8192, EC256 and EC384",
+			EnvVars: []string{"ACMES_KEY_TYPE"},
+		},
+		&cli.StringFlag{
+			Name:    "preferred-chain",
+			Value:   "",
+			Usage:   "common name of the root or an intermediate of the chain to pick when the ca offers alternates, such as ISRG Root X1",
+			EnvVars: []string{"ACMES_PREFERRED_CHAIN"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-renew-must-staple",
+			Value:   false,
+			Usage:   "renew must staple only when the request asks for it, renewals are always must staple by default",
+			EnvVars: []string{"ACMES_NO_RENEW_MUST_STAPLE"},
+		},
+		&cli.StringFlag{
+			Name:    "eab-kid",
+			Value:   "",
+			Usage:   "key id of the external account binding, which some cas such as zerossl require",
+			EnvVars: []string{"ACMES_EAB_KID"},
+		},
+		&cli.StringFlag{
+			Name:    "eab-hmac",
+			Value:   "",
+			Usage:   "base64url encoded hmac key of the external account binding",
+			EnvVars: []string{"ACMES_EAB_HMAC"},
+		},
+		&cli.StringFlag{
+			Name:    "provider",
+			Value:   "",
+			Usage:   "dns provider for acme",
+			EnvVars: []string{"ACMES_DNS_PROVIDER"},
+		},
+		&cli.IntFlag{
+			Name:    "dns-port",
+			Value:   0,
+			Usage:   "port for the embedded dns server which answers challenges of provider embedded, disabled when 0",
+			EnvVars: []string{"ACMES_DNS_PORT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "resolver",
+			Usage:   "recursive nameserver such as 8.8.8.8:53, it can be repeated, nameservers of /etc/resolv.conf are used when it is absent",
+			EnvVars: []string{"ACMES_DNS_RESOLVERS"},
+		},
+		&cli.DurationFlag{
+			Name:    "propagation-timeout",
+			Value:   0,
+			Usage:   "max time to wait for challenge records, the default of the provider is used when it is 0",
+			EnvVars: []string{"ACMES_PROPAGATION_TIMEOUT"},
+		},
+		&cli.DurationFlag{
+			Name:    "propagation-interval",
+			Value:   0,
+			Usage:   "time between checks of challenge records, the default of the provider is used when it is 0",
+			EnvVars: []string{"ACMES_PROPAGATION_INTERVAL"},
+		},
+		&cli.BoolFlag{
+			Name:    "skip-authoritative-check",
+			Value:   false,
+			Usage:   "only check challenge records at the resolvers, not at each authoritative nameserver",
+			EnvVars: []string{"ACMES_SKIP_AUTHORITATIVE_CHECK"},
+		},
+		&cli.IntFlag{
+			Name:    "dns-ttl",
+			Value:   0,
+			Usage:   "ttl of challenge records in seconds, the default of the provider is used when it is 0",
+			EnvVars: []string{"ACMES_DNS_TTL"},
+		},
+		&cli.BoolFlag{
+			Name:    "skip-preflight",
+			Value:   false,
+			Usage:   "skip checking caa records and authoritative nameservers of domains before ordering",
+			EnvVars: []string{"ACMES_SKIP_PREFLIGHT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "allow-domain",
+			Usage:   "suffix of domains which are allowed, such as foo.com, it can be repeated, all are allowed when it is absent",
+			EnvVars: []string{"ACMES_ALLOW_DOMAINS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "deny-domain",
+			Usage:   "suffix of domains which are denied, it can be repeated and takes precedence over allow-domain",
+			EnvVars: []string{"ACMES_DENY_DOMAINS"},
+		},
+		&cli.IntFlag{
+			Name:    "metrics-port",
+			Value:   0,
+			Usage:   "port for plain http prometheus metrics, disabled when 0",
+			EnvVars: []string{"ACMES_METRICS_PORT"},
+		},
+		&cli.IntFlag{
+			Name:    "probe-port",
+			Value:   0,
+			Usage:   "port for plain http health and readiness probes, disabled when 0",
+			EnvVars: []string{"ACMES_PROBE_PORT"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "notify",
+			Usage:   "notifier url, webhook+https://{host}/{path}, slack+https://{slack webhook} or smtp://{user}:{password}@{host}:{port}?from={from}&to={to}",
+			EnvVars: []string{"ACMES_NOTIFY"},
+		},
+		&cli.DurationFlag{
+			Name:    "notify-warning",
+			Value:   14 * 24 * time.Hour,
+			Usage:   "notify certificates which expire within the duration",
+			EnvVars: []string{"ACMES_NOTIFY_WARNING"},
+		},
+		&cli.DurationFlag{
+			Name:    "notify-cooldown",
+			Value:   6 * time.Hour,
+			Usage:   "min interval between notifications of the same kind for one domain",
+			EnvVars: []string{"ACMES_NOTIFY_COOLDOWN"},
 		},
 		&cli.StringFlag{
-			Required: true,
-			Name:     "email",
-			Value:    "",
-			Usage:    "user email for acme",
-			EnvVars:  []string{"ACMES_EMAIL"},
+			Name:    "audit",
+			Value:   "",
+			Usage:   "audit log of certificate operations, store or file:///some_path/audit.jsonl, disabled when empty",
+			EnvVars: []string{"ACMES_AUDIT"},
 		},
 		&cli.StringFlag{
-			Required: true,
-			Name:     "provider",
-			Value:    "",
-			Usage:    "dns provider for acme",
-			EnvVars:  []string{"ACMES_DNS_PROVIDER"},
+			Name:    "otlp-endpoint",
+			Value:   "",
+			Usage:   "otlp http endpoint for tracing, such as http://127.0.0.1:4318, disabled when empty",
+			EnvVars: []string{"ACMES_OTLP_ENDPOINT"},
+		},
+		&cli.DurationFlag{
+			Name:    "shutdown-timeout",
+			Value:   60 * time.Second,
+			Usage:   "max time to wait for in-flight orders and background jobs on SIGINT or SIGTERM",
+			EnvVars: []string{"ACMES_SHUTDOWN_TIMEOUT"},
 		},
 	},
 }
+
+// mergeFlags sets config by flags and env vars which are set, or by all flags including their defaults when all is true,
+// defaults are set before the config file is decoded, so that only fields which are absent in the file keep them.
+func mergeFlags(c *cli.Context, config *Config, all bool) {
+	flagInt(c, all, "port", &config.Port)
+	flagInt(c, all, "grpc-port", &config.GRPC.Port)
+	flagString(c, all, "ca", &config.TLS.CA)
+	flagString(c, all, "cakey", &config.TLS.Key)
+	flagString(c, all, "level", &config.Log.Level)
+	flagString(c, all, "formatter", &config.Log.Formatter)
+	flagString(c, all, "store", &config.Store)
+	flagString(c, all, "email", &config.ACME.Email)
+	flagString(c, all, "directory", &config.ACME.Directory)
+	flagString(c, all, "key-type", &config.ACME.KeyType)
+	flagString(c, all, "preferred-chain", &config.ACME.PreferredChain)
+	flagBool(c, all, "no-renew-must-staple", &config.ACME.DisableRenewMustStaple)
+	flagString(c, all, "eab-kid", &config.ACME.EAB.KID)
+	flagString(c, all, "eab-hmac", &config.ACME.EAB.HMACKey)
+	flagString(c, all, "provider", &config.DNS.Provider)
+	flagInt(c, all, "dns-port", &config.DNS.Embedded.Port)
+	flagStrings(c, all, "resolver", &config.DNS.Resolvers)
+	flagDuration(c, all, "propagation-timeout", &config.DNS.Propagation.Timeout)
+	flagDuration(c, all, "propagation-interval", &config.DNS.Propagation.Interval)
+	flagBool(c, all, "skip-authoritative-check", &config.DNS.Propagation.DisableAuthoritativeCheck)
+	flagInt(c, all, "dns-ttl", &config.DNS.TTL)
+	flagBool(c, all, "skip-preflight", &config.Preflight.Disabled)
+	flagStrings(c, all, "allow-domain", &config.Domains.Allow)
+	flagStrings(c, all, "deny-domain", &config.Domains.Deny)
+	flagInt(c, all, "metrics-port", &config.Metrics.Port)
+	flagInt(c, all, "probe-port", &config.Probes.Port)
+	flagStrings(c, all, "notify", &config.Notify.Notifiers)
+	flagDuration(c, all, "notify-warning", &config.Notify.Warning)
+	flagDuration(c, all, "notify-cooldown", &config.Notify.Cooldown)
+	flagString(c, all, "audit", &config.Audit)
+	flagString(c, all, "otlp-endpoint", &config.Tracing.Endpoint)
+	flagDuration(c, all, "shutdown-timeout", &config.Shutdown.Timeout)
+}
+
+func flagString(c *cli.Context, all bool, name string, v *string) {
+	if all || c.IsSet(name) {
+		*v = c.String(name)
+	}
+	*v = strings.TrimSpace(*v)
+}
+
+func flagStrings(c *cli.Context, all bool, name string, v *[]string) {
+	if all || c.IsSet(name) {
+		*v = c.StringSlice(name)
+	}
+}
+
+func flagBool(c *cli.Context, all bool, name string, v *bool) {
+	if all || c.IsSet(name) {
+		*v = c.Bool(name)
+	}
+}
+
+func flagInt(c *cli.Context, all bool, name string, v *int) {
+	if all || c.IsSet(name) {
+		*v = c.Int(name)
+	}
+}
+
+func flagDuration(c *cli.Context, all bool, name string, v *time.Duration) {
+	if all || c.IsSet(name) {
+		*v = c.Duration(name)
+	}
+}
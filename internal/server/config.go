@@ -0,0 +1,562 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/aacfactory/acmes/internal/notify"
+	"github.com/aacfactory/acmes/internal/responder"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Port      int             `yaml:"port" toml:"port"`
+	GRPC      GRPCConfig      `yaml:"grpc" toml:"grpc"`
+	TLS       TLSConfig       `yaml:"tls" toml:"tls"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Store     string          `yaml:"store" toml:"store"`
+	ACME      ACMEConfig      `yaml:"acme" toml:"acme"`
+	DNS       DNSConfig       `yaml:"dns" toml:"dns"`
+	Metrics   MetricsConfig   `yaml:"metrics" toml:"metrics"`
+	Probes    ProbesConfig    `yaml:"probes" toml:"probes"`
+	Notify    NotifyConfig    `yaml:"notify" toml:"notify"`
+	Domains   DomainsConfig   `yaml:"domains" toml:"domains"`
+	Audit     string          `yaml:"audit" toml:"audit"`
+	RateLimit RateLimitConfig `yaml:"rateLimit" toml:"rateLimit"`
+	Preflight PreflightConfig `yaml:"preflight" toml:"preflight"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Shutdown  ShutdownConfig  `yaml:"shutdown" toml:"shutdown"`
+}
+
+type GRPCConfig struct {
+	// Port serves the grpc api over the same mtls as the http api, disabled when 0.
+	Port int `yaml:"port" toml:"port"`
+}
+
+type TLSConfig struct {
+	CA  string `yaml:"ca" toml:"ca"`
+	Key string `yaml:"key" toml:"key"`
+}
+
+type LogConfig struct {
+	Level     string `yaml:"level" toml:"level"`
+	Formatter string `yaml:"formatter" toml:"formatter"`
+}
+
+// ACMEConfig is the default acme account, Accounts are named ones beside it.
+type ACMEConfig struct {
+	Email string `yaml:"email" toml:"email"`
+	// Directory is the directory url of the ca, default is the production of let's encrypt.
+	Directory string `yaml:"directory" toml:"directory"`
+	// KeyType is the key type of certificates, one of RSA2048, RSA3072, RSA4096, RSA8192, EC256 and EC384, default is RSA2048.
+	KeyType string `yaml:"keyType" toml:"keyType"`
+	// EAB binds the account to an account of the ca, some cas such as zerossl require it.
+	EAB EABConfig `yaml:"eab" toml:"eab"`
+	// PreferredChain is the common name of the root or an intermediate of the chain to pick when the ca offers alternates,
+	// such as ISRG Root X1, the default chain of the ca is used when it is empty or not offered.
+	PreferredChain string `yaml:"preferredChain" toml:"preferredChain"`
+	// DisableRenewMustStaple makes renewals must staple only when the request asks for it, like obtain,
+	// renewals of all accounts are must staple when it is false.
+	DisableRenewMustStaple bool            `yaml:"disableRenewMustStaple" toml:"disableRenewMustStaple"`
+	Accounts               []AccountConfig `yaml:"accounts" toml:"accounts"`
+}
+
+// AccountConfig is a named acme account, requests pick it by name, or by Domains when they name no account.
+type AccountConfig struct {
+	Name      string    `yaml:"name" toml:"name"`
+	Email     string    `yaml:"email" toml:"email"`
+	Directory string    `yaml:"directory" toml:"directory"`
+	KeyType   string    `yaml:"keyType" toml:"keyType"`
+	EAB       EABConfig `yaml:"eab" toml:"eab"`
+	// PreferredChain is the chain of the ca of the account, acme.preferredChain is used when it is empty.
+	PreferredChain string `yaml:"preferredChain" toml:"preferredChain"`
+	// Domains are suffixes of domains which use the account when requests name no account, such as foo.com.
+	Domains []string `yaml:"domains" toml:"domains"`
+	// Clients are common names of client certificates which can use the account, every client can use it when it is empty.
+	Clients []string `yaml:"clients" toml:"clients"`
+}
+
+// EABConfig is the external account binding of an acme account.
+type EABConfig struct {
+	KID     string `yaml:"kid" toml:"kid"`
+	HMACKey string `yaml:"hmacKey" toml:"hmacKey"`
+}
+
+type DNSConfig struct {
+	Provider string `yaml:"provider" toml:"provider"`
+	// Env is set into environment variables before the provider is created, lego providers read credentials from them.
+	Env map[string]string `yaml:"env" toml:"env"`
+	// Resolvers are recursive nameservers such as 8.8.8.8:53, nameservers of /etc/resolv.conf are used when it is empty.
+	// They are used by pre-flight checks and propagation checks.
+	Resolvers []string `yaml:"resolvers" toml:"resolvers"`
+	// TTL of challenge records in seconds, the default of the provider is used when it is 0.
+	TTL int `yaml:"ttl" toml:"ttl"`
+	// Propagation controls how long to wait for challenge records before the ca is asked to validate them.
+	Propagation PropagationConfig `yaml:"propagation" toml:"propagation"`
+	// Aliases write challenge records of some domains into delegated zones.
+	Aliases []AliasConfig `yaml:"aliases" toml:"aliases"`
+	// Embedded serves challenge records by acmes itself, it is used by the provider named embedded.
+	Embedded EmbeddedConfig `yaml:"embedded" toml:"embedded"`
+}
+
+// EmbeddedConfig is the authoritative dns server of acmes, zones or _acme-challenge names are delegated to it by NS records.
+type EmbeddedConfig struct {
+	// Port serves udp and tcp, such as 53, disabled when 0.
+	Port int `yaml:"port" toml:"port"`
+	// Zones are zones delegated to acmes, such as acme.foo.com, names of challenge records are zones of their own when it is empty.
+	Zones []string `yaml:"zones" toml:"zones"`
+	// Nameserver is the host name of acmes in NS records, such as ns.acme.foo.com.
+	Nameserver string `yaml:"nameserver" toml:"nameserver"`
+}
+
+// AliasConfig writes challenge records of domains into a delegated zone,
+// _acme-challenge of the domains must be a cname to the record in the zone.
+type AliasConfig struct {
+	// Domain is the suffix of domains, such as foo.com which matches foo.com and its subdomains.
+	Domain string `yaml:"domain" toml:"domain"`
+	// Alias is the domain whose _acme-challenge record is written instead, such as foo-com.bar.net,
+	// cnames of _acme-challenge of the domain are followed when it is empty. It can not be used with acme-dns.
+	Alias string `yaml:"alias" toml:"alias"`
+	// Provider writes records of the delegated zone, such as acme-dns, dns.provider is used when it is empty.
+	Provider string `yaml:"provider" toml:"provider"`
+	// Env is set into environment variables before Provider is created.
+	Env map[string]string `yaml:"env" toml:"env"`
+	// TTL of challenge records of Provider in seconds, the default of the provider is used when it is 0.
+	TTL int `yaml:"ttl" toml:"ttl"`
+}
+
+// PropagationConfig controls the check of challenge records, which runs before the ca is asked to validate them.
+type PropagationConfig struct {
+	// Timeout is the max time to wait for records, the default of the provider is used when it is 0.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// Interval is the time between checks, the default of the provider is used when it is 0.
+	Interval time.Duration `yaml:"interval" toml:"interval"`
+	// DisableAuthoritativeCheck only asks each resolver for the value of the record, and does not ask the authoritative nameservers of the zone,
+	// such as when they are not reachable behind split-horizon dns.
+	DisableAuthoritativeCheck bool `yaml:"disableAuthoritativeCheck" toml:"disableAuthoritativeCheck"`
+}
+
+// DomainsConfig limits domains which can be ordered by suffixes, such as foo.com which matches foo.com and *.foo.com.
+type DomainsConfig struct {
+	// Allow is the suffixes which are allowed, all domains are allowed when it is empty.
+	Allow []string `yaml:"allow" toml:"allow"`
+	// Deny is the suffixes which are denied, it takes precedence over Allow.
+	Deny []string `yaml:"deny" toml:"deny"`
+}
+
+type MetricsConfig struct {
+	Port int `yaml:"port" toml:"port"`
+}
+
+type ProbesConfig struct {
+	Port int `yaml:"port" toml:"port"`
+}
+
+type NotifyConfig struct {
+	Notifiers []string      `yaml:"notifiers" toml:"notifiers"`
+	Warning   time.Duration `yaml:"warning" toml:"warning"`
+	Cooldown  time.Duration `yaml:"cooldown" toml:"cooldown"`
+}
+
+// RateLimitConfig limits orders before they are placed at the ca, zero fields take defaults of let's encrypt.
+// A negative limit turns that limit off and keeps the others, such as DomainCertificates -1 for a ca without it.
+type RateLimitConfig struct {
+	// Disabled turns limits off, such as for a private ca.
+	Disabled bool `yaml:"disabled" toml:"disabled"`
+	// ClientOrders is the max orders of one client in ClientWindow, default is 20 per hour.
+	ClientOrders int           `yaml:"clientOrders" toml:"clientOrders"`
+	ClientWindow time.Duration `yaml:"clientWindow" toml:"clientWindow"`
+	// AccountOrders is the max orders of each acme account in AccountWindow, default is 300 per 3 hours.
+	AccountOrders int           `yaml:"accountOrders" toml:"accountOrders"`
+	AccountWindow time.Duration `yaml:"accountWindow" toml:"accountWindow"`
+	// DomainCertificates is the max certificates of one registered domain in DomainWindow, default is 50 per week.
+	DomainCertificates int           `yaml:"domainCertificates" toml:"domainCertificates"`
+	DomainWindow       time.Duration `yaml:"domainWindow" toml:"domainWindow"`
+	// FailedValidations is the max failed validations of one domain in FailedWindow, default is 5 per hour.
+	FailedValidations int           `yaml:"failedValidations" toml:"failedValidations"`
+	FailedWindow      time.Duration `yaml:"failedWindow" toml:"failedWindow"`
+}
+
+func (config RateLimitConfig) withDefaults() RateLimitConfig {
+	defaultInt := func(v *int, def int) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	defaultDuration := func(v *time.Duration, def time.Duration) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+	defaultInt(&config.ClientOrders, 20)
+	defaultDuration(&config.ClientWindow, time.Hour)
+	defaultInt(&config.AccountOrders, 300)
+	defaultDuration(&config.AccountWindow, 3*time.Hour)
+	defaultInt(&config.DomainCertificates, 50)
+	defaultDuration(&config.DomainWindow, 7*24*time.Hour)
+	defaultInt(&config.FailedValidations, 5)
+	defaultDuration(&config.FailedWindow, time.Hour)
+	return config
+}
+
+// PreflightConfig checks caa records and authoritative nameservers of domains before orders are placed.
+type PreflightConfig struct {
+	Disabled bool `yaml:"disabled" toml:"disabled"`
+	// CAAIdentities are identities of the ca in caa records, default is letsencrypt.org.
+	CAAIdentities []string `yaml:"caaIdentities" toml:"caaIdentities"`
+	// Nameservers are substrings of authoritative nameservers of the dns provider, such as awsdns-,
+	// well-known ones of the provider are used when it is empty, and the check is skipped when they are unknown.
+	Nameservers []string `yaml:"nameservers" toml:"nameservers"`
+}
+
+type TracingConfig struct {
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+}
+
+type ShutdownConfig struct {
+	// Timeout is how long to wait for in-flight orders and background jobs on SIGINT or SIGTERM.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+var envPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?}`)
+
+// interpolate replaces ${NAME} and ${NAME:-default} by environment variables,
+// a variable without default must be set. Comments are kept as they are.
+func interpolate(content []byte) (v []byte, err error) {
+	missing := make([]string, 0, 1)
+	replace := func(match []byte) []byte {
+		groups := envPattern.FindSubmatch(match)
+		name := string(groups[1])
+		value, has := os.LookupEnv(name)
+		if has {
+			return []byte(value)
+		}
+		if len(groups[2]) > 0 {
+			return groups[3]
+		}
+		missing = append(missing, name)
+		return match
+	}
+	v = make([]byte, 0, len(content))
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		comment := commentAt(line)
+		v = append(v, envPattern.ReplaceAllFunc(line[:comment], replace)...)
+		v = append(v, line[comment:]...)
+	}
+	if len(missing) == 1 {
+		err = fmt.Errorf("environment variable %s is not set", missing[0])
+		return
+	}
+	if len(missing) > 1 {
+		err = fmt.Errorf("environment variables %s are not set", strings.Join(missing, ", "))
+		return
+	}
+	return
+}
+
+// commentAt returns where the comment of line begins, or the length of line when it has none.
+// A comment begins by # at the beginning of the line or after a space, out of quotes, in both yaml and toml.
+func commentAt(line []byte) int {
+	quote := byte(0)
+	for i, b := range line {
+		switch {
+		case quote != 0:
+			if b == quote && (quote == '\'' || line[i-1] != '\\') {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return i
+		}
+	}
+	return len(line)
+}
+
+// loadConfig decodes the config file into config, the format is toml when the extension is .toml, otherwise yaml,
+// fields which are absent in the file are kept.
+func loadConfig(path string, config *Config) (err error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		err = fmt.Errorf("acmes: read config failed, %v", readErr)
+		return
+	}
+	content, err = interpolate(content)
+	if err != nil {
+		err = fmt.Errorf("acmes: read config %s failed, %v", path, err)
+		return
+	}
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		meta, decodeErr := toml.NewDecoder(bytes.NewReader(content)).Decode(config)
+		if decodeErr != nil {
+			err = fmt.Errorf("acmes: decode config %s failed, %v", path, decodeErr)
+			return
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			err = fmt.Errorf("acmes: decode config %s failed, unknown field %s", path, undecoded[0].String())
+			return
+		}
+		return
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	decodeErr := decoder.Decode(config)
+	if decodeErr != nil && !errors.Is(decodeErr, io.EOF) {
+		err = fmt.Errorf("acmes: decode config %s failed, %v", path, decodeErr)
+		return
+	}
+	return
+}
+
+// LoadAccount reads the config file at path, and returns its store and the account named name in it,
+// the default account when name is empty, so that commands locate the account which serve uses.
+func LoadAccount(path string, name string) (storeURL string, account AccountConfig, err error) {
+	config := &Config{}
+	loadErr := loadConfig(path, config)
+	if loadErr != nil {
+		err = loadErr
+		return
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = defaultAccount
+	}
+	for _, item := range config.ACME.accounts() {
+		if item.Name == name {
+			storeURL = strings.TrimSpace(config.Store)
+			account = item
+			return
+		}
+	}
+	err = fmt.Errorf("acmes: account %s is not in config %s", name, path)
+	return
+}
+
+// Validate reports all invalid fields at once.
+func (config *Config) Validate() (err error) {
+	problems := make([]string, 0, 1)
+	checkPort := func(name string, port int) {
+		if port < 0 || port > 65535 {
+			problems = append(problems, fmt.Sprintf("%s %d is out of range", name, port))
+		}
+	}
+	checkPort("port", config.Port)
+	checkPort("grpc.port", config.GRPC.Port)
+	checkPort("dns.embedded.port", config.DNS.Embedded.Port)
+	checkPort("metrics.port", config.Metrics.Port)
+	checkPort("probes.port", config.Probes.Port)
+	if config.TLS.CA == "" {
+		problems = append(problems, "tls.ca is required")
+	}
+	if config.TLS.Key == "" {
+		problems = append(problems, "tls.key is required")
+	}
+	switch strings.ToLower(config.Log.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		problems = append(problems, fmt.Sprintf("log.level %s is not one of debug, info, warn and error", config.Log.Level))
+	}
+	switch strings.ToLower(config.Log.Formatter) {
+	case "", "text", "text_colorful", "json":
+	default:
+		problems = append(problems, fmt.Sprintf("log.formatter %s is not one of text, text_colorful and json", config.Log.Formatter))
+	}
+	if config.Store == "" {
+		problems = append(problems, "store is required")
+	} else if !strings.HasPrefix(config.Store, "file://") {
+		problems = append(problems, fmt.Sprintf("store %s is not support, use file:///some_dir_path", config.Store))
+	}
+	if config.ACME.Email == "" {
+		problems = append(problems, "acme.email is required")
+	}
+	names := make(map[string]struct{})
+	emails := make(map[string]struct{})
+	for i, account := range config.ACME.accounts() {
+		field := "acme"
+		if i > 0 {
+			field = fmt.Sprintf("acme.accounts[%d]", i-1)
+			if !accountNamePattern.MatchString(account.Name) {
+				problems = append(problems, fmt.Sprintf("%s.name %s must match %s", field, account.Name, accountNamePattern.String()))
+			}
+			if account.Email == "" {
+				problems = append(problems, fmt.Sprintf("%s.email is required", field))
+			}
+		}
+		if _, has := names[account.Name]; has {
+			problems = append(problems, fmt.Sprintf("%s.name %s is duplicated", field, account.Name))
+		}
+		names[account.Name] = struct{}{}
+		// certificates and users are kept by email in the store
+		if _, has := emails[account.Email]; has && account.Email != "" {
+			problems = append(problems, fmt.Sprintf("%s.email %s is used by another account", field, account.Email))
+		}
+		emails[account.Email] = struct{}{}
+		if account.Directory != "" {
+			u, parseErr := url.Parse(account.Directory)
+			if parseErr != nil || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("%s.directory %s is not an url", field, account.Directory))
+			}
+		}
+		if _, keyTypeErr := parseKeyType(account.KeyType); keyTypeErr != nil {
+			problems = append(problems, fmt.Sprintf("%s.keyType is invalid, %v", field, keyTypeErr))
+		}
+		if (account.EAB.KID == "") != (account.EAB.HMACKey == "") {
+			problems = append(problems, fmt.Sprintf("%s.eab needs both kid and hmacKey", field))
+		}
+		for j, domain := range account.Domains {
+			if _, domainErr := normalizeSuffix(domain); domainErr != nil {
+				problems = append(problems, fmt.Sprintf("%s.domains[%d] is invalid, %v", field, j, domainErr))
+			}
+		}
+	}
+	if config.DNS.Provider == "" {
+		problems = append(problems, "dns.provider is required")
+	}
+	if config.DNS.Embedded.Port == 0 && config.DNS.usesEmbedded() {
+		problems = append(problems, "dns.embedded.port is required by the embedded provider")
+	}
+	if config.DNS.TTL < 0 {
+		problems = append(problems, "dns.ttl must not be negative")
+	} else if config.DNS.TTL > 0 && !supportsTTL(config.DNS.Provider) {
+		problems = append(problems, fmt.Sprintf("dns.ttl of provider %s is not supported, set the ttl env var of the provider instead", config.DNS.Provider))
+	}
+	if config.DNS.Propagation.Timeout < 0 || config.DNS.Propagation.Interval < 0 {
+		problems = append(problems, "dns.propagation durations must not be negative")
+	}
+	for i, alias := range config.DNS.Aliases {
+		if alias.TTL < 0 {
+			problems = append(problems, fmt.Sprintf("dns.aliases[%d].ttl must not be negative", i))
+		} else if alias.TTL > 0 && (alias.Provider == "" || !supportsTTL(alias.Provider)) {
+			problems = append(problems, fmt.Sprintf("dns.aliases[%d].ttl of provider %s is not supported, set the ttl env var of the provider instead", i, alias.Provider))
+		}
+		if _, domainErr := normalizeSuffix(alias.Domain); domainErr != nil {
+			problems = append(problems, fmt.Sprintf("dns.aliases[%d].domain is invalid, %v", i, domainErr))
+		}
+		if alias.Provider == "" && len(alias.Env) > 0 {
+			problems = append(problems, fmt.Sprintf("dns.aliases[%d].env requires provider, env of dns.provider is set by dns.env", i))
+		}
+		if alias.Alias != "" {
+			if _, aliasErr := normalizeSuffix(alias.Alias); aliasErr != nil {
+				problems = append(problems, fmt.Sprintf("dns.aliases[%d].alias is invalid, %v", i, aliasErr))
+			}
+			provider := alias.Provider
+			if provider == "" {
+				provider = config.DNS.Provider
+			}
+			// acme-dns keeps its accounts by the name it is given, an alias would share one account among the domains
+			if strings.EqualFold(strings.TrimSpace(provider), acmeDNSProvider) {
+				problems = append(problems, fmt.Sprintf("dns.aliases[%d].alias can not be used with %s, its cname already delegates the domain", i, acmeDNSProvider))
+			}
+		}
+	}
+	for i, raw := range config.Notify.Notifiers {
+		if _, notifierErr := notify.New(raw); notifierErr != nil {
+			problems = append(problems, fmt.Sprintf("notify.notifiers[%d] is invalid, %v", i, notifierErr))
+		}
+	}
+	if _, policyErr := createDomainPolicy(config.Domains); policyErr != nil {
+		problems = append(problems, policyErr.Error())
+	}
+	if config.Notify.Warning < 0 {
+		problems = append(problems, "notify.warning must not be negative")
+	}
+	if config.Notify.Cooldown < 0 {
+		problems = append(problems, "notify.cooldown must not be negative")
+	}
+	if config.Audit != "" && config.Audit != "store" && !strings.HasPrefix(config.Audit, "file://") {
+		problems = append(problems, fmt.Sprintf("audit %s is not support, use store or file:///some_path/audit.jsonl", config.Audit))
+	}
+	// negative limits disable their own limit, windows have no such meaning
+	rateLimit := config.RateLimit
+	if rateLimit.ClientWindow < 0 || rateLimit.AccountWindow < 0 || rateLimit.DomainWindow < 0 || rateLimit.FailedWindow < 0 {
+		problems = append(problems, "rateLimit windows must not be negative")
+	}
+	if config.Tracing.Endpoint != "" {
+		u, parseErr := url.Parse(config.Tracing.Endpoint)
+		if parseErr != nil || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("tracing.endpoint %s is not an url", config.Tracing.Endpoint))
+		}
+	}
+	if config.Shutdown.Timeout < 0 {
+		problems = append(problems, "shutdown.timeout must not be negative")
+	}
+	if len(problems) > 0 {
+		err = fmt.Errorf("acmes: config is invalid, %s", strings.Join(problems, "; "))
+		return
+	}
+	return
+}
+
+// defaultAccount is the name of the account of the top level fields of acme.
+const defaultAccount = "default"
+
+var accountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// accounts returns the default account first, then the named ones.
+func (config *ACMEConfig) accounts() []AccountConfig {
+	accounts := make([]AccountConfig, 0, len(config.Accounts)+1)
+	accounts = append(accounts, AccountConfig{
+		Name:           defaultAccount,
+		Email:          config.Email,
+		Directory:      config.Directory,
+		KeyType:        config.KeyType,
+		EAB:            config.EAB,
+		PreferredChain: config.PreferredChain,
+	})
+	for _, account := range config.Accounts {
+		if account.PreferredChain == "" {
+			account.PreferredChain = config.PreferredChain
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts
+}
+
+// usesEmbedded reports whether the provider or a provider of aliases is the embedded dns server.
+func (config *DNSConfig) usesEmbedded() bool {
+	if strings.ToLower(config.Provider) == responder.Name {
+		return true
+	}
+	for _, alias := range config.Aliases {
+		if strings.ToLower(alias.Provider) == responder.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// ttlOf returns the ttl of provider, which is set at dns or at an alias whose provider is it.
+func (config *DNSConfig) ttlOf(provider string) int {
+	if strings.EqualFold(config.Provider, provider) && config.TTL > 0 {
+		return config.TTL
+	}
+	for _, alias := range config.Aliases {
+		if strings.EqualFold(alias.Provider, provider) && alias.TTL > 0 {
+			return alias.TTL
+		}
+	}
+	return 0
+}
+
+func (config *DNSConfig) setEnv() (err error) {
+	err = setProviderEnv(config.Env)
+	return
+}
+
+func setProviderEnv(env map[string]string) (err error) {
+	for name, value := range env {
+		err = os.Setenv(name, value)
+		if err != nil {
+			err = fmt.Errorf("acmes: set env %s of dns provider failed, %v", name, err)
+			return
+		}
+	}
+	return
+}
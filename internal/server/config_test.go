@@ -0,0 +1,83 @@
+package server
+
+import (
+	"github.com/urfave/cli/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInterpolate(t *testing.T) {
+	t.Setenv("ACMES_TEST_EMAIL", "foo@bar.com")
+	cases := []struct {
+		content string
+		want    string
+		fail    bool
+	}{
+		{"email: ${ACMES_TEST_EMAIL}\n", "email: foo@bar.com\n", false},
+		{"level: ${ACMES_TEST_LEVEL:-info}", "level: info", false},
+		{"email: ${ACMES_TEST_MISSING}", "", true},
+		// comments are kept, variables in them need not be set
+		{"# token: ${ACMES_TEST_MISSING}\nemail: ${ACMES_TEST_EMAIL}", "# token: ${ACMES_TEST_MISSING}\nemail: foo@bar.com", false},
+		{"  # ${ACMES_TEST_MISSING}", "  # ${ACMES_TEST_MISSING}", false},
+		{"email: ${ACMES_TEST_EMAIL} # was ${ACMES_TEST_MISSING}", "email: foo@bar.com # was ${ACMES_TEST_MISSING}", false},
+		// # in quotes or without a space before it is not a comment
+		{`key: "a # ${ACMES_TEST_EMAIL}"`, `key: "a # foo@bar.com"`, false},
+		{"url: https://foo.com/#${ACMES_TEST_EMAIL}", "url: https://foo.com/#foo@bar.com", false},
+	}
+	for _, c := range cases {
+		v, err := interpolate([]byte(c.content))
+		if c.fail {
+			if err == nil {
+				t.Errorf("%q is interpolated without its variable", c.content)
+			}
+			continue
+		}
+		if err != nil || string(v) != c.want {
+			t.Errorf("%q is interpolated to %q, want %q, %v", c.content, v, c.want, err)
+		}
+	}
+}
+
+func TestMergeFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "acmes.yaml")
+	cases := []struct {
+		file     string
+		args     []string
+		port     int
+		cooldown time.Duration
+	}{
+		// flag defaults fill fields which are absent in the file
+		{"store: file:///tmp/store\n", nil, 80, 6 * time.Hour},
+		// zero in the file is kept, it is not replaced by the flag default
+		{"notify:\n  cooldown: 0s\n", nil, 80, 0},
+		{"port: 8443\n", nil, 8443, 6 * time.Hour},
+		// flags which are set take precedence over the file
+		{"port: 8443\nnotify:\n  cooldown: 1h\n", []string{"--port", "9443", "--notify-cooldown", "0s"}, 9443, 0},
+	}
+	for _, c := range cases {
+		if err := os.WriteFile(path, []byte(c.file), 0600); err != nil {
+			t.Fatal(err)
+		}
+		var config *Config
+		app := &cli.App{
+			Flags: Command.Flags,
+			Action: func(ctx *cli.Context) (err error) {
+				config = &Config{}
+				mergeFlags(ctx, config, true)
+				if err = loadConfig(path, config); err != nil {
+					return
+				}
+				mergeFlags(ctx, config, false)
+				return
+			},
+		}
+		if err := app.Run(append([]string{"acmes"}, c.args...)); err != nil {
+			t.Fatal(err)
+		}
+		if config.Port != c.port || config.Notify.Cooldown != c.cooldown {
+			t.Errorf("%q %v: port is %d and cooldown is %s, want %d and %s", c.file, c.args, config.Port, config.Notify.Cooldown, c.port, c.cooldown)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"strings"
+)
+
+var domainProfile = idna.New(
+	idna.MapForLookup(),
+	idna.ValidateLabels(true),
+	idna.StrictDomainName(true),
+	idna.BidiRule(),
+	idna.Transitional(false),
+)
+
+// normalizeDomain returns the lower case punycode of domain, or an invalid domain error which tells what is wrong.
+// A wildcard is only allowed as the whole leftmost label, and not right above a public suffix.
+func normalizeDomain(domain string) (v string, err error) {
+	raw := domain
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if domain == "" {
+		err = newError(ErrorInvalidDomain, "domain is required")
+		return
+	}
+	wildcard := false
+	if strings.HasPrefix(domain, "*.") {
+		wildcard = true
+		domain = domain[2:]
+	}
+	if strings.Contains(domain, "*") {
+		err = newError(ErrorInvalidDomain, "domain %q is invalid, wildcard is only allowed as the leftmost label", raw)
+		return
+	}
+	if net.ParseIP(domain) != nil {
+		err = newError(ErrorInvalidDomain, "domain %q is invalid, ip address is not supported", raw)
+		return
+	}
+	ascii, asciiErr := domainProfile.ToASCII(domain)
+	if asciiErr != nil {
+		err = newError(ErrorInvalidDomain, "domain %q is invalid, %v", raw, asciiErr)
+		return
+	}
+	if len(ascii) > 253 {
+		err = newError(ErrorInvalidDomain, "domain %q is invalid, it is longer than 253", raw)
+		return
+	}
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		err = newError(ErrorInvalidDomain, "domain %q is invalid, it has no parent domain", raw)
+		return
+	}
+	for _, label := range labels {
+		if label == "" {
+			err = newError(ErrorInvalidDomain, "domain %q is invalid, it has an empty label", raw)
+			return
+		}
+		if len(label) > 63 {
+			err = newError(ErrorInvalidDomain, "domain %q is invalid, label %s is longer than 63", raw, label)
+			return
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			err = newError(ErrorInvalidDomain, "domain %q is invalid, label %s starts or ends with hyphen", raw, label)
+			return
+		}
+	}
+	if suffix, _ := publicsuffix.PublicSuffix(ascii); suffix == ascii {
+		err = newError(ErrorInvalidDomain, "domain %q is invalid, it is a public suffix", raw)
+		return
+	}
+	v = ascii
+	if wildcard {
+		v = "*." + ascii
+	}
+	return
+}
+
+// domainPolicy allows domains which match one of allow suffixes when allow is not empty, and denies those which match one of deny suffixes.
+// Deny takes precedence over allow.
+type domainPolicy struct {
+	allow []string
+	deny  []string
+}
+
+func createDomainPolicy(config DomainsConfig) (policy *domainPolicy, err error) {
+	policy = &domainPolicy{
+		allow: make([]string, 0, len(config.Allow)),
+		deny:  make([]string, 0, len(config.Deny)),
+	}
+	for _, suffix := range config.Allow {
+		normalized, normalizeErr := normalizeSuffix(suffix)
+		if normalizeErr != nil {
+			err = fmt.Errorf("domains.allow %s is invalid, %v", suffix, normalizeErr)
+			return
+		}
+		policy.allow = append(policy.allow, normalized)
+	}
+	for _, suffix := range config.Deny {
+		normalized, normalizeErr := normalizeSuffix(suffix)
+		if normalizeErr != nil {
+			err = fmt.Errorf("domains.deny %s is invalid, %v", suffix, normalizeErr)
+			return
+		}
+		policy.deny = append(policy.deny, normalized)
+	}
+	return
+}
+
+// normalizeSuffix accepts public suffixes too, such as com, so that a whole tld can be denied.
+func normalizeSuffix(suffix string) (v string, err error) {
+	suffix = strings.TrimPrefix(strings.TrimSuffix(strings.TrimSpace(suffix), "."), ".")
+	v, err = domainProfile.ToASCII(suffix)
+	if err == nil && v == "" {
+		err = fmt.Errorf("suffix is empty")
+	}
+	return
+}
+
+func (policy *domainPolicy) check(domain string) (err error) {
+	name := strings.TrimPrefix(domain, "*.")
+	for _, suffix := range policy.deny {
+		if matchSuffix(name, suffix) {
+			err = newError(ErrorForbidden, "domain %s is denied by %s", domain, suffix)
+			return
+		}
+	}
+	if len(policy.allow) == 0 {
+		return
+	}
+	for _, suffix := range policy.allow {
+		if matchSuffix(name, suffix) {
+			return
+		}
+	}
+	err = newError(ErrorForbidden, "domain %s is not allowed", domain)
+	return
+}
+
+func matchSuffix(domain string, suffix string) bool {
+	return domain == suffix || strings.HasSuffix(domain, "."+suffix)
+}
+
+// pickAccount checks domain like checkDomain, then picks the account by name or by the normalized domain,
+// so that the suffixes of accounts match the domain which is ordered, who must be a client of the account.
+func (handler *Handler) pickAccount(who requester, name string, domain string) (normalized string, acct *account, err error) {
+	normalized, err = handler.checkDomain(domain)
+	if err != nil {
+		return
+	}
+	acct, err = handler.accounts.pick(name, normalized)
+	if err != nil {
+		return
+	}
+	if !acct.allows(who) {
+		err = newError(ErrorForbidden, "client %s can not use account %s", who.client, acct.name)
+		acct = nil
+	}
+	return
+}
+
+// checkDomain normalizes domain and checks it by the policy, the normalized one is used in orders and store keys.
+func (handler *Handler) checkDomain(domain string) (v string, err error) {
+	v, err = normalizeDomain(domain)
+	if err != nil {
+		return
+	}
+	if policy := handler.policy.Load(); policy != nil {
+		err = policy.check(v)
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	cases := []struct {
+		domain string
+		want   string
+	}{
+		{"www.foo.com", "www.foo.com"},
+		{" WWW.Foo.COM ", "www.foo.com"},
+		{"www.foo.com.", "www.foo.com"},
+		{"*.foo.com", "*.foo.com"},
+		{"*.foo.co.uk", "*.foo.co.uk"},
+		{"Bücher.example", "xn--bcher-kva.example"},
+		{strings.Repeat("a", 63) + ".foo.com", strings.Repeat("a", 63) + ".foo.com"},
+		{"", ""},
+		{".", ""},
+		{"../x", ""},
+		{"a/b", ""},
+		{"foo..com", ""},
+		{"localhost", ""},
+		{"*.com", ""},
+		{"*.co.uk", ""},
+		{"co.uk", ""},
+		{"foo.*.com", ""},
+		{"*foo.com", ""},
+		{"*.*.foo.com", ""},
+		{"192.168.0.1", ""},
+		{"::1", ""},
+		{"-www.foo.com", ""},
+		{"www-.foo.com", ""},
+		{strings.Repeat("a", 64) + ".foo.com", ""},
+		{strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com", ""},
+	}
+	for _, c := range cases {
+		got, err := normalizeDomain(c.domain)
+		if c.want == "" {
+			invalid := &Error{}
+			if err == nil || !errors.As(err, &invalid) || invalid.Code != ErrorInvalidDomain {
+				t.Errorf("%q is normalized to %q, %v", c.domain, got, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%q is normalized to %q, want %q, %v", c.domain, got, c.want, err)
+		}
+	}
+}
+
+func TestDomainPolicy_check(t *testing.T) {
+	policy, err := createDomainPolicy(DomainsConfig{
+		Allow: []string{"foo.com", ".bar.org."},
+		Deny:  []string{"admin.foo.com", "secret.bar.org"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	denyOnly, err := createDomainPolicy(DomainsConfig{Deny: []string{"com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		policy  *domainPolicy
+		domain  string
+		allowed bool
+	}{
+		{policy, "foo.com", true},
+		{policy, "www.foo.com", true},
+		{policy, "*.foo.com", true},
+		{policy, "www.bar.org", true},
+		// deny takes precedence over allow
+		{policy, "admin.foo.com", false},
+		{policy, "www.admin.foo.com", false},
+		{policy, "*.admin.foo.com", false},
+		{policy, "secret.bar.org", false},
+		// suffixes match whole labels
+		{policy, "notfoo.com", false},
+		{policy, "foo.com.evil.net", false},
+		{policy, "baz.net", false},
+		{denyOnly, "www.foo.com", false},
+		{denyOnly, "www.foo.org", true},
+		{&domainPolicy{}, "www.foo.com", true},
+	}
+	for _, c := range cases {
+		checkErr := c.policy.check(c.domain)
+		if c.allowed != (checkErr == nil) {
+			t.Errorf("%s is allowed %v, want %v, %v", c.domain, checkErr == nil, c.allowed, checkErr)
+			continue
+		}
+		forbidden := &Error{}
+		if checkErr != nil && (!errors.As(checkErr, &forbidden) || forbidden.Code != ErrorForbidden) {
+			t.Errorf("%s is not forbidden, %v", c.domain, checkErr)
+		}
+	}
+	if _, err = createDomainPolicy(DomainsConfig{Allow: []string{" . "}}); err == nil {
+		t.Fatal("empty suffix is valid")
+	}
+}
+
+func TestHandler_pickAccount(t *testing.T) {
+	main := &account{name: "main"}
+	books := &account{name: "books", domains: []string{"xn--bcher-kva.example"}}
+	handler := &Handler{
+		accounts: &accounts{
+			list:     []*account{main, books},
+			byName:   map[string]*account{"main": main, "books": books},
+			suffixes: []accountSuffix{{suffix: "xn--bcher-kva.example", account: books}},
+		},
+	}
+	cases := []struct {
+		name   string
+		domain string
+		want   *account
+	}{
+		{"", "www.Bücher.example.", books},
+		{"", "*.BÜCHER.example", books},
+		{"", "www.xn--bcher-kva.example", books},
+		{"", "www.foo.com", main},
+		{"main", "www.bücher.example", main},
+	}
+	for _, c := range cases {
+		domain, acct, err := handler.pickAccount(requester{}, c.name, c.domain)
+		if err != nil || acct != c.want {
+			t.Errorf("account of %q is %v, want %s, %v", c.domain, acct, c.want.name, err)
+			continue
+		}
+		if normalized, _ := normalizeDomain(c.domain); domain != normalized {
+			t.Errorf("domain of %q is %q, not normalized", c.domain, domain)
+		}
+	}
+	if _, _, err := handler.pickAccount(requester{}, "", "foo..com"); err == nil {
+		t.Fatal("account of invalid domain is picked")
+	}
+
+	// an account with clients is only picked for them, by name or by domain
+	books.clients = map[string]struct{}{"shop": {}}
+	if _, acct, err := handler.pickAccount(requester{client: "shop"}, "", "www.bücher.example"); err != nil || acct != books {
+		t.Fatalf("account of its client is not picked, %v", err)
+	}
+	forbidden := &Error{}
+	for _, name := range []string{"", "books"} {
+		if _, _, err := handler.pickAccount(requester{client: "other"}, name, "www.bücher.example"); !errors.As(err, &forbidden) || forbidden.Code != ErrorForbidden {
+			t.Errorf("account %q is picked for another client, %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-acme/lego/v4/acme"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ErrorInvalidRequest  = "invalid_request"
+	ErrorInvalidDomain   = "invalid_domain"
+	ErrorRateLimited     = "rate_limited"
+	ErrorChallengeFailed = "challenge_failed"
+	ErrorPreflightFailed = "preflight_failed"
+	ErrorInvalidIssued   = "invalid_certificate"
+	ErrorNotFound        = "not_found"
+	ErrorForbidden       = "forbidden"
+	ErrorUnavailable     = "unavailable"
+	ErrorInternal        = "internal"
+)
+
+var errorStatus = map[string]int{
+	ErrorInvalidRequest:  http.StatusBadRequest,
+	ErrorInvalidDomain:   http.StatusBadRequest,
+	ErrorRateLimited:     http.StatusTooManyRequests,
+	ErrorChallengeFailed: http.StatusUnprocessableEntity,
+	ErrorPreflightFailed: http.StatusUnprocessableEntity,
+	ErrorInvalidIssued:   http.StatusBadGateway,
+	ErrorNotFound:        http.StatusNotFound,
+	ErrorForbidden:       http.StatusForbidden,
+	ErrorUnavailable:     http.StatusServiceUnavailable,
+	ErrorInternal:        http.StatusInternalServerError,
+}
+
+// Error is the body of failed responses, the code tells clients what went wrong without parsing the cause.
+type Error struct {
+	Code  string `json:"code"`
+	Cause string `json:"cause"`
+	// RetryAfter is the seconds to wait before trying again when it is rate limited.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Cause
+}
+
+func (e *Error) status() int {
+	status, has := errorStatus[e.Code]
+	if !has {
+		return http.StatusInternalServerError
+	}
+	return status
+}
+
+func newError(code string, format string, args ...interface{}) *Error {
+	return &Error{
+		Code:  code,
+		Cause: fmt.Sprintf(format, args...),
+	}
+}
+
+func newRateLimitedError(retryAfter time.Duration, format string, args ...interface{}) *Error {
+	v := newError(ErrorRateLimited, format, args...)
+	v.RetryAfter = int(math.Ceil(retryAfter.Seconds()))
+	return v
+}
+
+// challengeProblems are acme problem types which mean the challenge of the domain was not validated.
+var challengeProblems = map[string]struct{}{
+	"urn:ietf:params:acme:error:unauthorized":      {},
+	"urn:ietf:params:acme:error:caa":               {},
+	"urn:ietf:params:acme:error:dns":               {},
+	"urn:ietf:params:acme:error:connection":        {},
+	"urn:ietf:params:acme:error:incorrectResponse": {},
+	"urn:ietf:params:acme:error:tls":               {},
+}
+
+// errorOf classifies err, the cause keeps the whole message of err.
+func errorOf(err error) (v *Error) {
+	v = &Error{
+		Code:  ErrorInternal,
+		Cause: err.Error(),
+	}
+	typed := &Error{}
+	if errors.As(err, &typed) {
+		v.Code = typed.Code
+		v.RetryAfter = typed.RetryAfter
+		return
+	}
+	problem := &acme.ProblemDetails{}
+	if errors.As(err, &problem) {
+		switch {
+		case problem.Type == "urn:ietf:params:acme:error:rateLimited":
+			v.Code = ErrorRateLimited
+		case problem.Type == "urn:ietf:params:acme:error:rejectedIdentifier":
+			v.Code = ErrorForbidden
+		case problem.Type == "urn:ietf:params:acme:error:malformed" && len(problem.SubProblems) > 0:
+			v.Code = ErrorInvalidDomain
+		default:
+			if _, has := challengeProblems[problem.Type]; has {
+				v.Code = ErrorChallengeFailed
+			}
+		}
+		return
+	}
+	// lego reports failed authorizations by a map of domain errors which can not be unwrapped.
+	if strings.Contains(v.Cause, "one or more domains had a problem") {
+		v.Code = ErrorChallengeFailed
+	}
+	return
+}
+
+func writeError(writer http.ResponseWriter, err error) {
+	v := errorOf(err)
+	if v.RetryAfter > 0 {
+		writer.Header().Set("Retry-After", strconv.Itoa(v.RetryAfter))
+	}
+	writeJSON(writer, v.status(), v)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, v interface{}) {
+	body, encodeErr := json.Marshal(v)
+	if encodeErr != nil {
+		status = http.StatusInternalServerError
+		body, _ = json.Marshal(&Error{Code: ErrorInternal, Cause: encodeErr.Error()})
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(body)
+}
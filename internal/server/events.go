@@ -0,0 +1,172 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EventUpdated = "updated"
+	EventRevoked = "revoked"
+)
+
+// eventHeartbeat keeps idle subscriptions alive through proxies.
+const eventHeartbeat = 30 * time.Second
+
+type Event struct {
+	Kind     string    `json:"kind"`
+	Domain   string    `json:"domain"`
+	NotAfter time.Time `json:"notAfter,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+type subscriber struct {
+	domains map[string]struct{}
+	events  chan *Event
+}
+
+// Events fans out certificate events to subscribers which watch the domains.
+// A slow subscriber drops events rather than blocking others, it can catch up by obtaining the certificate.
+type Events struct {
+	mutex       sync.Mutex
+	closed      bool
+	subscribers map[*subscriber]struct{}
+}
+
+func newEvents() *Events {
+	return &Events{
+		mutex:       sync.Mutex{},
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+func (events *Events) subscribe(domains []string) (sub *subscriber, ok bool) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	if events.closed {
+		return
+	}
+	sub = &subscriber{
+		domains: make(map[string]struct{}, len(domains)),
+		events:  make(chan *Event, 8),
+	}
+	for _, domain := range domains {
+		sub.domains[domain] = struct{}{}
+	}
+	events.subscribers[sub] = struct{}{}
+	ok = true
+	return
+}
+
+func (events *Events) unsubscribe(sub *subscriber) {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	if _, has := events.subscribers[sub]; !has {
+		return
+	}
+	delete(events.subscribers, sub)
+	close(sub.events)
+}
+
+func (events *Events) publish(kind string, domain string, notAfter time.Time) {
+	event := &Event{
+		Kind:     kind,
+		Domain:   domain,
+		NotAfter: notAfter,
+		Time:     time.Now(),
+	}
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	for sub := range events.subscribers {
+		if _, has := sub.domains[domain]; !has {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+// Close ends all subscriptions, it is called when the server is shutting down, so that streams do not hold it.
+func (events *Events) Close() {
+	events.mutex.Lock()
+	defer events.mutex.Unlock()
+	events.closed = true
+	for sub := range events.subscribers {
+		delete(events.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// checkDomains checks domains to watch, at least one is required.
+func (handler *Handler) checkDomains(domains []string) (v []string, err error) {
+	v = make([]string, 0, len(domains))
+	for _, domain := range domains {
+		if strings.TrimSpace(domain) == "" {
+			continue
+		}
+		domain, err = handler.checkDomain(domain)
+		if err != nil {
+			return
+		}
+		v = append(v, domain)
+	}
+	if len(v) == 0 {
+		err = newError(ErrorInvalidDomain, "domain is required")
+		return
+	}
+	return
+}
+
+// serveEvents streams events of the domains in query as server-sent events, such as /v1/events?domain=a.foo.com&domain=b.foo.com.
+func (handler *Handler) serveEvents(writer http.ResponseWriter, request *http.Request) (err error) {
+	domains, domainsErr := handler.checkDomains(request.URL.Query()["domain"])
+	if domainsErr != nil {
+		err = domainsErr
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		err = newError(ErrorInternal, "streaming is not supported")
+		return
+	}
+	sub, subscribed := handler.events.subscribe(domains)
+	if !subscribed {
+		err = newError(ErrorUnavailable, "acmes is shutting down")
+		return
+	}
+	defer handler.events.unsubscribe(sub)
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, writeErr := writer.Write([]byte(": heartbeat\n\n"))
+			if writeErr != nil {
+				return
+			}
+			flusher.Flush()
+		case event, open := <-sub.events:
+			if !open {
+				return
+			}
+			data, _ := json.Marshal(event)
+			_, writeErr := writer.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event.Kind, data)))
+			if writeErr != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/notify"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/aacfactory/logs"
+	"time"
+)
+
+// watchExpiry scans certificates of emails every interval until ctx is done,
+// and notifies the ones which expire within warning, a renewed certificate has a later expiry and so leaves the window.
+func watchExpiry(ctx context.Context, log logs.Logger, emails []string, stores store.Store, notifier *notify.Dispatcher, warning time.Duration, interval time.Duration) {
+	for {
+		for _, email := range emails {
+			scanExpiry(ctx, log, email, stores, notifier, warning)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func scanExpiry(ctx context.Context, log logs.Logger, email string, stores store.Store, notifier *notify.Dispatcher, warning time.Duration) {
+	domains, listErr := stores.ListUserCertificates(ctx, email)
+	if listErr != nil {
+		log.Warn().Cause(listErr).Message(fmt.Sprintf("acmes: scan expiry of %s failed", email))
+		return
+	}
+	for _, domain := range domains {
+		cert, has, getErr := stores.GetUserCertificate(ctx, email, domain)
+		if getErr != nil {
+			log.Warn().Cause(getErr).Message(fmt.Sprintf("acmes: scan expiry of %s failed", domain))
+			continue
+		}
+		if !has {
+			continue
+		}
+		if time.Until(cert.NotAfter) > warning {
+			continue
+		}
+		notifier.Notify(&notify.Event{
+			Kind:     notify.Expiring,
+			Email:    email,
+			Domain:   domain,
+			NotAfter: cert.NotAfter,
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/aacfactory/acmes/client/acmespb"
+	"github.com/aacfactory/acmes/internal/audit"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/aacfactory/logs"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/runtime/protoiface"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// grpcService serves the grpc api by the handler, so that both apis share orders, stores and events.
+type grpcService struct {
+	acmespb.UnimplementedAcmesServer
+	handler *Handler
+}
+
+func (service *grpcService) Obtain(ctx context.Context, request *acmespb.ObtainRequest) (v *acmespb.Certificate, err error) {
+	who := requesterOfPeer(ctx)
+	domain, acct, pickErr := service.handler.pickAccount(who, request.Account, request.Domain)
+	if pickErr != nil {
+		err = statusOf(pickErr)
+		return
+	}
+	cert, obtainErr := service.handler.obtain(ctx, who, acct, domain, orderOptions{
+		preferredChain: strings.TrimSpace(request.PreferredChain),
+		mustStaple:     request.MustStaple,
+	})
+	service.handler.audit(who, audit.Obtain, domain, cert, obtainErr)
+	if obtainErr != nil {
+		err = statusOf(obtainErr)
+		return
+	}
+	v = certificateMessage(domain, cert)
+	return
+}
+
+func (service *grpcService) Renew(ctx context.Context, request *acmespb.RenewRequest) (v *acmespb.Certificate, err error) {
+	who := requesterOfPeer(ctx)
+	domain, acct, pickErr := service.handler.pickAccount(who, request.Account, request.Domain)
+	if pickErr != nil {
+		err = statusOf(pickErr)
+		return
+	}
+	cert, renewErr := service.handler.renew(ctx, who, acct, domain, orderOptions{
+		preferredChain: strings.TrimSpace(request.PreferredChain),
+		mustStaple:     request.MustStaple,
+	})
+	service.handler.audit(who, audit.Renew, domain, cert, renewErr)
+	if renewErr != nil {
+		err = statusOf(renewErr)
+		return
+	}
+	v = certificateMessage(domain, cert)
+	return
+}
+
+func (service *grpcService) Revoke(ctx context.Context, request *acmespb.RevokeRequest) (v *acmespb.RevokeResponse, err error) {
+	who := requesterOfPeer(ctx)
+	domain, acct, pickErr := service.handler.pickAccount(who, request.Account, request.Domain)
+	if pickErr != nil {
+		err = statusOf(pickErr)
+		return
+	}
+	cert, revokeErr := service.handler.revoke(ctx, acct, domain, uint(request.Reason))
+	service.handler.audit(who, audit.Revoke, domain, cert, revokeErr)
+	if revokeErr != nil {
+		err = statusOf(revokeErr)
+		return
+	}
+	v = &acmespb.RevokeResponse{}
+	return
+}
+
+func (service *grpcService) List(ctx context.Context, request *acmespb.ListRequest) (v *acmespb.ListResponse, err error) {
+	summaries, listErr := service.handler.listCertificates(ctx, requesterOfPeer(ctx), request.Account)
+	if listErr != nil {
+		err = statusOf(listErr)
+		return
+	}
+	v = &acmespb.ListResponse{
+		Certificates: make([]*acmespb.CertificateSummary, 0, len(summaries)),
+	}
+	for _, summary := range summaries {
+		v.Certificates = append(v.Certificates, &acmespb.CertificateSummary{
+			Domain:   summary.Domain,
+			NotAfter: timestamppb.New(summary.NotAfter),
+			Account:  summary.Account,
+		})
+	}
+	return
+}
+
+func (service *grpcService) Watch(request *acmespb.WatchRequest, stream acmespb.Acmes_WatchServer) (err error) {
+	domains, domainsErr := service.handler.checkDomains(request.Domains)
+	if domainsErr != nil {
+		err = statusOf(domainsErr)
+		return
+	}
+	sub, subscribed := service.handler.events.subscribe(domains)
+	if !subscribed {
+		err = statusOf(newError(ErrorUnavailable, "acmes is shutting down"))
+		return
+	}
+	defer service.handler.events.unsubscribe(sub)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return
+		case event, open := <-sub.events:
+			if !open {
+				return
+			}
+			sendErr := stream.Send(&acmespb.Event{
+				Kind:     event.Kind,
+				Domain:   event.Domain,
+				NotAfter: timestamppb.New(event.NotAfter),
+				Time:     timestamppb.New(event.Time),
+			})
+			if sendErr != nil {
+				return
+			}
+		}
+	}
+}
+
+func certificateMessage(domain string, cert *store.Certificate) *acmespb.Certificate {
+	return &acmespb.Certificate{
+		Domain:         domain,
+		Resource:       cert.Resource,
+		Cert:           cert.Cert,
+		Key:            cert.Key,
+		NotAfter:       timestamppb.New(cert.NotAfter),
+		Leaf:           cert.Leaf,
+		Intermediates:  cert.Intermediates,
+		Chain:          cert.Chain,
+		Ocsp:           cert.OCSP,
+		OcspNextUpdate: ocspNextUpdateOf(cert),
+	}
+}
+
+func ocspNextUpdateOf(cert *store.Certificate) *timestamppb.Timestamp {
+	if cert.OCSPNextUpdate.IsZero() {
+		return nil
+	}
+	return timestamppb.New(cert.OCSPNextUpdate)
+}
+
+func requesterOfPeer(ctx context.Context) (v requester) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return
+	}
+	var state *tls.ConnectionState
+	if info, isTLS := p.AuthInfo.(credentials.TLSInfo); isTLS {
+		state = &info.State
+	}
+	v = requesterOfConn(p.Addr.String(), state)
+	return
+}
+
+var grpcCodes = map[string]codes.Code{
+	ErrorInvalidRequest:  codes.InvalidArgument,
+	ErrorInvalidDomain:   codes.InvalidArgument,
+	ErrorRateLimited:     codes.ResourceExhausted,
+	ErrorChallengeFailed: codes.FailedPrecondition,
+	ErrorPreflightFailed: codes.FailedPrecondition,
+	ErrorInvalidIssued:   codes.DataLoss,
+	ErrorNotFound:        codes.NotFound,
+	ErrorForbidden:       codes.PermissionDenied,
+	ErrorUnavailable:     codes.Unavailable,
+	ErrorInternal:        codes.Internal,
+}
+
+// statusOf converts err to a grpc status, the error code of the http api is the reason of its error info.
+func statusOf(err error) error {
+	v := errorOf(err)
+	code, has := grpcCodes[v.Code]
+	if !has {
+		code = codes.Internal
+	}
+	st := status.New(code, v.Cause)
+	details := []protoiface.MessageV1{&errdetails.ErrorInfo{
+		Reason: v.Code,
+		Domain: "acmes",
+	}}
+	if v.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{
+			RetryDelay: durationpb.New(time.Duration(v.RetryAfter) * time.Second),
+		})
+	}
+	detailed, detailErr := st.WithDetails(details...)
+	if detailErr != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// grpcTracing continues the w3c trace context in metadata, and spans each call by its full method.
+func grpcTracing() []grpc.ServerOption {
+	extract := func(ctx context.Context) context.Context {
+		md, _ := metadata.FromIncomingContext(ctx)
+		return otel.GetTextMapPropagator().Extract(ctx, acmespb.MetadataCarrier(md))
+	}
+	return []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+			ctx, span := startSpan(extract(ctx), info.FullMethod)
+			resp, err = handler(ctx, req)
+			endSpan(span, err)
+			return
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+			ctx, span := startSpan(extract(stream.Context()), info.FullMethod)
+			err = handler(srv, &tracedStream{ServerStream: stream, ctx: ctx})
+			endSpan(span, err)
+			return
+		}),
+	}
+}
+
+type tracedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *tracedStream) Context() context.Context {
+	return stream.ctx
+}
+
+// serveGRPC serves the grpc api at port over the same mtls config as the http api.
+func serveGRPC(log logs.Logger, port int, tlsConfig *tls.Config, handler *Handler, running *atomic.Bool) (srv *grpc.Server, err error) {
+	ln, lnErr := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if lnErr != nil {
+		err = fmt.Errorf("acmes: serve grpc failed, %v", lnErr)
+		return
+	}
+	options := append([]grpc.ServerOption{grpc.Creds(credentials.NewTLS(tlsConfig))}, grpcTracing()...)
+	srv = grpc.NewServer(options...)
+	acmespb.RegisterAcmesServer(srv, &grpcService{handler: handler})
+	running.Store(true)
+	go func() {
+		serveErr := srv.Serve(ln)
+		running.Store(false)
+		if serveErr != nil && !errors.Is(serveErr, grpc.ErrServerStopped) {
+			log.Error().Cause(serveErr).Message(fmt.Sprintf("acmes: serve grpc at :%d failed", port))
+		}
+	}()
+	if log.DebugEnabled() {
+		log.Debug().Message(fmt.Sprintf("serve grpc at :%d", port))
+	}
+	return
+}
+
+// stopGRPC stops srv gracefully, and stops it at once when ctx is done.
+func stopGRPC(ctx context.Context, srv *grpc.Server) (err error) {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		srv.Stop()
+		err = ctx.Err()
+	}
+	return
+}
@@ -2,91 +2,253 @@ package server
 
 import (
 	"context"
-	"crypto/x509"
 	"encoding/json"
-	"encoding/pem"
 	"fmt"
+	"github.com/aacfactory/acmes/internal/audit"
+	"github.com/aacfactory/acmes/internal/notify"
+	"github.com/aacfactory/acmes/internal/preflight"
 	"github.com/aacfactory/acmes/internal/store"
 	"github.com/aacfactory/logs"
 	"github.com/go-acme/lego/v4/certificate"
-	"github.com/go-acme/lego/v4/lego"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 	"golang.org/x/sync/singleflight"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// orderTimeout bounds the work of an order which callers share, such as preflight checks, store and ocsp.
+const orderTimeout = 10 * time.Minute
+
 type RequestParam struct {
 	Domain string `json:"domain"`
+	// Async makes obtain respond a job at once, the job is polled at /v1/jobs/{id}.
+	Async bool `json:"async,omitempty"`
+	// Account is the name of the acme account, the account is picked by the domain when it is empty.
+	Account string `json:"account,omitempty"`
+	// PreferredChain overrides the preferred chain of the account for this order.
+	PreferredChain string `json:"preferredChain,omitempty"`
+	// MustStaple adds the ocsp must staple extension to the certificate of this order.
+	MustStaple bool `json:"mustStaple,omitempty"`
+}
+
+// orderOptions are options of an order which a request sets.
+type orderOptions struct {
+	preferredChain string
+	mustStaple     bool
+}
+
+func (param *RequestParam) options() orderOptions {
+	return orderOptions{
+		preferredChain: strings.TrimSpace(param.PreferredChain),
+		mustStaple:     param.MustStaple,
+	}
+}
+
+// key identifies the options in the key of the order, so that orders with other options are not shared.
+func (options orderOptions) key(acct *account) string {
+	return fmt.Sprintf("%s:%t", options.chainOf(acct), options.mustStaple)
+}
+
+// chainOf returns the preferred chain of the order, it is the one of the account when the request names none.
+func (options orderOptions) chainOf(acct *account) string {
+	if options.preferredChain != "" {
+		return options.preferredChain
+	}
+	return acct.preferredChain
 }
 
 type Handler struct {
-	log     logs.Logger
-	email   string
-	acme    *lego.Client
-	stores  store.Store
-	barrier *singleflight.Group
+	log      logs.Logger
+	accounts *accounts
+	stores   store.Store
+	barrier  *singleflight.Group
+	metrics  *Metrics
+	notifier *notify.Dispatcher
+	audits   audit.Log
+	events   *Events
+	limits   *Limiter
+	checker  *preflight.Checker
+	policy   atomic.Pointer[domainPolicy]
+	inflight sync.WaitGroup
+	// renewMustStaple makes every renewal must staple, whatever the request asks.
+	renewMustStaple bool
 }
 
+// routeFunc serves a matched route, it writes the response only when it succeeds, failures are written by ServeHTTP.
+type routeFunc func(writer http.ResponseWriter, request *http.Request) (err error)
+
 func (handler *Handler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
 	if handler.log.DebugEnabled() {
 		handler.log.Debug().Message(fmt.Sprintf("%s %s", request.Method, request.URL.String()))
 	}
-	if request.Method != http.MethodPost {
-		writer.WriteHeader(http.StatusNotAcceptable)
+	var name string
+	var fn routeFunc
+	if strings.HasPrefix(request.URL.Path, "/v1/") {
+		name, fn = handler.route(request)
+	} else {
+		name, fn = handler.legacyRoute(request)
+	}
+	if fn == nil {
+		writeError(writer, newError(ErrorNotFound, "%s %s was not found", request.Method, request.URL.Path))
+		return
+	}
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	ctx, span := startSpan(ctx, name)
+	err := fn(writer, request.WithContext(ctx))
+	endSpan(span, err)
+	if err != nil {
+		writeError(writer, err)
 		return
 	}
-	contentType := request.Header.Get("Content-Type")
-	if contentType != "application/acme" {
-		writer.WriteHeader(http.StatusNotAcceptable)
+}
+
+// decodeParam reads the param in body, the content type of body must be contentType.
+func decodeParam(request *http.Request, contentType string) (param *RequestParam, err error) {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if mediaType != contentType {
+		err = newError(ErrorInvalidRequest, "content type must be %s", contentType)
 		return
 	}
-	param := &RequestParam{}
 	body, bodyErr := io.ReadAll(request.Body)
 	if bodyErr != nil {
-		writer.WriteHeader(http.StatusBadRequest)
+		err = newError(ErrorInvalidRequest, "read body failed, %v", bodyErr)
 		return
 	}
-	paramErr := json.Unmarshal(body, param)
-	if paramErr != nil {
-		writer.WriteHeader(http.StatusBadRequest)
+	param = &RequestParam{}
+	decodeErr := json.Unmarshal(body, param)
+	if decodeErr != nil {
+		err = newError(ErrorInvalidRequest, "decode body failed, %v", decodeErr)
 		return
 	}
-	requestPath := request.URL.Path
-	var cert *store.Certificate
-	var err error
-	switch requestPath {
-	case "/obtain":
-		cert, err = handler.obtain(handler.email, param.Domain)
-	case "/renew":
-		cert, err = handler.renew(handler.email, param.Domain)
-	default:
-		writer.WriteHeader(http.StatusNotFound)
+	param.Domain = strings.TrimSpace(param.Domain)
+	return
+}
+
+// serveObtain obtains the certificate of the domain in param, the job of async obtain is located at jobsPath + id.
+func (handler *Handler) serveObtain(writer http.ResponseWriter, request *http.Request, param *RequestParam, jobsPath string) (err error) {
+	ctx := request.Context()
+	who := requesterOf(request)
+	domain, acct, pickErr := handler.pickAccount(who, param.Account, param.Domain)
+	if pickErr != nil {
+		err = pickErr
 		return
 	}
-	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
-		_, _ = writer.Write([]byte(fmt.Sprintf("{\"cause\": \"%s\"}", err.Error())))
+	param.Domain = domain
+	if param.Async {
+		job, submitErr := handler.submit(ctx, who, acct, param.Domain, param.options())
+		if submitErr != nil {
+			err = submitErr
+			return
+		}
+		writer.Header().Set("Location", jobsPath+job.Id)
+		writeJSON(writer, http.StatusAccepted, jobResultOf(job))
 		return
 	}
-	result, certErr := json.Marshal(cert)
-	if certErr != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
-		_, _ = writer.Write([]byte(fmt.Sprintf("{\"cause\": \"%s\"}", certErr.Error())))
+	cert, obtainErr := handler.obtain(ctx, who, acct, param.Domain, param.options())
+	handler.audit(who, audit.Obtain, param.Domain, cert, obtainErr)
+	if obtainErr != nil {
+		err = obtainErr
 		return
 	}
-	writer.WriteHeader(http.StatusOK)
-	writer.Header().Add("Content-Type", "application/json")
-	_, _ = writer.Write(result)
+	writeJSON(writer, http.StatusOK, cert)
+	return
 }
 
-func (handler *Handler) obtain(email string, domain string) (v *store.Certificate, err error) {
+// serveRenew renews the certificate of domain, the account is in the account query param or picked by the domain.
+func (handler *Handler) serveRenew(writer http.ResponseWriter, request *http.Request, domain string, accountName string, options orderOptions) (err error) {
+	who := requesterOf(request)
+	domain, acct, pickErr := handler.pickAccount(who, accountName, domain)
+	if pickErr != nil {
+		err = pickErr
+		return
+	}
+	cert, renewErr := handler.renew(request.Context(), who, acct, domain, options)
+	handler.audit(who, audit.Renew, domain, cert, renewErr)
+	if renewErr != nil {
+		err = renewErr
+		return
+	}
+	writeJSON(writer, http.StatusOK, cert)
+	return
+}
+
+// serveRevoke revokes the certificate of domain, the crl reason is in the reason query param which is 0 by default,
+// the account is in the account query param or picked by the domain.
+func (handler *Handler) serveRevoke(writer http.ResponseWriter, request *http.Request, domain string) (err error) {
+	var reason uint64
+	if raw := request.URL.Query().Get("reason"); raw != "" {
+		var parseErr error
+		reason, parseErr = strconv.ParseUint(raw, 10, 32)
+		if parseErr != nil {
+			err = newError(ErrorInvalidRequest, "reason %s is not a crl reason", raw)
+			return
+		}
+	}
+	who := requesterOf(request)
+	domain, acct, pickErr := handler.pickAccount(who, request.URL.Query().Get("account"), domain)
+	if pickErr != nil {
+		err = pickErr
+		return
+	}
+	cert, revokeErr := handler.revoke(request.Context(), acct, domain, uint(reason))
+	handler.audit(who, audit.Revoke, domain, cert, revokeErr)
+	if revokeErr != nil {
+		err = revokeErr
+		return
+	}
+	writer.WriteHeader(http.StatusNoContent)
+	return
+}
+
+func (handler *Handler) serveJob(writer http.ResponseWriter, request *http.Request, id string) (err error) {
+	result, has, getErr := handler.getJob(request.Context(), id)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if !has {
+		err = newError(ErrorNotFound, "job %s was not found", id)
+		return
+	}
+	writeJSON(writer, http.StatusOK, result)
+	return
+}
+
+// obtain returns the stored certificate of domain in the account, or orders one by the account for who when it is absent.
+func (handler *Handler) obtain(ctx context.Context, who requester, acct *account, domain string, options orderOptions) (v *store.Certificate, err error) {
+	handler.inflight.Add(1)
+	defer handler.inflight.Done()
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "obtain", attribute.String("acmes.domain", domain), attribute.String("acmes.account", acct.name))
+	defer func() {
+		handler.metrics.observeRequest("obtain", beg, err)
+		endSpan(span, err)
+	}()
+	domain, err = handler.checkDomain(domain)
+	if err != nil {
+		return
+	}
 	if handler.log.DebugEnabled() {
 		handler.log.Debug().Message(fmt.Sprintf("begin obtain %s", domain))
 	}
-	key := fmt.Sprintf("obtain:%s:%s", email, domain)
-	result, doErr, _ := handler.barrier.Do(key, func() (v interface{}, handleErr error) {
-		cert, hasCert, getErr := handler.stores.GetUserCertificate(context.TODO(), email, domain)
+	email := acct.email
+	key := fmt.Sprintf("obtain:%s:%s:%s", email, domain, options.key(acct))
+	// ran is only set in the caller which runs the order, shared is true for it too when others joined
+	ran := false
+	result, doErr, shared := handler.barrier.Do(key, func() (v interface{}, handleErr error) {
+		ran = true
+		// the order is shared by every caller, so it must not be canceled with the request of the first one
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), orderTimeout)
+		defer cancel()
+		cert, hasCert, getErr := handler.stores.GetUserCertificate(ctx, email, domain)
 		if getErr != nil {
 			handleErr = getErr
 			return
@@ -95,34 +257,62 @@ func (handler *Handler) obtain(email string, domain string) (v *store.Certificat
 			v = cert
 			return
 		}
+		handleErr = handler.preflight(ctx, domain)
+		if handleErr != nil {
+			return
+		}
+		reserveErr := handler.limits.reserve(who.identity(), acct.name, domain)
+		if reserveErr != nil {
+			handler.metrics.observeRateLimited("obtain")
+			handleErr = reserveErr
+			return
+		}
 		request := certificate.ObtainRequest{
-			Domains: []string{domain},
-			Bundle:  true,
+			Domains:        []string{domain},
+			Bundle:         true,
+			PreferredChain: options.chainOf(acct),
+			MustStaple:     options.mustStaple,
 		}
-		certificates, obtainErr := handler.acme.Certificate.Obtain(request)
+		orderBeg := time.Now()
+		_, orderSpan := startSpan(ctx, "acme.obtain")
+		certificates, obtainErr := acct.client.Certificate.Obtain(request)
+		endSpan(orderSpan, obtainErr)
+		handler.metrics.observeOrder("obtain", orderBeg, obtainErr)
+		handler.limits.done(domain, obtainErr)
 		if obtainErr != nil {
 			handleErr = obtainErr
 			return
 		}
-		cert, handleErr = handler.handleCertificates(certificates)
+		cert, handleErr = handler.handleCertificates(ctx, domain, certificates)
 		if handleErr != nil {
 			return
 		}
 
-		saveErr := handler.stores.SaveUserCertificate(context.TODO(), email, domain, cert)
+		saveErr := handler.stores.SaveUserCertificate(ctx, email, domain, cert)
 		if saveErr != nil {
 			handleErr = saveErr
 			return
 		}
+		if _, stapleErr := stapleOCSP(ctx, handler.stores, email, domain, cert); stapleErr != nil {
+			handler.log.Warn().Cause(stapleErr).Message(fmt.Sprintf("acmes: staple ocsp of %s failed", domain))
+		}
+		handler.notifier.Notify(&notify.Event{
+			Kind:     notify.Issued,
+			Email:    email,
+			Domain:   domain,
+			NotAfter: cert.NotAfter,
+		})
+		handler.events.publish(EventUpdated, domain, cert.NotAfter)
 		v = cert
 		return
 	})
 	handler.barrier.Forget(key)
+	handler.metrics.observeShared("obtain", shared && !ran)
 	if doErr != nil {
 		if handler.log.DebugEnabled() {
 			handler.log.Debug().Cause(doErr).Message(fmt.Sprintf("obtain %s failed", domain))
 		}
-		err = fmt.Errorf("acmes: obtain failed, %v", doErr)
+		err = fmt.Errorf("acmes: obtain failed, %w", doErr)
 		return
 	}
 	if handler.log.DebugEnabled() {
@@ -132,19 +322,51 @@ func (handler *Handler) obtain(email string, domain string) (v *store.Certificat
 	return
 }
 
-func (handler *Handler) renew(email string, domain string) (v *store.Certificate, err error) {
+// renew orders a new certificate of domain by the account for who when the stored one is expired.
+func (handler *Handler) renew(ctx context.Context, who requester, acct *account, domain string, options orderOptions) (v *store.Certificate, err error) {
+	handler.inflight.Add(1)
+	defer handler.inflight.Done()
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "renew", attribute.String("acmes.domain", domain), attribute.String("acmes.account", acct.name))
+	defer func() {
+		handler.metrics.observeRequest("renew", beg, err)
+		endSpan(span, err)
+	}()
+	domain, err = handler.checkDomain(domain)
+	if err != nil {
+		return
+	}
 	if handler.log.DebugEnabled() {
 		handler.log.Debug().Message(fmt.Sprintf("begin renew %s", domain))
 	}
-	key := fmt.Sprintf("renew:%s:%s", email, domain)
-	result, doErr, _ := handler.barrier.Do(key, func() (v interface{}, handleErr error) {
-		cert, hasCert, getErr := handler.stores.GetUserCertificate(context.TODO(), email, domain)
+	email := acct.email
+	options.mustStaple = options.mustStaple || handler.renewMustStaple
+	key := fmt.Sprintf("renew:%s:%s:%s", email, domain, options.key(acct))
+	// ran tells the caller which runs the order from the ones which share it, as obtain
+	ran := false
+	result, doErr, shared := handler.barrier.Do(key, func() (v interface{}, handleErr error) {
+		ran = true
+		// shared by every caller as obtain
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), orderTimeout)
+		defer cancel()
+		// only the caller which runs the order notifies its failure, the others share it
+		defer func() {
+			if handleErr != nil {
+				handler.notifier.Notify(&notify.Event{
+					Kind:   notify.RenewFailed,
+					Email:  email,
+					Domain: domain,
+					Cause:  handleErr.Error(),
+				})
+			}
+		}()
+		cert, hasCert, getErr := handler.stores.GetUserCertificate(ctx, email, domain)
 		if getErr != nil {
 			handleErr = getErr
 			return
 		}
-		if hasCert {
-			handleErr = fmt.Errorf("not obtained")
+		if !hasCert {
+			handleErr = newError(ErrorNotFound, "certificate of %s was not obtained", domain)
 			return
 		}
 		if cert.NotAfter.After(time.Now()) {
@@ -157,36 +379,62 @@ func (handler *Handler) renew(email string, domain string) (v *store.Certificate
 			handleErr = resourceErr
 			return
 		}
-		certificates, renewErr := handler.acme.Certificate.RenewWithOptions(resource, &certificate.RenewOptions{
+		handleErr = handler.preflight(ctx, domain)
+		if handleErr != nil {
+			return
+		}
+		reserveErr := handler.limits.reserve(who.identity(), acct.name, domain)
+		if reserveErr != nil {
+			handler.metrics.observeRateLimited("renew")
+			handleErr = reserveErr
+			return
+		}
+		orderBeg := time.Now()
+		_, orderSpan := startSpan(ctx, "acme.renew")
+		certificates, renewErr := acct.client.Certificate.RenewWithOptions(resource, &certificate.RenewOptions{
 			NotBefore:                      time.Now().AddDate(0, 0, -1),
 			NotAfter:                       time.Now().AddDate(0, 3, 0),
 			Bundle:                         true,
-			PreferredChain:                 "",
+			PreferredChain:                 options.chainOf(acct),
 			AlwaysDeactivateAuthorizations: false,
-			MustStaple:                     true,
+			MustStaple:                     options.mustStaple,
 		})
+		endSpan(orderSpan, renewErr)
+		handler.metrics.observeOrder("renew", orderBeg, renewErr)
+		handler.limits.done(domain, renewErr)
 		if renewErr != nil {
 			handleErr = renewErr
 			return
 		}
-		cert, handleErr = handler.handleCertificates(certificates)
+		cert, handleErr = handler.handleCertificates(ctx, domain, certificates)
 		if handleErr != nil {
 			return
 		}
-		saveErr := handler.stores.SaveUserCertificate(context.TODO(), email, domain, cert)
+		saveErr := handler.stores.SaveUserCertificate(ctx, email, domain, cert)
 		if saveErr != nil {
 			handleErr = saveErr
 			return
 		}
+		if _, stapleErr := stapleOCSP(ctx, handler.stores, email, domain, cert); stapleErr != nil {
+			handler.log.Warn().Cause(stapleErr).Message(fmt.Sprintf("acmes: staple ocsp of %s failed", domain))
+		}
+		handler.notifier.Notify(&notify.Event{
+			Kind:     notify.Issued,
+			Email:    email,
+			Domain:   domain,
+			NotAfter: cert.NotAfter,
+		})
+		handler.events.publish(EventUpdated, domain, cert.NotAfter)
 		v = cert
 		return
 	})
 	handler.barrier.Forget(key)
+	handler.metrics.observeShared("renew", shared && !ran)
 	if doErr != nil {
 		if handler.log.DebugEnabled() {
 			handler.log.Debug().Cause(doErr).Message(fmt.Sprintf("renew %s failed", domain))
 		}
-		err = fmt.Errorf("acmes: renew failed, %v", doErr)
+		err = fmt.Errorf("acmes: renew failed, %w", doErr)
 		return
 	}
 	if handler.log.DebugEnabled() {
@@ -196,40 +444,113 @@ func (handler *Handler) renew(email string, domain string) (v *store.Certificate
 	return
 }
 
-func (handler *Handler) handleCertificates(certificates *certificate.Resource) (v *store.Certificate, err error) {
-	resp, getErr := http.Get(certificates.CertStableURL)
-	if getErr != nil {
-		err = fmt.Errorf("get cert from %s failed, %v", certificates.CertStableURL, getErr)
+// preflight checks caa records and authoritative nameservers of domain, so that orders which can not be validated are not placed.
+func (handler *Handler) preflight(ctx context.Context, domain string) (err error) {
+	if handler.checker == nil {
 		return
 	}
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		err = fmt.Errorf("get cert from %s failed, %v", certificates.CertStableURL, string(body))
+	ctx, span := startSpan(ctx, "preflight")
+	report := handler.checker.Check(ctx, domain)
+	if reportErr := report.Err(); reportErr != nil {
+		err = newError(ErrorPreflightFailed, "preflight of %s failed, %v", domain, reportErr)
+	}
+	endSpan(span, err)
+	return
+}
+
+// revoke revokes the certificate of domain at the ca with the crl reason, then removes it from the store,
+// so that the next obtain orders a new one.
+func (handler *Handler) revoke(ctx context.Context, acct *account, domain string, reason uint) (v *store.Certificate, err error) {
+	handler.inflight.Add(1)
+	defer handler.inflight.Done()
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "revoke", attribute.String("acmes.domain", domain), attribute.String("acmes.account", acct.name))
+	defer func() {
+		handler.metrics.observeRequest("revoke", beg, err)
+		endSpan(span, err)
+	}()
+	domain, err = handler.checkDomain(domain)
+	if err != nil {
 		return
 	}
-	certPEM, readErr := io.ReadAll(resp.Body)
-	if readErr != nil {
-		err = readErr
+	if reason > 10 || reason == 7 {
+		err = newError(ErrorInvalidRequest, "acmes: revoke failed, %d is not a crl reason", reason)
+		return
+	}
+	email := acct.email
+	key := fmt.Sprintf("revoke:%s:%s", email, domain)
+	result, doErr, _ := handler.barrier.Do(key, func() (v interface{}, handleErr error) {
+		// shared by every caller as well
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), orderTimeout)
+		defer cancel()
+		cert, hasCert, getErr := handler.stores.GetUserCertificate(ctx, email, domain)
+		if getErr != nil {
+			handleErr = getErr
+			return
+		}
+		if !hasCert {
+			handleErr = newError(ErrorNotFound, "certificate of %s was not obtained", domain)
+			return
+		}
+		orderBeg := time.Now()
+		_, orderSpan := startSpan(ctx, "acme.revoke")
+		revokeErr := acct.client.Certificate.RevokeWithReason(cert.Cert, &reason)
+		endSpan(orderSpan, revokeErr)
+		handler.metrics.observeOrder("revoke", orderBeg, revokeErr)
+		if revokeErr != nil {
+			handleErr = revokeErr
+			return
+		}
+		removeErr := handler.stores.RemoveUserCertificate(ctx, email, domain)
+		if removeErr != nil {
+			handleErr = removeErr
+			return
+		}
+		handler.events.publish(EventRevoked, domain, cert.NotAfter)
+		v = cert
+		return
+	})
+	handler.barrier.Forget(key)
+	if doErr != nil {
+		if handler.log.DebugEnabled() {
+			handler.log.Debug().Cause(doErr).Message(fmt.Sprintf("revoke %s failed", domain))
+		}
+		err = fmt.Errorf("acmes: revoke failed, %w", doErr)
 		return
 	}
-	certBlock, _ := pem.Decode(certPEM)
-	cert0, parseCertificateErr := x509.ParseCertificate(certBlock.Bytes)
-	if parseCertificateErr != nil {
-		err = parseCertificateErr
+	if handler.log.DebugEnabled() {
+		handler.log.Debug().Message(fmt.Sprintf("revoke %s succeed", domain))
+	}
+	v = result.(*store.Certificate)
+	return
+}
+
+// wait waits for in-flight obtain, renew and revoke until ctx is done.
+func (handler *Handler) wait(ctx context.Context) (err error) {
+	err = waitGroup(ctx, &handler.inflight)
+	return
+}
+
+// handleCertificates keeps the chain which lego returned, it is the preferred chain when the ca offered it.
+// The chain is verified before it is kept, a broken one fails the order instead of being served.
+func (handler *Handler) handleCertificates(ctx context.Context, domain string, certificates *certificate.Resource) (v *store.Certificate, err error) {
+	_, span := startSpan(ctx, "handleCertificates")
+	defer func() {
+		endSpan(span, err)
+	}()
+	certPEM := certificates.Certificate
+	leaf, verifyErr := verifyIssued([]string{domain}, certPEM, certificates.PrivateKey)
+	if verifyErr != nil {
+		err = newError(ErrorInvalidIssued, "certificate of %s which the ca issued is invalid, %v", domain, verifyErr)
 		return
 	}
-	renewAT := cert0.NotAfter.Local()
+	renewAT := leaf.NotAfter.Local()
 	keyPEM := certificates.PrivateKey
 	resource, resourceErr := json.Marshal(certificates)
 	if resourceErr != nil {
 		err = resourceErr
 		return
 	}
-	v = &store.Certificate{
-		Resource: resource,
-		Cert:     certPEM,
-		Key:      keyPEM,
-		NotAfter: renewAT,
-	}
+	v = store.NewCertificate(resource, certPEM, keyPEM, renewAT)
 	return
 }
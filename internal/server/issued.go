@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// issuedClockSkew is how far the not before of an issued certificate may be ahead of the local clock.
+const issuedClockSkew = 5 * time.Minute
+
+// verifyIssued checks the chain which the ca issued for domains before it is stored:
+// every pem block is a certificate, the key matches the leaf, sans of the leaf are domains,
+// and the leaf verifies up to the last certificate of the chain.
+func verifyIssued(domains []string, chainPEM []byte, keyPEM []byte) (leaf *x509.Certificate, err error) {
+	certificates := make([]*x509.Certificate, 0, 3)
+	rest := chainPEM
+	for i := 0; ; i++ {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			err = fmt.Errorf("block %d of the chain is %s, not a certificate", i, block.Type)
+			return
+		}
+		cert, parseErr := x509.ParseCertificate(block.Bytes)
+		if parseErr != nil {
+			err = fmt.Errorf("block %d of the chain can not be parsed, %v", i, parseErr)
+			return
+		}
+		certificates = append(certificates, cert)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		err = fmt.Errorf("the chain has data which is not pem")
+		return
+	}
+	if len(certificates) == 0 {
+		err = fmt.Errorf("the chain has no certificate")
+		return
+	}
+	if len(certificates) == 1 {
+		err = fmt.Errorf("the chain has no issuer of the leaf")
+		return
+	}
+	leaf = certificates[0]
+
+	key, keyErr := certcrypto.ParsePEMPrivateKey(keyPEM)
+	if keyErr != nil {
+		err = fmt.Errorf("the private key can not be parsed, %v", keyErr)
+		return
+	}
+	signer, isSigner := key.(crypto.Signer)
+	if !isSigner {
+		err = fmt.Errorf("the private key is not a signer")
+		return
+	}
+	if public, ok := leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !public.Equal(signer.Public()) {
+		err = fmt.Errorf("the private key does not match the leaf")
+		return
+	}
+
+	want := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		want = append(want, strings.ToLower(domain))
+	}
+	got := make([]string, 0, len(leaf.DNSNames))
+	for _, name := range leaf.DNSNames {
+		got = append(got, strings.ToLower(name))
+	}
+	sort.Strings(want)
+	sort.Strings(got)
+	if strings.Join(want, ",") != strings.Join(got, ",") {
+		err = fmt.Errorf("sans of the leaf are %s, not %s", strings.Join(got, ", "), strings.Join(want, ", "))
+		return
+	}
+
+	// the root is not in the chain, the last certificate is trusted as the anchor, so that private cas verify too
+	roots := x509.NewCertPool()
+	roots.AddCert(certificates[len(certificates)-1])
+	intermediates := x509.NewCertPool()
+	for _, cert := range certificates[1 : len(certificates)-1] {
+		intermediates.AddCert(cert)
+	}
+	now := time.Now()
+	if leaf.NotBefore.After(now) && leaf.NotBefore.Sub(now) <= issuedClockSkew {
+		now = leaf.NotBefore
+	}
+	_, verifyErr := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	if verifyErr != nil {
+		err = fmt.Errorf("the chain does not verify, %v", verifyErr)
+		return
+	}
+	for i := 0; i < len(certificates)-1; i++ {
+		if signatureErr := certificates[i].CheckSignatureFrom(certificates[i+1]); signatureErr != nil {
+			err = fmt.Errorf("certificate %d of the chain is not signed by the next one, %v", i, signatureErr)
+			return
+		}
+	}
+	return
+}
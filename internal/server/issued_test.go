@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+type issuedCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func issue(t *testing.T, name string, ca bool, parent *issuedCert, notAfter time.Time, domains ...string) *issuedCert {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		DNSNames:              domains,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
+	signerCert, signerKey := template, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &issuedCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+func keyPEMOf(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func TestVerifyIssued(t *testing.T) {
+	year := time.Now().AddDate(1, 0, 0)
+	root := issue(t, "root", true, nil, year)
+	intermediate := issue(t, "intermediate", true, root, year)
+	leaf := issue(t, "www.foo.com", false, intermediate, year, "www.foo.com")
+	expired := issue(t, "www.foo.com", false, intermediate, time.Now().Add(-time.Minute), "www.foo.com")
+	other := issue(t, "www.bar.com", false, intermediate, year, "www.bar.com")
+	stranger := issue(t, "stranger", true, nil, year)
+	join := func(blocks ...[]byte) []byte {
+		content := make([]byte, 0, 4096)
+		for _, block := range blocks {
+			content = append(content, block...)
+		}
+		return content
+	}
+	cases := []struct {
+		name  string
+		chain []byte
+		key   []byte
+		cause string
+	}{
+		{"valid", join(leaf.pem, intermediate.pem), keyPEMOf(t, leaf.key), ""},
+		{"empty", nil, keyPEMOf(t, leaf.key), "no certificate"},
+		{"only leaf", leaf.pem, keyPEMOf(t, leaf.key), "no issuer"},
+		{"garbage", join(leaf.pem, intermediate.pem, []byte("garbage")), keyPEMOf(t, leaf.key), "not pem"},
+		{"key block", join(leaf.pem, keyPEMOf(t, leaf.key)), keyPEMOf(t, leaf.key), "not a certificate"},
+		{"other key", join(leaf.pem, intermediate.pem), keyPEMOf(t, other.key), "does not match"},
+		{"other domain", join(other.pem, intermediate.pem), keyPEMOf(t, other.key), "sans of the leaf"},
+		{"expired", join(expired.pem, intermediate.pem), keyPEMOf(t, expired.key), "does not verify"},
+		{"wrong issuer", join(leaf.pem, stranger.pem), keyPEMOf(t, leaf.key), "does not verify"},
+	}
+	for _, c := range cases {
+		_, err := verifyIssued([]string{"www.foo.com"}, c.chain, c.key)
+		if c.cause == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.cause) {
+			t.Errorf("%s: want %q, got %v", c.name, c.cause, err)
+		}
+	}
+}
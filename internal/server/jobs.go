@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/audit"
+	"github.com/aacfactory/acmes/internal/store"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+const (
+	// jobRetention is how long finished jobs are kept for polling.
+	jobRetention = 7 * 24 * time.Hour
+	// jobSweepInterval is the time between sweeps of expired finished jobs.
+	jobSweepInterval = time.Hour
+)
+
+// JobResult is the job which clients poll, the requester of the job and its account are not in it.
+type JobResult struct {
+	Id          string             `json:"id"`
+	Domain      string             `json:"domain"`
+	State       string             `json:"state"`
+	Cause       string             `json:"cause,omitempty"`
+	Code        string             `json:"code,omitempty"`
+	Certificate *store.Certificate `json:"certificate,omitempty"`
+}
+
+func jobResultOf(job *store.Job) *JobResult {
+	return &JobResult{
+		Id:     job.Id,
+		Domain: job.Domain,
+		State:  job.State,
+		Cause:  job.Cause,
+		Code:   job.Code,
+	}
+}
+
+func newJobId() (id string, err error) {
+	p := make([]byte, 16)
+	_, err = rand.Read(p)
+	if err != nil {
+		err = fmt.Errorf("acmes: create job id failed, %v", err)
+		return
+	}
+	id = hex.EncodeToString(p)
+	return
+}
+
+// submit saves a pending job for obtaining the certificate of domain, and runs it in background.
+func (handler *Handler) submit(ctx context.Context, who requester, acct *account, domain string, options orderOptions) (job *store.Job, err error) {
+	domain, err = handler.checkDomain(domain)
+	if err != nil {
+		return
+	}
+	id, idErr := newJobId()
+	if idErr != nil {
+		err = idErr
+		return
+	}
+	now := time.Now()
+	job = &store.Job{
+		Id:             id,
+		Account:        acct.name,
+		Email:          acct.email,
+		Domain:         domain,
+		PreferredChain: options.preferredChain,
+		MustStaple:     options.mustStaple,
+		State:          store.JobPending,
+		Client:         who.client,
+		ClientSerial:   who.clientSerial,
+		RemoteAddr:     who.remoteAddr,
+		CreateAT:       now,
+		UpdateAT:       now,
+	}
+	err = handler.stores.SaveJob(ctx, job)
+	if err != nil {
+		err = fmt.Errorf("acmes: submit job failed, %v", err)
+		return
+	}
+	handler.runJob(job)
+	return
+}
+
+func (handler *Handler) runJob(job *store.Job) {
+	handler.inflight.Add(1)
+	go func(job *store.Job) {
+		defer handler.inflight.Done()
+		ctx, span := startSpan(context.Background(), "job", attribute.String("acmes.job", job.Id), attribute.String("acmes.domain", job.Domain))
+		handler.updateJob(ctx, job, store.JobValidating, nil)
+		var cert *store.Certificate
+		who := requester{
+			client:       job.Client,
+			clientSerial: job.ClientSerial,
+			remoteAddr:   job.RemoteAddr,
+		}
+		acct, err := handler.accounts.ofJob(job)
+		if err == nil {
+			cert, err = handler.obtain(ctx, who, acct, job.Domain, orderOptions{
+				preferredChain: job.PreferredChain,
+				mustStaple:     job.MustStaple,
+			})
+		}
+		endSpan(span, err)
+		handler.audit(who, audit.Obtain, job.Domain, cert, err)
+		if err != nil {
+			handler.updateJob(ctx, job, store.JobFailed, err)
+			return
+		}
+		handler.updateJob(ctx, job, store.JobIssued, nil)
+	}(job)
+}
+
+func (handler *Handler) updateJob(ctx context.Context, job *store.Job, state string, cause error) {
+	job.State = state
+	if cause != nil {
+		failure := errorOf(cause)
+		job.Cause = failure.Cause
+		job.Code = failure.Code
+	}
+	job.UpdateAT = time.Now()
+	saveErr := handler.stores.SaveJob(ctx, job)
+	if saveErr != nil {
+		handler.log.Error().Cause(saveErr).Message(fmt.Sprintf("acmes: save job %s failed", job.Id))
+	}
+}
+
+func (handler *Handler) getJob(ctx context.Context, id string) (result *JobResult, has bool, err error) {
+	job, hasJob, getErr := handler.stores.GetJob(ctx, id)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	if !hasJob {
+		return
+	}
+	result = jobResultOf(job)
+	if job.State == store.JobIssued {
+		cert, hasCert, getCertErr := handler.stores.GetUserCertificate(ctx, job.Email, job.Domain)
+		if getCertErr != nil {
+			err = getCertErr
+			return
+		}
+		if hasCert {
+			result.Certificate = cert
+		}
+	}
+	has = true
+	return
+}
+
+// resumeJobs runs again the jobs which were not finished before the last shutdown.
+func (handler *Handler) resumeJobs(ctx context.Context) (err error) {
+	jobs, listErr := handler.stores.ListJobs(ctx)
+	if listErr != nil {
+		err = fmt.Errorf("acmes: resume jobs failed, %v", listErr)
+		return
+	}
+	for _, job := range jobs {
+		if job.Finished() {
+			continue
+		}
+		if handler.log.DebugEnabled() {
+			handler.log.Debug().Message(fmt.Sprintf("resume job %s of %s", job.Id, job.Domain))
+		}
+		handler.runJob(job)
+	}
+	return
+}
+
+// watchJobs removes finished jobs which are older than retention every interval until ctx is done.
+func (handler *Handler) watchJobs(ctx context.Context, retention time.Duration, interval time.Duration) {
+	for {
+		handler.sweepJobs(ctx, time.Now().Add(-retention))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// sweepJobs removes finished jobs which were last updated before deadline.
+func (handler *Handler) sweepJobs(ctx context.Context, deadline time.Time) {
+	jobs, listErr := handler.stores.ListJobs(ctx)
+	if listErr != nil {
+		handler.log.Warn().Cause(listErr).Message("acmes: sweep jobs failed")
+		return
+	}
+	for _, job := range jobs {
+		if !job.Finished() || !job.UpdateAT.Before(deadline) {
+			continue
+		}
+		if removeErr := handler.stores.RemoveJob(ctx, job.Id); removeErr != nil {
+			handler.log.Warn().Cause(removeErr).Message(fmt.Sprintf("acmes: remove job %s failed", job.Id))
+		}
+	}
+}
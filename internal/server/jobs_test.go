@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/aacfactory/logs"
+	"testing"
+	"time"
+)
+
+func TestHandler_sweepJobs(t *testing.T) {
+	log, logErr := logs.New(logs.WithConsoleWriterFormatter(logs.TextFormatter), logs.WithLevel(logs.ErrorLevel))
+	if logErr != nil {
+		t.Fatal(logErr)
+	}
+	stores, storeErr := store.NewFileStore(t.TempDir())
+	if storeErr != nil {
+		t.Fatal(storeErr)
+	}
+	handler := &Handler{log: log, stores: stores}
+	now := time.Now()
+	jobs := []struct {
+		job  store.Job
+		kept bool
+	}{
+		{store.Job{Id: "expired", State: store.JobIssued, UpdateAT: now.Add(-jobRetention - time.Minute)}, false},
+		{store.Job{Id: "expired-failure", State: store.JobFailed, UpdateAT: now.Add(-jobRetention - time.Minute)}, false},
+		{store.Job{Id: "fresh", State: store.JobIssued, UpdateAT: now.Add(-time.Hour)}, true},
+		{store.Job{Id: "unfinished", State: store.JobValidating, UpdateAT: now.Add(-jobRetention - time.Minute)}, true},
+	}
+	for _, j := range jobs {
+		job := j.job
+		if err := stores.SaveJob(context.TODO(), &job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler.sweepJobs(context.TODO(), now.Add(-jobRetention))
+	for _, j := range jobs {
+		_, has, err := stores.GetJob(context.TODO(), j.job.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if has != j.kept {
+			t.Errorf("job %s is kept %v, want %v", j.job.Id, has, j.kept)
+		}
+	}
+}
+
+func TestJobResultOf(t *testing.T) {
+	p, _ := json.Marshal(jobResultOf(&store.Job{
+		Id:           "1",
+		Email:        "foo@bar.com",
+		Domain:       "www.foo.com",
+		State:        store.JobFailed,
+		Cause:        "timeout",
+		Code:         ErrorInternal,
+		Client:       "client",
+		ClientSerial: "01",
+		RemoteAddr:   "127.0.0.1:1234",
+	}))
+	fields := map[string]interface{}{}
+	_ = json.Unmarshal(p, &fields)
+	if len(fields) != 5 || fields["id"] != "1" || fields["domain"] != "www.foo.com" || fields["state"] != store.JobFailed ||
+		fields["cause"] != "timeout" || fields["code"] != ErrorInternal {
+		t.Fatalf("job result is %s", p)
+	}
+}
@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"github.com/aacfactory/logs"
 	"github.com/go-acme/lego/v4/log"
 	slog "log"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 func createLog(level string, formatter string) (v logs.Logger, err error) {
@@ -37,13 +40,79 @@ func createLog(level string, formatter string) (v logs.Logger, err error) {
 		logs.WithConsoleWriterFormatter(lf),
 		logs.WithLevel(logLevel),
 	)
-	if err != nil {
-		return
-	}
+	return
+}
+
+// useLog makes lego write its logs into v.
+func useLog(v logs.Logger) {
 	log.Logger = slog.New(&writer{
 		core: v,
 	}, "", slog.LstdFlags)
-	return
+}
+
+// swappableLogger delegates to the current logger, so that level and formatter can be changed while running.
+type swappableLogger struct {
+	current atomic.Pointer[loggerHolder]
+}
+
+type loggerHolder struct {
+	logs.Logger
+}
+
+func newSwappableLogger(v logs.Logger) *swappableLogger {
+	s := &swappableLogger{}
+	s.current.Store(&loggerHolder{Logger: v})
+	return s
+}
+
+// swap replaces the current logger by v, and shuts the previous one down after pending events are written.
+func (s *swappableLogger) swap(v logs.Logger) {
+	prev := s.current.Swap(&loggerHolder{Logger: v})
+	go func(prev logs.Logger) {
+		ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Second)
+		defer cancel()
+		_ = prev.Shutdown(ctx)
+	}(prev.Logger)
+}
+
+func (s *swappableLogger) With(key string, value any) logs.Logger {
+	return s.current.Load().With(key, value)
+}
+
+func (s *swappableLogger) DebugEnabled() bool {
+	return s.current.Load().DebugEnabled()
+}
+
+func (s *swappableLogger) Debug() logs.Event {
+	return s.current.Load().Debug()
+}
+
+func (s *swappableLogger) InfoEnabled() bool {
+	return s.current.Load().InfoEnabled()
+}
+
+func (s *swappableLogger) Info() logs.Event {
+	return s.current.Load().Info()
+}
+
+func (s *swappableLogger) WarnEnabled() bool {
+	return s.current.Load().WarnEnabled()
+}
+
+func (s *swappableLogger) Warn() logs.Event {
+	return s.current.Load().Warn()
+}
+
+func (s *swappableLogger) ErrorEnabled() bool {
+	return s.current.Load().ErrorEnabled()
+}
+
+func (s *swappableLogger) Error() logs.Event {
+	return s.current.Load().Error()
+}
+
+func (s *swappableLogger) Shutdown(ctx context.Context) error {
+	return s.current.Load().Shutdown(ctx)
 }
 
 type writer struct {
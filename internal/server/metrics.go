@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+const (
+	resultSucceed = "succeed"
+	resultFailed  = "failed"
+)
+
+func resultOf(err error) string {
+	if err != nil {
+		return resultFailed
+	}
+	return resultSucceed
+}
+
+type Metrics struct {
+	registry          *prometheus.Registry
+	requests          *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	orderDuration     *prometheus.HistogramVec
+	challengeDuration *prometheus.HistogramVec
+	barrierShared     *prometheus.CounterVec
+	rateLimited       *prometheus.CounterVec
+	storeDuration     *prometheus.HistogramVec
+}
+
+func createMetrics() (v *Metrics) {
+	v = &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "acmes",
+			Name:      "requests_total",
+			Help:      "Number of obtain and renew requests by result.",
+		}, []string{"operation", "result"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "acmes",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of obtain and renew requests.",
+			Buckets:   []float64{.01, .1, 1, 5, 15, 30, 60, 120, 300, 600},
+		}, []string{"operation", "result"}),
+		orderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "acmes",
+			Name:      "acme_order_duration_seconds",
+			Help:      "Duration of acme orders placed at the ca.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600},
+		}, []string{"operation", "result"}),
+		challengeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "acmes",
+			Name:      "dns_challenge_duration_seconds",
+			Help:      "Duration from presenting a dns-01 challenge record to cleaning it up.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600},
+		}, []string{"result"}),
+		barrierShared: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "acmes",
+			Name:      "singleflight_shared_total",
+			Help:      "Number of requests which shared the result of an in-flight request.",
+		}, []string{"operation"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "acmes",
+			Name:      "rate_limited_total",
+			Help:      "Number of orders which were rejected by rate limits before they were placed.",
+		}, []string{"operation"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "acmes",
+			Name:      "store_operation_duration_seconds",
+			Help:      "Duration of store operations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+	}
+	v.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		v.requests,
+		v.requestDuration,
+		v.orderDuration,
+		v.challengeDuration,
+		v.barrierShared,
+		v.rateLimited,
+		v.storeDuration,
+	)
+	return
+}
+
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *Metrics) observeRequest(operation string, beg time.Time, err error) {
+	result := resultOf(err)
+	m.requests.WithLabelValues(operation, result).Inc()
+	m.requestDuration.WithLabelValues(operation, result).Observe(time.Since(beg).Seconds())
+}
+
+func (m *Metrics) observeOrder(operation string, beg time.Time, err error) {
+	m.orderDuration.WithLabelValues(operation, resultOf(err)).Observe(time.Since(beg).Seconds())
+}
+
+func (m *Metrics) observeShared(operation string, shared bool) {
+	if shared {
+		m.barrierShared.WithLabelValues(operation).Inc()
+	}
+}
+
+func (m *Metrics) observeRateLimited(operation string) {
+	m.rateLimited.WithLabelValues(operation).Inc()
+}
+
+func (m *Metrics) observeStore(operation string, beg time.Time, err error) {
+	m.storeDuration.WithLabelValues(operation, resultOf(err)).Observe(time.Since(beg).Seconds())
+}
+
+// watchCertificates registers a gauge of seconds until expiry for every certificate of emails,
+// it is read from the store on each scrape so that certificates obtained before a restart are included.
+func (m *Metrics) watchCertificates(emails []string, stores store.Store) {
+	m.registry.MustRegister(&certificateCollector{
+		emails: emails,
+		stores: stores,
+		desc: prometheus.NewDesc(
+			"acmes_certificate_expiry_seconds",
+			"Seconds until the managed certificate expires.",
+			[]string{"email", "domain"},
+			nil,
+		),
+	})
+}
+
+type certificateCollector struct {
+	emails []string
+	stores store.Store
+	desc   *prometheus.Desc
+}
+
+func (c *certificateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *certificateCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.TODO()
+	for _, email := range c.emails {
+		domains, listErr := c.stores.ListUserCertificates(ctx, email)
+		if listErr != nil {
+			ch <- prometheus.NewInvalidMetric(c.desc, listErr)
+			continue
+		}
+		for _, domain := range domains {
+			cert, has, getErr := c.stores.GetUserCertificate(ctx, email, domain)
+			if getErr != nil || !has {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(cert.NotAfter).Seconds(), email, domain)
+		}
+	}
+}
+
+// instrumentStore wraps stores, and records latency and spans of each operation.
+func instrumentStore(stores store.Store, metrics *Metrics) store.Store {
+	return &instrumentedStore{
+		stores:  stores,
+		metrics: metrics,
+	}
+}
+
+type instrumentedStore struct {
+	stores  store.Store
+	metrics *Metrics
+}
+
+func (s *instrumentedStore) GetUser(ctx context.Context, email string) (user *store.User, has bool, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.GetUser")
+	user, has, err = s.stores.GetUser(ctx, email)
+	s.metrics.observeStore("get_user", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) SaveUser(ctx context.Context, user *store.User) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.SaveUser")
+	err = s.stores.SaveUser(ctx, user)
+	s.metrics.observeStore("save_user", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) GetUserCertificate(ctx context.Context, email string, domain string) (cert *store.Certificate, has bool, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.GetUserCertificate")
+	cert, has, err = s.stores.GetUserCertificate(ctx, email, domain)
+	s.metrics.observeStore("get_user_certificate", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) SaveUserCertificate(ctx context.Context, email string, domain string, cert *store.Certificate) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.SaveUserCertificate")
+	err = s.stores.SaveUserCertificate(ctx, email, domain, cert)
+	s.metrics.observeStore("save_user_certificate", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) SaveUserCertificateOCSP(ctx context.Context, email string, domain string, serial *big.Int, response []byte, nextUpdate time.Time) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.SaveUserCertificateOCSP")
+	err = s.stores.SaveUserCertificateOCSP(ctx, email, domain, serial, response, nextUpdate)
+	s.metrics.observeStore("save_user_certificate_ocsp", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) RemoveUserCertificate(ctx context.Context, email string, domain string) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.RemoveUserCertificate")
+	err = s.stores.RemoveUserCertificate(ctx, email, domain)
+	s.metrics.observeStore("remove_user_certificate", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) ListUserCertificates(ctx context.Context, email string) (domains []string, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.ListUserCertificates")
+	domains, err = s.stores.ListUserCertificates(ctx, email)
+	s.metrics.observeStore("list_user_certificates", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) AppendAudit(ctx context.Context, line []byte) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.AppendAudit")
+	err = s.stores.AppendAudit(ctx, line)
+	s.metrics.observeStore("append_audit", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) ReadAudit(ctx context.Context) (reader io.ReadCloser, has bool, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.ReadAudit")
+	reader, has, err = s.stores.ReadAudit(ctx)
+	s.metrics.observeStore("read_audit", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) SaveJob(ctx context.Context, job *store.Job) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.SaveJob")
+	err = s.stores.SaveJob(ctx, job)
+	s.metrics.observeStore("save_job", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) GetJob(ctx context.Context, id string) (job *store.Job, has bool, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.GetJob")
+	job, has, err = s.stores.GetJob(ctx, id)
+	s.metrics.observeStore("get_job", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) ListJobs(ctx context.Context) (jobs []*store.Job, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.ListJobs")
+	jobs, err = s.stores.ListJobs(ctx)
+	s.metrics.observeStore("list_jobs", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) RemoveJob(ctx context.Context, id string) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.RemoveJob")
+	err = s.stores.RemoveJob(ctx, id)
+	s.metrics.observeStore("remove_job", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) GetChallengeAccount(ctx context.Context, provider string, domain string) (account *store.ChallengeAccount, has bool, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.GetChallengeAccount")
+	account, has, err = s.stores.GetChallengeAccount(ctx, provider, domain)
+	s.metrics.observeStore("get_challenge_account", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) SaveChallengeAccount(ctx context.Context, account *store.ChallengeAccount) (err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.SaveChallengeAccount")
+	err = s.stores.SaveChallengeAccount(ctx, account)
+	s.metrics.observeStore("save_challenge_account", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) ListChallengeAccounts(ctx context.Context, provider string) (accounts []*store.ChallengeAccount, err error) {
+	beg := time.Now()
+	ctx, span := startSpan(ctx, "store.ListChallengeAccounts")
+	accounts, err = s.stores.ListChallengeAccounts(ctx, provider)
+	s.metrics.observeStore("list_challenge_accounts", beg, err)
+	endSpan(span, err)
+	return
+}
+
+func (s *instrumentedStore) Lock(ctx context.Context, shared bool) (unlock func(), err error) {
+	unlock, err = s.stores.Lock(ctx, shared)
+	return
+}
+
+func (s *instrumentedStore) Close(ctx context.Context) (err error) {
+	err = s.stores.Close(ctx)
+	return
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/aacfactory/logs"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// ocspDefaultValidity is how long a response without next update is treated as fresh.
+	ocspDefaultValidity = 48 * time.Hour
+	ocspTimeout         = 30 * time.Second
+	ocspMaxResponseSize = 1 << 20
+)
+
+// watchOCSP scans certificates of emails every interval until ctx is done, and refreshes their ocsp responses
+// when half of the validity passed, subscribers of a refreshed domain are told to get it again.
+func watchOCSP(ctx context.Context, log logs.Logger, emails []string, stores store.Store, events *Events, interval time.Duration) {
+	for {
+		for _, email := range emails {
+			scanOCSP(ctx, log, email, stores, events)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+func scanOCSP(ctx context.Context, log logs.Logger, email string, stores store.Store, events *Events) {
+	domains, listErr := stores.ListUserCertificates(ctx, email)
+	if listErr != nil {
+		log.Warn().Cause(listErr).Message(fmt.Sprintf("acmes: scan ocsp of %s failed", email))
+		return
+	}
+	for _, domain := range domains {
+		cert, has, getErr := stores.GetUserCertificate(ctx, email, domain)
+		if getErr != nil {
+			log.Warn().Cause(getErr).Message(fmt.Sprintf("acmes: scan ocsp of %s failed", domain))
+			continue
+		}
+		if !has || time.Now().After(cert.NotAfter) {
+			continue
+		}
+		stapled, stapleErr := stapleOCSP(ctx, stores, email, domain, cert)
+		if stapleErr != nil {
+			log.Warn().Cause(stapleErr).Message(fmt.Sprintf("acmes: refresh ocsp of %s failed", domain))
+			continue
+		}
+		if stapled {
+			events.publish(EventUpdated, domain, cert.NotAfter)
+		}
+	}
+}
+
+// stapleOCSP fetches the ocsp response of cert when it has none or the one it has is due, and keeps it beside cert.
+// stapled is false when the response is fresh or the ca has no ocsp responder.
+func stapleOCSP(ctx context.Context, stores store.Store, email string, domain string, cert *store.Certificate) (stapled bool, err error) {
+	block, _ := pem.Decode(cert.Leaf)
+	if block == nil {
+		err = fmt.Errorf("leaf of %s is not pem", domain)
+		return
+	}
+	leaf, parseErr := x509.ParseCertificate(block.Bytes)
+	if parseErr != nil {
+		err = fmt.Errorf("leaf of %s can not be parsed, %v", domain, parseErr)
+		return
+	}
+	if len(leaf.OCSPServer) == 0 || !ocspDue(cert.OCSP, time.Now()) {
+		return
+	}
+	raw, response, fetchErr := fetchOCSP(ctx, cert.Chain)
+	if fetchErr != nil {
+		err = fmt.Errorf("get ocsp of %s failed, %v", domain, fetchErr)
+		return
+	}
+	switch response.Status {
+	case ocsp.Good, ocsp.Revoked:
+		// a revoked status is stapled as well, clients must not trust the certificate either way
+	default:
+		err = fmt.Errorf("ocsp status of %s is unknown", domain)
+		return
+	}
+	nextUpdate := response.NextUpdate
+	if nextUpdate.IsZero() {
+		nextUpdate = response.ThisUpdate.Add(ocspDefaultValidity)
+	}
+	if !nextUpdate.After(time.Now()) {
+		err = fmt.Errorf("ocsp of %s is stale, its next update is %s", domain, nextUpdate.Format(time.RFC3339))
+		return
+	}
+	err = stores.SaveUserCertificateOCSP(ctx, email, domain, leaf.SerialNumber, raw, nextUpdate)
+	if err != nil {
+		return
+	}
+	cert.OCSP = raw
+	cert.OCSPNextUpdate = nextUpdate
+	stapled = true
+	return
+}
+
+// fetchOCSP posts the ocsp request of the leaf of chain to its responder, the response must be signed by the issuer in chain.
+func fetchOCSP(ctx context.Context, chain []byte) (raw []byte, response *ocsp.Response, err error) {
+	certificates, parseErr := certcrypto.ParsePEMBundle(chain)
+	if parseErr != nil {
+		err = parseErr
+		return
+	}
+	if len(certificates) < 2 {
+		err = fmt.Errorf("the chain has no issuer of the leaf")
+		return
+	}
+	leaf, issuer := certificates[0], certificates[1]
+	request, requestErr := ocsp.CreateRequest(leaf, issuer, nil)
+	if requestErr != nil {
+		err = requestErr
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, ocspTimeout)
+	defer cancel()
+	httpRequest, httpRequestErr := http.NewRequestWithContext(ctx, http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(request))
+	if httpRequestErr != nil {
+		err = httpRequestErr
+		return
+	}
+	httpRequest.Header.Set("Content-Type", "application/ocsp-request")
+	resp, postErr := http.DefaultClient.Do(httpRequest)
+	if postErr != nil {
+		err = postErr
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s responded %s", leaf.OCSPServer[0], resp.Status)
+		return
+	}
+	raw, err = io.ReadAll(io.LimitReader(resp.Body, ocspMaxResponseSize))
+	if err != nil {
+		return
+	}
+	response, err = ocsp.ParseResponseForCert(raw, leaf, issuer)
+	return
+}
+
+// ocspDue is true when raw is empty, can not be parsed, or half of its validity passed at now.
+func ocspDue(raw []byte, now time.Time) bool {
+	if len(raw) == 0 {
+		return true
+	}
+	response, parseErr := ocsp.ParseResponse(raw, nil)
+	if parseErr != nil {
+		return true
+	}
+	nextUpdate := response.NextUpdate
+	if nextUpdate.IsZero() {
+		nextUpdate = response.ThisUpdate.Add(ocspDefaultValidity)
+	}
+	refreshAT := response.ThisUpdate.Add(nextUpdate.Sub(response.ThisUpdate) / 2)
+	return !now.Before(refreshAT)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"golang.org/x/crypto/ocsp"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestOcspDue(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ocsp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(1, 0, 0),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, createErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if createErr != nil {
+		t.Fatal(createErr)
+	}
+	issuer, _ := x509.ParseCertificate(der)
+	thisUpdate := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	response := func(nextUpdate time.Time) []byte {
+		raw, err := ocsp.CreateResponse(issuer, issuer, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: big.NewInt(2),
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   nextUpdate,
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	week := response(thisUpdate.AddDate(0, 0, 7))
+	endless := response(time.Time{})
+	cases := []struct {
+		name string
+		raw  []byte
+		now  time.Duration
+		due  bool
+	}{
+		{"missing response", nil, 0, true},
+		{"unparseable response", []byte("not ocsp"), 0, true},
+		{"fresh", week, 24 * time.Hour, false},
+		{"right before half of validity", week, 84*time.Hour - time.Second, false},
+		{"half of validity", week, 84 * time.Hour, true},
+		{"expired", week, 8 * 24 * time.Hour, true},
+		{"missing next update is fresh", endless, ocspDefaultValidity/2 - time.Second, false},
+		{"missing next update at half of default validity", endless, ocspDefaultValidity / 2, true},
+	}
+	for _, c := range cases {
+		if due := ocspDue(c.raw, thisUpdate.Add(c.now)); due != c.due {
+			t.Errorf("%s: due is %v, want %v", c.name, due, c.due)
+		}
+	}
+}
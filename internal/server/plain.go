@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aacfactory/logs"
+	"net"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// plainServers collects the handlers which are served over plain http without client cert, such as metrics and probes.
+// Handlers on the same port share one server.
+type plainServers map[int]*http.ServeMux
+
+func (servers plainServers) handle(port int, pattern string, handler http.Handler) {
+	if port < 1 {
+		return
+	}
+	mux, has := servers[port]
+	if !has {
+		mux = http.NewServeMux()
+		servers[port] = mux
+	}
+	mux.Handle(pattern, handler)
+}
+
+func (servers plainServers) serve(log logs.Logger, probes *Probes) (srvs []*http.Server, err error) {
+	ports := make([]int, 0, len(servers))
+	for port := range servers {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+	srvs = make([]*http.Server, 0, len(ports))
+	for _, port := range ports {
+		running := probes.job(fmt.Sprintf("http:%d", port))
+		srv, serveErr := servePlain(log, port, servers[port], running)
+		if serveErr != nil {
+			err = serveErr
+			return
+		}
+		srvs = append(srvs, srv)
+	}
+	return
+}
+
+func servePlain(log logs.Logger, port int, handler http.Handler, running *atomic.Bool) (srv *http.Server, err error) {
+	ln, lnErr := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if lnErr != nil {
+		err = fmt.Errorf("acmes: serve plain http failed, %v", lnErr)
+		return
+	}
+	srv = &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	running.Store(true)
+	go func() {
+		serveErr := srv.Serve(ln)
+		running.Store(false)
+		if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+			log.Error().Cause(serveErr).Message(fmt.Sprintf("acmes: serve plain http at :%d failed", port))
+		}
+	}()
+	if log.DebugEnabled() {
+		log.Debug().Message(fmt.Sprintf("serve plain http at :%d", port))
+	}
+	return
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/go-acme/lego/v4/lego"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type probeResult struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Probes serves /healthz for liveness and /readyz for readiness.
+// Liveness only looks at the process itself, the listener and the background jobs,
+// readiness also checks the dependencies which obtaining a certificate needs.
+type Probes struct {
+	accounts   []AccountConfig
+	stores     store.Store
+	httpClient *http.Client
+	mutex      sync.RWMutex
+	jobs       map[string]*atomic.Bool
+}
+
+func createProbes(accounts []AccountConfig, stores store.Store) *Probes {
+	return &Probes{
+		accounts: accounts,
+		stores:   stores,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		mutex: sync.RWMutex{},
+		jobs:  make(map[string]*atomic.Bool),
+	}
+}
+
+// job returns the running flag of the named job, the job is alive only while the flag is true.
+func (p *Probes) job(name string) *atomic.Bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	running, has := p.jobs[name]
+	if !has {
+		running = &atomic.Bool{}
+		p.jobs[name] = running
+	}
+	return running
+}
+
+func (p *Probes) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", p.healthz)
+	mux.HandleFunc("/readyz", p.readyz)
+	return mux
+}
+
+func (p *Probes) healthz(writer http.ResponseWriter, _ *http.Request) {
+	result := &probeResult{
+		Status: "ok",
+		Checks: make(map[string]string),
+	}
+	p.mutex.RLock()
+	names := make([]string, 0, len(p.jobs))
+	for name := range p.jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if p.jobs[name].Load() {
+			result.Checks[name] = "ok"
+			continue
+		}
+		result.Status = "failed"
+		result.Checks[name] = "not running"
+	}
+	p.mutex.RUnlock()
+	p.write(writer, result)
+}
+
+func (p *Probes) readyz(writer http.ResponseWriter, request *http.Request) {
+	result := &probeResult{
+		Status: "ok",
+		Checks: make(map[string]string),
+	}
+	ctx, cancel := context.WithTimeout(request.Context(), 5*time.Second)
+	defer cancel()
+	checks := map[string]func(ctx context.Context) error{
+		"store":     p.checkStore,
+		"account":   p.checkAccount,
+		"directory": p.checkDirectory,
+	}
+	for name, check := range checks {
+		checkErr := check(ctx)
+		if checkErr != nil {
+			result.Status = "failed"
+			result.Checks[name] = checkErr.Error()
+			continue
+		}
+		result.Checks[name] = "ok"
+	}
+	p.write(writer, result)
+}
+
+func (p *Probes) checkStore(ctx context.Context) (err error) {
+	_, err = p.stores.ListUserCertificates(ctx, p.accounts[0].Email)
+	return
+}
+
+// checkAccount checks that every account is registered.
+func (p *Probes) checkAccount(ctx context.Context) (err error) {
+	for _, account := range p.accounts {
+		user, has, getErr := p.stores.GetUser(ctx, account.Email)
+		if getErr != nil {
+			err = getErr
+			return
+		}
+		if !has {
+			err = fmt.Errorf("%s is not registered", account.Email)
+			return
+		}
+		reg := user.GetRegistration()
+		if reg == nil || reg.URI == "" {
+			err = fmt.Errorf("%s is not registered", account.Email)
+			return
+		}
+	}
+	return
+}
+
+// checkDirectory checks that directories of all accounts are reachable.
+func (p *Probes) checkDirectory(ctx context.Context) (err error) {
+	checked := make(map[string]struct{})
+	for _, account := range p.accounts {
+		directory := account.Directory
+		if directory == "" {
+			directory = lego.LEDirectoryProduction
+		}
+		if _, has := checked[directory]; has {
+			continue
+		}
+		checked[directory] = struct{}{}
+		err = p.checkURL(ctx, directory)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (p *Probes) checkURL(ctx context.Context, directory string) (err error) {
+	request, requestErr := http.NewRequestWithContext(ctx, http.MethodGet, directory, nil)
+	if requestErr != nil {
+		err = requestErr
+		return
+	}
+	resp, getErr := p.httpClient.Do(request)
+	if getErr != nil {
+		err = getErr
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("%s responded %s", directory, resp.Status)
+		return
+	}
+	return
+}
+
+func (p *Probes) write(writer http.ResponseWriter, result *probeResult) {
+	body, _ := json.Marshal(result)
+	writer.Header().Set("Content-Type", "application/json")
+	if result.Status != "ok" {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		writer.WriteHeader(http.StatusOK)
+	}
+	_, _ = writer.Write(body)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+// propagationQueryTimeout is the timeout of each query of checkAtResolvers.
+const propagationQueryTimeout = 10 * time.Second
+
+// systemResolvers returns resolvers of /etc/resolv.conf, or the ones which lego falls back to when it can not be read.
+func systemResolvers() []string {
+	config, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(config.Servers) == 0 {
+		return []string{"google-public-dns-a.google.com:53", "google-public-dns-b.google.com:53"}
+	}
+	return dns01.ParseNameservers(config.Servers)
+}
+
+// checkAtResolvers replaces the propagation check of lego, the TXT record must have the value at every resolver,
+// authoritative nameservers of the zone are not asked. Resolvers follow cnames of the record themselves.
+// It is used when authoritative nameservers are not reachable from acmes.
+func checkAtResolvers(resolvers []string) dns01.WrapPreCheckFunc {
+	if len(resolvers) == 0 {
+		resolvers = systemResolvers()
+	}
+	return func(_, fqdn, value string, _ dns01.PreCheckFunc) (ok bool, err error) {
+		for _, resolver := range resolvers {
+			found, queryErr := hasTXT(resolver, fqdn, value)
+			if queryErr != nil {
+				err = fmt.Errorf("resolver %s failed to answer txt of %s, %v", resolver, fqdn, queryErr)
+				return
+			}
+			if !found {
+				err = fmt.Errorf("resolver %s did not return the expected txt of %s", resolver, fqdn)
+				return
+			}
+		}
+		ok = true
+		return
+	}
+}
+
+func hasTXT(resolver string, fqdn string, value string) (found bool, err error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(fqdn), dns.TypeTXT)
+	m.SetEdns0(4096, false)
+	client := &dns.Client{Net: "udp", Timeout: propagationQueryTimeout}
+	in, _, exchangeErr := client.Exchange(m, resolver)
+	if exchangeErr == nil && in.Truncated {
+		client.Net = "tcp"
+		in, _, exchangeErr = client.Exchange(m, resolver)
+	}
+	if exchangeErr != nil {
+		err = exchangeErr
+		return
+	}
+	if in.Rcode != dns.RcodeSuccess && in.Rcode != dns.RcodeNameError {
+		err = fmt.Errorf("%s", dns.RcodeToString[in.Rcode])
+		return
+	}
+	for _, rr := range in.Answer {
+		if txt, isTXT := rr.(*dns.TXT); isTXT && strings.Join(txt.Txt, "") == value {
+			found = true
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"github.com/aacfactory/acmes/internal/responder"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"testing"
+)
+
+func TestCheckAtResolvers(t *testing.T) {
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	r := responder.New(responder.Options{
+		Zones:      []string{"acme.foo.test"},
+		Nameserver: "ns.acme.foo.test",
+	})
+	if err := r.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown(context.TODO())
+	keyAuth := "token.thumbprint"
+	fqdn, value := dns01.GetRecord("www.acme.foo.test", keyAuth)
+	check := checkAtResolvers([]string{r.Addr()})
+	unused := func(string, string) (bool, error) {
+		t.Fatal("check of lego is called")
+		return false, nil
+	}
+
+	if ok, err := check("www.acme.foo.test", fqdn, value, unused); ok || err == nil {
+		t.Fatal("absent record is propagated")
+	}
+	if err := r.Present("www.acme.foo.test", "token", keyAuth); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := check("www.acme.foo.test", fqdn, value, unused); !ok || err != nil {
+		t.Fatalf("present record is not propagated, %v", err)
+	}
+	if ok, err := check("www.acme.foo.test", fqdn, "other", unused); ok || err == nil {
+		t.Fatal("record of other value is propagated")
+	}
+	if ok, err := checkAtResolvers([]string{r.Addr(), "127.0.0.1:1"})("www.acme.foo.test", fqdn, value, unused); ok || err == nil {
+		t.Fatal("record which one resolver does not answer is propagated")
+	}
+}
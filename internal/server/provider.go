@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"sync"
+	"time"
+)
+
+// trackProvider wraps the dns provider, records the time from presenting a challenge record to cleaning it up,
+// and keeps presented records which are not cleaned up yet, so that they can be removed on shutdown.
+// Timeout and Sequential of the wrapped provider are kept, because lego changes its behavior by them,
+// the timeout and interval of propagation override the timeout of the provider when they are set.
+func trackProvider(provider challenge.Provider, metrics *Metrics, propagation PropagationConfig) (tracked *trackedProvider, v challenge.Provider) {
+	tracked = &trackedProvider{
+		provider: provider,
+		metrics:  metrics,
+		timeout:  propagation.Timeout,
+		interval: propagation.Interval,
+		mutex:    sync.Mutex{},
+		presents: make(map[string]*presented),
+	}
+	v = tracked
+	if _, ok := provider.(sequentialProvider); ok {
+		v = &trackedSequentialProvider{
+			trackedProvider: tracked,
+		}
+	}
+	return
+}
+
+type sequentialProvider interface {
+	Sequential() time.Duration
+}
+
+type presented struct {
+	domain  string
+	token   string
+	keyAuth string
+	at      time.Time
+}
+
+type trackedProvider struct {
+	provider challenge.Provider
+	metrics  *Metrics
+	timeout  time.Duration
+	interval time.Duration
+	mutex    sync.Mutex
+	presents map[string]*presented
+}
+
+func (p *trackedProvider) Present(domain, token, keyAuth string) (err error) {
+	beg := time.Now()
+	err = p.provider.Present(domain, token, keyAuth)
+	if err != nil {
+		p.metrics.challengeDuration.WithLabelValues(resultFailed).Observe(time.Since(beg).Seconds())
+		return
+	}
+	p.mutex.Lock()
+	p.presents[domain+token] = &presented{
+		domain:  domain,
+		token:   token,
+		keyAuth: keyAuth,
+		at:      beg,
+	}
+	p.mutex.Unlock()
+	return
+}
+
+func (p *trackedProvider) CleanUp(domain, token, keyAuth string) (err error) {
+	err = p.provider.CleanUp(domain, token, keyAuth)
+	p.mutex.Lock()
+	record, has := p.presents[domain+token]
+	delete(p.presents, domain+token)
+	p.mutex.Unlock()
+	if has {
+		p.metrics.challengeDuration.WithLabelValues(resultOf(err)).Observe(time.Since(record.at).Seconds())
+	}
+	return
+}
+
+func (p *trackedProvider) Timeout() (timeout, interval time.Duration) {
+	timeout, interval = dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+	if pt, ok := p.provider.(challenge.ProviderTimeout); ok {
+		timeout, interval = pt.Timeout()
+	}
+	if p.timeout > 0 {
+		timeout = p.timeout
+	}
+	if p.interval > 0 {
+		interval = p.interval
+	}
+	return
+}
+
+// cleanUpPending removes records which were presented but not cleaned up, such as ones of orders interrupted by shutdown.
+func (p *trackedProvider) cleanUpPending() (err error) {
+	p.mutex.Lock()
+	pending := make([]*presented, 0, len(p.presents))
+	for _, record := range p.presents {
+		pending = append(pending, record)
+	}
+	p.mutex.Unlock()
+	for _, record := range pending {
+		cleanUpErr := p.CleanUp(record.domain, record.token, record.keyAuth)
+		if cleanUpErr != nil && err == nil {
+			err = fmt.Errorf("acmes: clean up challenge of %s failed, %v", record.domain, cleanUpErr)
+		}
+	}
+	return
+}
+
+type trackedSequentialProvider struct {
+	*trackedProvider
+}
+
+func (p *trackedSequentialProvider) Sequential() time.Duration {
+	return p.provider.(sequentialProvider).Sequential()
+}
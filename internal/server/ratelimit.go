@@ -0,0 +1,190 @@
+package server
+
+import (
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// window counts events of each key in a sliding window.
+type window struct {
+	limit  int
+	period time.Duration
+	events map[string][]time.Time
+}
+
+func newWindow(limit int, period time.Duration) *window {
+	return &window{
+		limit:  limit,
+		period: period,
+		events: make(map[string][]time.Time),
+	}
+}
+
+// retryAfter returns how long to wait until one more event of key is allowed, 0 means it is allowed now.
+func (w *window) retryAfter(key string, now time.Time) time.Duration {
+	if w.limit < 1 {
+		return 0
+	}
+	events := w.prune(key, now)
+	if len(events) < w.limit {
+		return 0
+	}
+	return events[len(events)-w.limit].Add(w.period).Sub(now)
+}
+
+func (w *window) add(key string, now time.Time) {
+	if w.limit < 1 {
+		return
+	}
+	w.events[key] = append(w.prune(key, now), now)
+}
+
+// sweep prunes every key, so that keys which are never seen again do not stay.
+func (w *window) sweep(now time.Time) {
+	for key := range w.events {
+		w.prune(key, now)
+	}
+}
+
+func (w *window) prune(key string, now time.Time) []time.Time {
+	events := w.events[key]
+	i := 0
+	for i < len(events) && now.Sub(events[i]) >= w.period {
+		i++
+	}
+	events = events[i:]
+	if len(events) == 0 {
+		delete(w.events, key)
+		return nil
+	}
+	w.events[key] = events
+	return events
+}
+
+// Limiter rejects orders before they are placed at the ca, so that one client can not exhaust the limits of the ca for all.
+// Counts are kept in memory, they restart from zero after a restart.
+type Limiter struct {
+	mutex    sync.Mutex
+	disabled bool
+	now      func() time.Time
+	swept    time.Time
+	clients  *window
+	account  *window
+	domains  *window
+	failures *window
+}
+
+func createLimiter(config RateLimitConfig) *Limiter {
+	config = config.withDefaults()
+	return &Limiter{
+		disabled: config.Disabled,
+		now:      time.Now,
+		clients:  newWindow(config.ClientOrders, config.ClientWindow),
+		account:  newWindow(config.AccountOrders, config.AccountWindow),
+		domains:  newWindow(config.DomainCertificates, config.DomainWindow),
+		failures: newWindow(config.FailedValidations, config.FailedWindow),
+	}
+}
+
+// reserve checks limits of an order of domain by the client with the acme account, and counts the order when it is allowed.
+func (l *Limiter) reserve(client string, account string, domain string) (err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.disabled {
+		return
+	}
+	now := l.now()
+	l.sweep(now)
+	registered := registeredDomain(domain)
+	checks := []struct {
+		window *window
+		key    string
+		cause  string
+	}{
+		{l.clients, client, fmt.Sprintf("too many orders of client %s", client)},
+		{l.account, account, fmt.Sprintf("too many orders of the acme account %s", account)},
+		{l.domains, registered, fmt.Sprintf("too many certificates of %s", registered)},
+		{l.failures, domain, fmt.Sprintf("too many failed validations of %s", domain)},
+	}
+	for _, check := range checks {
+		if retryAfter := check.window.retryAfter(check.key, now); retryAfter > 0 {
+			err = newRateLimitedError(retryAfter, "%s, retry after %s", check.cause, retryAfter.Round(time.Second))
+			return
+		}
+	}
+	l.clients.add(client, now)
+	l.account.add(account, now)
+	return
+}
+
+// done counts the result of an order of domain, issued certificates count against the registered domain,
+// failed challenges count against the domain.
+func (l *Limiter) done(domain string, err error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.disabled {
+		return
+	}
+	now := l.now()
+	if err == nil {
+		l.domains.add(registeredDomain(domain), now)
+		return
+	}
+	if errorOf(err).Code == ErrorChallengeFailed {
+		l.failures.add(domain, now)
+	}
+}
+
+// limiterSweepInterval is how often keys whose events all passed are removed.
+const limiterSweepInterval = 10 * time.Minute
+
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < limiterSweepInterval {
+		return
+	}
+	l.swept = now
+	for _, w := range []*window{l.clients, l.account, l.domains, l.failures} {
+		w.sweep(now)
+	}
+}
+
+func (l *Limiter) hook(config *Config) (apply func(), err error) {
+	next := config.RateLimit.withDefaults()
+	apply = func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.disabled = next.Disabled
+		l.clients.limit, l.clients.period = next.ClientOrders, next.ClientWindow
+		l.account.limit, l.account.period = next.AccountOrders, next.AccountWindow
+		l.domains.limit, l.domains.period = next.DomainCertificates, next.DomainWindow
+		l.failures.limit, l.failures.period = next.FailedValidations, next.FailedWindow
+	}
+	return
+}
+
+// registeredDomain returns the domain under the public suffix, such as foo.com of *.www.foo.com.
+func registeredDomain(domain string) string {
+	domain = strings.TrimPrefix(domain, "*.")
+	registered, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return domain
+	}
+	return registered
+}
+
+// identity is the key of the client in rate limits, it is the common name of the client cert,
+// or the remote ip when the cert has no common name.
+func (who requester) identity() string {
+	if who.client != "" {
+		return who.client
+	}
+	host, _, err := net.SplitHostPort(who.remoteAddr)
+	if err != nil {
+		return who.remoteAddr
+	}
+	return host
+}
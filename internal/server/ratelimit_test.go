@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindow_retryAfter(t *testing.T) {
+	beg := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	w := newWindow(2, time.Hour)
+	w.add("a", beg)
+	w.add("a", beg.Add(10*time.Minute))
+	cases := []struct {
+		at   time.Duration
+		want time.Duration
+	}{
+		// the oldest of the last limit events leaves the window at beg + period
+		{20 * time.Minute, 40 * time.Minute},
+		{59*time.Minute + 59*time.Second, time.Second},
+		{time.Hour, 0},
+		// the second one leaves at beg + 10m + period, only one event is left before it
+		{time.Hour + 5*time.Minute, 0},
+		{2 * time.Hour, 0},
+	}
+	for _, c := range cases {
+		if got := w.retryAfter("a", beg.Add(c.at)); got != c.want {
+			t.Errorf("retry after at %s is %s, want %s", c.at, got, c.want)
+		}
+	}
+	if _, has := w.events["a"]; has {
+		t.Fatal("key whose events all passed is kept")
+	}
+	if got := w.retryAfter("b", beg); got != 0 {
+		t.Fatalf("unknown key must wait %s", got)
+	}
+	unlimited := newWindow(0, time.Hour)
+	unlimited.add("a", beg)
+	if got := unlimited.retryAfter("a", beg); got != 0 || len(unlimited.events) != 0 {
+		t.Fatal("window without limit counts events")
+	}
+}
+
+func TestLimiter_reserve(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := createLimiter(RateLimitConfig{ClientOrders: 2, ClientWindow: time.Hour, FailedValidations: 1, FailedWindow: time.Hour})
+	l.now = func() time.Time { return now }
+
+	if err := l.reserve("a", "default", "www.foo.com"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if err := l.reserve("a", "default", "www.foo.com"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(30 * time.Minute)
+	err := l.reserve("a", "default", "www.foo.com")
+	limited := &Error{}
+	if !errors.As(err, &limited) || limited.Code != ErrorRateLimited {
+		t.Fatalf("third order is not rate limited, %v", err)
+	}
+	// 29m59.5s is left, Retry-After rounds it up to whole seconds
+	if limited.RetryAfter != 30*60 {
+		t.Fatalf("retry after is %d", limited.RetryAfter)
+	}
+	if err = l.reserve("b", "default", "www.foo.com"); err != nil {
+		t.Fatalf("other client is limited, %v", err)
+	}
+
+	// only failed challenges count toward failed validations
+	l.done("api.foo.com", newError(ErrorPreflightFailed, "caa"))
+	l.done("api.foo.com", fmt.Errorf("timeout"))
+	if err = l.reserve("c", "default", "api.foo.com"); err != nil {
+		t.Fatalf("failure which is not a challenge counts, %v", err)
+	}
+	l.done("api.foo.com", newError(ErrorChallengeFailed, "unauthorized"))
+	if err = l.reserve("c", "default", "api.foo.com"); !errors.As(err, &limited) {
+		t.Fatalf("failed challenge does not count, %v", err)
+	}
+
+	// keys whose events all passed are swept by a later reserve of another key
+	now = now.Add(2 * time.Hour)
+	if err = l.reserve("d", "default", "db.foo.com"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if _, has := l.clients.events[key]; has {
+			t.Errorf("client %s is not swept", key)
+		}
+	}
+	if _, has := l.failures.events["api.foo.com"]; has {
+		t.Error("failures of api.foo.com are not swept")
+	}
+	if len(l.clients.events) != 1 {
+		t.Fatalf("clients are %v", l.clients.events)
+	}
+}
+
+func TestLimiter_reserveDisabledLimit(t *testing.T) {
+	l := createLimiter(RateLimitConfig{ClientOrders: -1, AccountOrders: 1})
+	if err := l.reserve("a", "default", "www.foo.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reserve("a", "other", "www.foo.com"); err != nil {
+		t.Fatalf("disabled client limit took the default, %v", err)
+	}
+	if err := l.reserve("b", "default", "www.foo.com"); err == nil {
+		t.Fatal("other limits are disabled too")
+	}
+	if len(l.clients.events) != 0 {
+		t.Fatalf("orders are counted by a disabled limit, %v", l.clients.events)
+	}
+}
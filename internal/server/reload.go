@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/aacfactory/logs"
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// reloadHook prepares the part of config it owns, and returns apply to switch to it.
+// Apply must not fail, so that a reload is either applied by all hooks or by none.
+type reloadHook func(config *Config) (apply func(), err error)
+
+// Reloader reloads config on SIGHUP or when the config file, ca or ca key file changes.
+type Reloader struct {
+	log     logs.Logger
+	load    func() (config *Config, err error)
+	mutex   sync.Mutex
+	current *Config
+	hooks   []reloadHook
+}
+
+func createReloader(log logs.Logger, config *Config, load func() (config *Config, err error)) *Reloader {
+	return &Reloader{
+		log:     log,
+		load:    load,
+		mutex:   sync.Mutex{},
+		current: config,
+		hooks:   make([]reloadHook, 0, 4),
+	}
+}
+
+func (r *Reloader) register(hook reloadHook) {
+	r.mutex.Lock()
+	r.hooks = append(r.hooks, hook)
+	r.mutex.Unlock()
+}
+
+func (r *Reloader) Reload() (err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	config, loadErr := r.load()
+	if loadErr != nil {
+		err = fmt.Errorf("acmes: reload failed, %v", loadErr)
+		return
+	}
+	applies := make([]func(), 0, len(r.hooks))
+	for _, hook := range r.hooks {
+		apply, hookErr := hook(config)
+		if hookErr != nil {
+			err = fmt.Errorf("acmes: reload failed, %v", hookErr)
+			return
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	for _, name := range restartRequired(r.current, config) {
+		r.log.Warn().Message(fmt.Sprintf("acmes: %s was changed, it takes effect after restart", name))
+	}
+	r.current = config
+	return
+}
+
+// restartRequired returns sections which are changed but can not be reloaded.
+func restartRequired(prev *Config, next *Config) (names []string) {
+	names = make([]string, 0, 1)
+	if prev.Port != next.Port {
+		names = append(names, "port")
+	}
+	if prev.Store != next.Store {
+		names = append(names, "store")
+	}
+	if !reflect.DeepEqual(prev.ACME, next.ACME) {
+		names = append(names, "acme")
+	}
+	if !reflect.DeepEqual(prev.DNS, next.DNS) {
+		names = append(names, "dns")
+	}
+	if !reflect.DeepEqual(prev.Preflight, next.Preflight) {
+		names = append(names, "preflight")
+	}
+	if prev.Metrics != next.Metrics {
+		names = append(names, "metrics")
+	}
+	if prev.GRPC != next.GRPC {
+		names = append(names, "grpc")
+	}
+	if prev.Probes != next.Probes {
+		names = append(names, "probes")
+	}
+	if prev.Notify.Warning != next.Notify.Warning {
+		names = append(names, "notify.warning")
+	}
+	if prev.Audit != next.Audit {
+		names = append(names, "audit")
+	}
+	if prev.Tracing != next.Tracing {
+		names = append(names, "tracing")
+	}
+	return
+}
+
+// Config returns the config which is applied now.
+func (r *Reloader) Config() *Config {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.current
+}
+
+// watchedFiles are the files of config which trigger a reload, the config file at path is one of them when path is not empty.
+func watchedFiles(config *Config, path string) []string {
+	files := []string{config.TLS.CA, config.TLS.Key}
+	if path != "" {
+		files = append(files, path)
+	}
+	return files
+}
+
+// watch returns a job which reloads on SIGHUP and when one of files changes, changes within a second are merged into one reload.
+// Files are taken from the config again after each reload, so that moved ca or key files are watched at their new paths.
+func (r *Reloader) watch(path string) (job func(ctx context.Context), err error) {
+	watcher, watcherErr := fsnotify.NewWatcher()
+	if watcherErr != nil {
+		err = fmt.Errorf("acmes: watch files failed, %v", watcherErr)
+		return
+	}
+	files, watched, followErr := follow(watcher, watchedFiles(r.Config(), path), nil)
+	if followErr != nil {
+		_ = watcher.Close()
+		err = followErr
+		return
+	}
+	job = func(ctx context.Context) {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP)
+		defer signal.Stop(signals)
+		defer watcher.Close()
+		var debounce <-chan time.Time
+		reload := func(reason string) {
+			if !r.reload(reason) {
+				return
+			}
+			nextFiles, nextWatched, nextErr := follow(watcher, watchedFiles(r.Config(), path), watched)
+			if nextErr != nil {
+				r.log.Warn().Cause(nextErr).Message("acmes: watch files failed")
+				return
+			}
+			files, watched = nextFiles, nextWatched
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				reload("SIGHUP")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				for _, file := range files {
+					if filepath.Clean(event.Name) == file || filepath.Base(event.Name) == "..data" {
+						debounce = time.After(time.Second)
+						break
+					}
+				}
+			case <-debounce:
+				debounce = nil
+				reload("file change")
+			case watchErr, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				r.log.Warn().Cause(watchErr).Message("acmes: watch files failed")
+			}
+		}
+	}
+	return
+}
+
+// follow watches dirs of files, because editors and kubernetes replace files rather than write them.
+// Dirs are added again even when they are in watched, so that a dir which was replaced is watched again,
+// and dirs of watched which are not used anymore are removed.
+func follow(watcher *fsnotify.Watcher, files []string, watched map[string]struct{}) (abs []string, dirs map[string]struct{}, err error) {
+	abs = make([]string, 0, len(files))
+	dirs = make(map[string]struct{}, len(files))
+	for _, file := range files {
+		absFile, absErr := filepath.Abs(file)
+		if absErr != nil {
+			err = fmt.Errorf("acmes: watch %s failed, %v", file, absErr)
+			return
+		}
+		abs = append(abs, absFile)
+		dir := filepath.Dir(absFile)
+		if _, has := dirs[dir]; has {
+			continue
+		}
+		if _, has := watched[dir]; has {
+			// add of a watched dir keeps the watch of the replaced one in fsnotify, which drops events of the new one
+			_ = watcher.Remove(dir)
+		}
+		addErr := watcher.Add(dir)
+		if addErr != nil {
+			err = fmt.Errorf("acmes: watch %s failed, %v", dir, addErr)
+			return
+		}
+		dirs[dir] = struct{}{}
+	}
+	for dir := range watched {
+		if _, has := dirs[dir]; !has {
+			_ = watcher.Remove(dir)
+		}
+	}
+	return
+}
+
+// reload reloads config and logs the result, ok is false when it failed.
+func (r *Reloader) reload(reason string) (ok bool) {
+	err := r.Reload()
+	if err != nil {
+		r.log.Error().Cause(err).Message(fmt.Sprintf("acmes: reload by %s failed", reason))
+		return
+	}
+	r.log.Info().Message(fmt.Sprintf("acmes: reloaded by %s", reason))
+	ok = true
+	return
+}
+
+// reloadableTLS serves the current tls config by GetConfigForClient, so new connections use the new server cert at once.
+type reloadableTLS struct {
+	current atomic.Pointer[tls.Config]
+}
+
+func newReloadableTLS(config *tls.Config) *reloadableTLS {
+	r := &reloadableTLS{}
+	r.current.Store(config)
+	return r
+}
+
+// Config returns a config which serves the current one, protos are the alpn protocols, such as h2 for grpc.
+func (r *reloadableTLS) Config(protos ...string) *tls.Config {
+	return &tls.Config{
+		NextProtos: protos,
+		GetConfigForClient: func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+			current := r.current.Load()
+			if len(protos) == 0 {
+				return current, nil
+			}
+			config := current.Clone()
+			config.NextProtos = protos
+			return config, nil
+		},
+	}
+}
+
+func (r *reloadableTLS) hook(config *Config) (apply func(), err error) {
+	next, createErr := createTLSConfig(config.TLS.CA, config.TLS.Key)
+	if createErr != nil {
+		err = createErr
+		return
+	}
+	apply = func() {
+		r.current.Store(next)
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFollow(t *testing.T) {
+	watcher, watcherErr := fsnotify.NewWatcher()
+	if watcherErr != nil {
+		t.Fatal(watcherErr)
+	}
+	defer watcher.Close()
+	changed := make(chan string, 16)
+	go func() {
+		for event := range watcher.Events {
+			changed <- event.Name
+		}
+	}()
+	root := t.TempDir()
+	tlsDir, movedDir := filepath.Join(root, "tls"), filepath.Join(root, "moved")
+	for _, dir := range []string{tlsDir, movedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ca := filepath.Join(tlsDir, "ca.pem")
+	_, watched, err := follow(watcher, []string{ca, filepath.Join(tlsDir, "ca.key")}, nil)
+	if err != nil || len(watched) != 1 {
+		t.Fatalf("watched are %v, %v", watched, err)
+	}
+
+	// the dir is replaced, it is watched again after the next reload
+	if err = os.RemoveAll(tlsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.MkdirAll(tlsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, watched, err = follow(watcher, []string{ca}, watched); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(ca, []byte("ca"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// events of the removal may come first
+	timeout := time.After(2 * time.Second)
+	for name := ""; name != ca; {
+		select {
+		case name = <-changed:
+		case <-timeout:
+			t.Fatal("change of the replaced dir is not watched")
+		}
+	}
+
+	// the ca is moved, the dir which is not used anymore is not watched
+	moved := filepath.Join(movedDir, "ca.pem")
+	files, watched, err := follow(watcher, []string{moved}, watched)
+	if err != nil || len(files) != 1 || files[0] != moved {
+		t.Fatalf("files are %v, %v", files, err)
+	}
+	if list := watcher.WatchList(); len(list) != 1 || list[0] != movedDir {
+		t.Fatalf("watch list is %v", list)
+	}
+}
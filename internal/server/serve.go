@@ -1,67 +1,241 @@
 package server
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
+	"github.com/aacfactory/acmes/internal/audit"
+	"github.com/aacfactory/acmes/internal/notify"
+	"github.com/aacfactory/acmes/internal/preflight"
+	"github.com/aacfactory/acmes/internal/responder"
+	"github.com/aacfactory/acmes/internal/store"
+	"github.com/aacfactory/logs"
 	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc"
 	slog "log"
 	"net/http"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-type options struct {
-	port         int
-	ca           string
-	key          string
-	level        string
-	logFormatter string
-	store        string
-	email        string
-	provider     string
-}
-
-func serve(opt options) (err error) {
-	port := opt.port
+// serve runs acmes, path is the config file which may be empty, load reads config again when reloading.
+func serve(config *Config, path string, load func() (config *Config, err error)) (err error) {
+	port := config.Port
 	if port < 1 {
 		port = 443
 	}
-	log, logErr := createLog(opt.level, opt.logFormatter)
+	rawLog, logErr := createLog(config.Log.Level, config.Log.Formatter)
 	if logErr != nil {
 		err = fmt.Errorf("acmes: serve failed, %v", logErr)
 		return
 	}
-	tlsConfig, tlsErr := createTLSConfig(opt.ca, opt.key)
+	log := newSwappableLogger(rawLog)
+	useLog(log)
+	reloader := createReloader(log, config, load)
+	reloader.register(func(config *Config) (apply func(), err error) {
+		next, createErr := createLog(config.Log.Level, config.Log.Formatter)
+		if createErr != nil {
+			err = createErr
+			return
+		}
+		apply = func() {
+			log.swap(next)
+		}
+		return
+	})
+	shutdownTracing, tracingErr := createTracing(config.Tracing.Endpoint)
+	if tracingErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", tracingErr)
+		return
+	}
+	defer func() {
+		_ = shutdownTracing(context.TODO())
+	}()
+	serverTLSConfig, tlsErr := createTLSConfig(config.TLS.CA, config.TLS.Key)
 	if tlsErr != nil {
 		err = fmt.Errorf("acmes: serve failed, %v", tlsErr)
 		return
 	}
-	stores, storeErr := createStore(opt.store)
+	tlsConfig := newReloadableTLS(serverTLSConfig)
+	reloader.register(tlsConfig.hook)
+	stores, storeErr := store.New(config.Store)
 	if storeErr != nil {
 		err = fmt.Errorf("acmes: serve failed, %v", storeErr)
 		return
 	}
+	// account commands which change keys fail while serve holds the store
+	unlockStore, lockErr := stores.Lock(context.TODO(), true)
+	if lockErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", lockErr)
+		return
+	}
+	defer unlockStore()
+	metrics := createMetrics()
+	stores = instrumentStore(stores, metrics)
+	accountConfigs := config.ACME.accounts()
+	emails := make([]string, 0, len(accountConfigs))
+	for _, account := range accountConfigs {
+		emails = append(emails, account.Email)
+	}
+	metrics.watchCertificates(emails, stores)
 
-	client, clientErr := createAcme(opt.email, opt.provider, stores)
-	if clientErr != nil {
-		err = fmt.Errorf("acmes: serve failed, %v", clientErr)
+	envErr := config.DNS.setEnv()
+	if envErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", envErr)
+		return
+	}
+	// stopping is set when shutdown begins, which stops the dns responder itself
+	stopping := false
+	var embedded *responder.Responder
+	if config.DNS.Embedded.Port > 0 {
+		embedded = responder.New(responder.Options{
+			Zones:      config.DNS.Embedded.Zones,
+			Nameserver: config.DNS.Embedded.Nameserver,
+			TTL:        uint32(config.DNS.ttlOf(responder.Name)),
+		})
+		err = embedded.Listen(fmt.Sprintf(":%d", config.DNS.Embedded.Port))
+		if err != nil {
+			err = fmt.Errorf("acmes: serve failed, %v", err)
+			return
+		}
+		defer func() {
+			if err == nil || stopping {
+				return
+			}
+			shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer shutdownCancel()
+			_ = embedded.Shutdown(shutdownCtx)
+		}()
+		if log.DebugEnabled() {
+			log.Debug().Message(fmt.Sprintf("serve dns at :%d", config.DNS.Embedded.Port))
+		}
+	}
+	challengeProvider, aliases, providerErr := createChallengeProvider(config.DNS, stores, embedded)
+	if providerErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", providerErr)
+		return
+	}
+	provider, trackedProvider := trackProvider(challengeProvider, metrics, config.DNS.Propagation)
+	accounts, accountsErr := createAccounts(accountConfigs, trackedProvider, config.DNS, stores)
+	if accountsErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", accountsErr)
 		return
 	}
 
-	ln, lnErr := tls.Listen("tcp", fmt.Sprintf(":%d", port), tlsConfig)
+	notifiers := make([]notify.Notifier, 0, len(config.Notify.Notifiers))
+	for _, raw := range config.Notify.Notifiers {
+		notifier, notifierErr := notify.New(raw)
+		if notifierErr != nil {
+			err = fmt.Errorf("acmes: serve failed, %v", notifierErr)
+			return
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	dispatcher := notify.NewDispatcher(log, notifiers, config.Notify.Cooldown)
+	reloader.register(func(config *Config) (apply func(), err error) {
+		next := make([]notify.Notifier, 0, len(config.Notify.Notifiers))
+		for _, raw := range config.Notify.Notifiers {
+			notifier, notifierErr := notify.New(raw)
+			if notifierErr != nil {
+				err = notifierErr
+				return
+			}
+			next = append(next, notifier)
+		}
+		apply = func() {
+			dispatcher.Reset(next, config.Notify.Cooldown)
+		}
+		return
+	})
+
+	var audits audit.Log
+	if config.Audit != "" {
+		audits, err = audit.New(config.Audit, stores)
+		if err != nil {
+			err = fmt.Errorf("acmes: serve failed, %v", err)
+			return
+		}
+	}
+
+	policy, policyErr := createDomainPolicy(config.Domains)
+	if policyErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", policyErr)
+		return
+	}
+
+	var checker *preflight.Checker
+	if !config.Preflight.Disabled {
+		checker, err = preflight.New(preflight.Options{
+			Resolvers:     config.DNS.Resolvers,
+			CAAIdentities: config.Preflight.CAAIdentities,
+			Provider:      config.DNS.Provider,
+			Nameservers:   config.Preflight.Nameservers,
+			Alias: func(domain string) (name string, provider string) {
+				name, provider, _ = aliases.resolve(domain)
+				return
+			},
+		})
+		if err != nil {
+			err = fmt.Errorf("acmes: serve failed, %v", err)
+			return
+		}
+	}
+
+	limits := createLimiter(config.RateLimit)
+	reloader.register(limits.hook)
+
+	probes := createProbes(accountConfigs, stores)
+
+	plains := plainServers{}
+	plains.handle(config.Metrics.Port, "/metrics", metrics.Handler())
+	probesHandler := probes.Handler()
+	plains.handle(config.Probes.Port, "/healthz", probesHandler)
+	plains.handle(config.Probes.Port, "/readyz", probesHandler)
+	plainSrvs, plainsErr := plains.serve(log, probes)
+	if plainsErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", plainsErr)
+		return
+	}
+
+	warning := config.Notify.Warning
+	if warning <= 0 {
+		warning = 14 * 24 * time.Hour
+	}
+	jobs := newBackground()
+	jobs.run(probes.job("expiry"), func(ctx context.Context) {
+		watchExpiry(ctx, log, emails, stores, dispatcher, warning, time.Hour)
+	})
+
+	reloadJob, watchErr := reloader.watch(path)
+	if watchErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", watchErr)
+		return
+	}
+	jobs.run(probes.job("reloader"), reloadJob)
+
+	ln, lnErr := tls.Listen("tcp", fmt.Sprintf(":%d", port), tlsConfig.Config())
 	if lnErr != nil {
 		err = fmt.Errorf("acmes: serve failed, %v", lnErr)
 		return
 	}
-	srv := http.Server{
-		Addr: fmt.Sprintf(":%d", port),
-		Handler: &Handler{
-			log:     log,
-			email:   strings.TrimSpace(opt.email),
-			acme:    client,
-			stores:  stores,
-			barrier: &singleflight.Group{},
-		},
-		TLSConfig: tlsConfig,
+	handler := &Handler{
+		log:             log,
+		accounts:        accounts,
+		stores:          stores,
+		barrier:         &singleflight.Group{},
+		metrics:         metrics,
+		notifier:        dispatcher,
+		audits:          audits,
+		events:          newEvents(),
+		limits:          limits,
+		checker:         checker,
+		renewMustStaple: !config.ACME.DisableRenewMustStaple,
+	}
+	srv := &http.Server{
+		Addr:      fmt.Sprintf(":%d", port),
+		Handler:   handler,
+		TLSConfig: tlsConfig.Config(),
 		ErrorLog: slog.New(
 			&writer{
 				core: log,
@@ -70,13 +244,133 @@ func serve(opt options) (err error) {
 			slog.LstdFlags,
 		),
 	}
+	handler.policy.Store(policy)
+	reloader.register(func(config *Config) (apply func(), err error) {
+		next, createErr := createDomainPolicy(config.Domains)
+		if createErr != nil {
+			err = createErr
+			return
+		}
+		apply = func() {
+			handler.policy.Store(next)
+		}
+		return
+	})
+	srv.RegisterOnShutdown(handler.events.Close)
+	var grpcSrv *grpc.Server
+	if config.GRPC.Port > 0 {
+		grpcSrv, err = serveGRPC(log, config.GRPC.Port, tlsConfig.Config("h2"), handler, probes.job("grpc"))
+		if err != nil {
+			err = fmt.Errorf("acmes: serve failed, %v", err)
+			return
+		}
+	}
+	resumeErr := handler.resumeJobs(context.TODO())
+	if resumeErr != nil {
+		err = fmt.Errorf("acmes: serve failed, %v", resumeErr)
+		return
+	}
+	jobs.run(probes.job("jobs"), func(ctx context.Context) {
+		handler.watchJobs(ctx, jobRetention, jobSweepInterval)
+	})
+	jobs.run(probes.job("ocsp"), func(ctx context.Context) {
+		watchOCSP(ctx, log, emails, stores, handler.events, time.Hour)
+	})
 	if log.DebugEnabled() {
 		log.Debug().Message(fmt.Sprintf("serve at :%d", port))
 	}
-	err = srv.Serve(ln)
-	if err != nil {
-		err = fmt.Errorf("acmes: serve failed, %v", err)
+	listening := probes.job("listener")
+	listening.Store(true)
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	select {
+	case serveErr := <-served:
+		listening.Store(false)
+		err = fmt.Errorf("acmes: serve failed, %v", serveErr)
 		return
+	case <-signals.Done():
+	}
+	log.Info().Message("acmes: shutting down")
+	timeout := reloader.Config().Shutdown.Timeout
+	if timeout <= 0 {
+		timeout = 60 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stopping = true
+	err = shutdown(ctx, log, srv, grpcSrv, plainSrvs, handler, jobs, dispatcher, provider, embedded, stores)
+	listening.Store(false)
+	return
+}
+
+type shutdownStep struct {
+	name string
+	fn   func() error
+}
+
+// shutdown stops accepting requests, waits for in-flight obtain and renew and background jobs,
+// removes dns challenge records which are left behind when no order is in flight anymore, then closes the store.
+// Plain http servers are stopped last, so that probes and metrics are served while draining.
+// Each step is run even if a former one failed, the first failure is returned.
+func shutdown(ctx context.Context, log logs.Logger, srv *http.Server, grpcSrv *grpc.Server, plainSrvs []*http.Server, handler *Handler,
+	jobs *background, dispatcher *notify.Dispatcher, provider *trackedProvider, embedded *responder.Responder, stores store.Store) (err error) {
+	// drained is false when orders are still in flight after the timeout, their records are in use and left to them
+	drained := false
+	steps := []shutdownStep{
+		{"stop listener", func() error { return srv.Shutdown(ctx) }},
+		{"stop grpc", func() error {
+			if grpcSrv == nil {
+				return nil
+			}
+			handler.events.Close()
+			return stopGRPC(ctx, grpcSrv)
+		}},
+		{"wait for in-flight orders", func() error {
+			waitErr := handler.wait(ctx)
+			drained = waitErr == nil
+			return waitErr
+		}},
+		{"stop background jobs", func() error { return jobs.stop(ctx) }},
+		{"send notifications", func() error { return dispatcher.Close(ctx) }},
+		{"clean up dns challenges", func() error {
+			if !drained {
+				log.Warn().Message("acmes: shutdown, dns challenges of in-flight orders are not cleaned up")
+				return nil
+			}
+			return provider.cleanUpPending()
+		}},
+		{"stop dns responder", func() error {
+			if embedded == nil {
+				return nil
+			}
+			return embedded.Shutdown(ctx)
+		}},
+		{"close store", func() error { return stores.Close(ctx) }},
+	}
+	for _, plainSrv := range plainSrvs {
+		steps = append(steps, shutdownStep{fmt.Sprintf("stop plain http at %s", plainSrv.Addr), func(plainSrv *http.Server) func() error {
+			return func() error { return plainSrv.Shutdown(ctx) }
+		}(plainSrv)})
+	}
+	for _, step := range steps {
+		stepErr := step.fn()
+		if stepErr == nil {
+			continue
+		}
+		log.Error().Cause(stepErr).Message(fmt.Sprintf("acmes: shutdown, %s failed", step.name))
+		if err == nil {
+			err = fmt.Errorf("acmes: shutdown failed, %s failed, %v", step.name, stepErr)
+		}
+	}
+	if err == nil {
+		log.Info().Message("acmes: shutdown succeed")
 	}
+	logCtx, logCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer logCancel()
+	_ = log.Shutdown(logCtx)
 	return
 }
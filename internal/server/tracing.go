@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/url"
+	"strings"
+)
+
+const tracerName = "github.com/aacfactory/acmes"
+
+// createTracing exports spans to the otlp http endpoint, such as http://127.0.0.1:4318.
+// Spans are not recorded when endpoint is empty.
+func createTracing(endpoint string) (shutdown func(ctx context.Context) error, err error) {
+	shutdown = func(ctx context.Context) error {
+		return nil
+	}
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return
+	}
+	u, parseErr := url.Parse(endpoint)
+	if parseErr != nil || u.Host == "" {
+		err = fmt.Errorf("acmes: create tracing failed for endpoint %s is invalid", endpoint)
+		return
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, exporterErr := otlptracehttp.New(context.TODO(), opts...)
+	if exporterErr != nil {
+		err = fmt.Errorf("acmes: create tracing failed, %v", exporterErr)
+		return
+	}
+	res, resErr := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName("acmes"),
+	))
+	if resErr != nil {
+		err = fmt.Errorf("acmes: create tracing failed, %v", resErr)
+		return
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	shutdown = provider.Shutdown
+	return
+}
+
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attributes...))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -3,8 +3,11 @@ package store
 import (
 	"context"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
+	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
@@ -46,13 +49,14 @@ type FileStore struct {
 
 func (fs *FileStore) GetUser(_ context.Context, email string) (user *User, has bool, err error) {
 	fs.mutex.Lock()
-	fs.mutex.Unlock()
+	defer fs.mutex.Unlock()
 	email = strings.TrimSpace(email)
-	if email == "" {
-		err = fmt.Errorf("acmes: get user failed for email is empty")
+	emailDir, keyErr := emailKey(email)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: get user failed, %v", keyErr)
 		return
 	}
-	userDir := filepath.Join(fs.rootDir, email)
+	userDir := filepath.Join(fs.rootDir, emailDir)
 	if !fs.pathExist(userDir) {
 		return
 	}
@@ -79,15 +83,39 @@ func (fs *FileStore) GetUser(_ context.Context, email string) (user *User, has b
 		Resource: resource,
 		Key:      key,
 	}
+	directoryPath := filepath.Join(userDir, "directory.txt")
+	if fs.pathExist(directoryPath) {
+		directory, readDirectoryErr := os.ReadFile(directoryPath)
+		if readDirectoryErr != nil {
+			user = nil
+			err = fmt.Errorf("acmes: get user failed, %v", readDirectoryErr)
+			return
+		}
+		user.Directory = strings.TrimSpace(string(directory))
+	}
+	pendingKeyPath := filepath.Join(userDir, "pending_key.pem")
+	if fs.pathExist(pendingKeyPath) {
+		pendingKey, readPendingKeyErr := os.ReadFile(pendingKeyPath)
+		if readPendingKeyErr != nil {
+			user = nil
+			err = fmt.Errorf("acmes: get user failed, %v", readPendingKeyErr)
+			return
+		}
+		user.PendingKey = pendingKey
+	}
 	has = true
 	return
 }
 
 func (fs *FileStore) SaveUser(_ context.Context, user *User) (err error) {
 	fs.mutex.Lock()
-	fs.mutex.Unlock()
-	email := user.Email
-	userDir := filepath.Join(fs.rootDir, email)
+	defer fs.mutex.Unlock()
+	emailDir, keyErr := emailKey(user.Email)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: save user failed, %v", keyErr)
+		return
+	}
+	userDir := filepath.Join(fs.rootDir, emailDir)
 	if !fs.pathExist(userDir) {
 		mkdirErr := os.MkdirAll(userDir, 0600)
 		if mkdirErr != nil {
@@ -107,21 +135,37 @@ func (fs *FileStore) SaveUser(_ context.Context, user *User) (err error) {
 		err = fmt.Errorf("acmes: save user failed, %v", saveKeyErr)
 		return
 	}
+	if user.Directory != "" {
+		saveDirectoryErr := os.WriteFile(filepath.Join(userDir, "directory.txt"), []byte(user.Directory), 0600)
+		if saveDirectoryErr != nil {
+			err = fmt.Errorf("acmes: save user failed, %v", saveDirectoryErr)
+			return
+		}
+	}
+	pendingKeyPath := filepath.Join(userDir, "pending_key.pem")
+	if len(user.PendingKey) == 0 {
+		if removeErr := os.Remove(pendingKeyPath); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = fmt.Errorf("acmes: save user failed, %v", removeErr)
+			return
+		}
+		return
+	}
+	savePendingKeyErr := os.WriteFile(pendingKeyPath, user.PendingKey, 0600)
+	if savePendingKeyErr != nil {
+		err = fmt.Errorf("acmes: save user failed, %v", savePendingKeyErr)
+		return
+	}
 	return
 }
 
 func (fs *FileStore) GetUserCertificate(_ context.Context, email string, domain string) (cert *Certificate, has bool, err error) {
 	fs.mutex.Lock()
-	fs.mutex.Unlock()
-	email = strings.TrimSpace(email)
-	domain = strings.TrimSpace(domain)
-	if domain == "" {
+	defer fs.mutex.Unlock()
+	domainDir, keyErr := fs.domainDir(email, domain)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: get user certificate failed, %v", keyErr)
 		return
 	}
-	if strings.Contains(domain, "*") {
-		domain = strings.ReplaceAll(domain, "*", "[x]")
-	}
-	domainDir := filepath.Join(fs.rootDir, email, domain)
 	if !fs.pathExist(domainDir) {
 		return
 	}
@@ -166,11 +210,27 @@ func (fs *FileStore) GetUserCertificate(_ context.Context, email string, domain
 		err = fmt.Errorf("acmes: get user certificate failed, %v", notAfterErr)
 		return
 	}
-	cert = &Certificate{
-		Resource: res,
-		Cert:     certPem,
-		Key:      keyPem,
-		NotAfter: notAfter,
+	cert = NewCertificate(res, certPem, keyPem, notAfter)
+	ocspPath := filepath.Join(domainDir, "ocsp.der")
+	ocspNextUpdatePath := filepath.Join(domainDir, "ocsp_next_update.txt")
+	if fs.pathExist(ocspPath) && fs.pathExist(ocspNextUpdatePath) {
+		ocsp, ocspReadErr := os.ReadFile(ocspPath)
+		if ocspReadErr != nil {
+			err = fmt.Errorf("acmes: get user certificate failed, %v", ocspReadErr)
+			return
+		}
+		nextUpdateContent, nextUpdateReadErr := os.ReadFile(ocspNextUpdatePath)
+		if nextUpdateReadErr != nil {
+			err = fmt.Errorf("acmes: get user certificate failed, %v", nextUpdateReadErr)
+			return
+		}
+		nextUpdate, nextUpdateErr := time.Parse(time.RFC3339, strings.TrimSpace(string(nextUpdateContent)))
+		if nextUpdateErr != nil {
+			err = fmt.Errorf("acmes: get user certificate failed, %v", nextUpdateErr)
+			return
+		}
+		cert.OCSP = ocsp
+		cert.OCSPNextUpdate = nextUpdate
 	}
 	has = true
 	return
@@ -178,16 +238,12 @@ func (fs *FileStore) GetUserCertificate(_ context.Context, email string, domain
 
 func (fs *FileStore) SaveUserCertificate(_ context.Context, email string, domain string, cert *Certificate) (err error) {
 	fs.mutex.Lock()
-	fs.mutex.Unlock()
-	email = strings.TrimSpace(email)
-	domain = strings.TrimSpace(domain)
-	if domain == "" {
+	defer fs.mutex.Unlock()
+	domainDir, keyErr := fs.domainDir(email, domain)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: save user certificate failed, %v", keyErr)
 		return
 	}
-	if strings.Contains(domain, "*") {
-		domain = strings.ReplaceAll(domain, "*", "[x]")
-	}
-	domainDir := filepath.Join(fs.rootDir, email, domain)
 	if !fs.pathExist(domainDir) {
 		mkdirErr := os.MkdirAll(domainDir, 0600)
 		if mkdirErr != nil {
@@ -195,6 +251,13 @@ func (fs *FileStore) SaveUserCertificate(_ context.Context, email string, domain
 			return
 		}
 	}
+	// the ocsp of the previous certificate does not staple the new one
+	for _, name := range []string{"ocsp.der", "ocsp_next_update.txt"} {
+		if removeErr := os.Remove(filepath.Join(domainDir, name)); removeErr != nil && !os.IsNotExist(removeErr) {
+			err = fmt.Errorf("acmes: save user certificate failed, %v", removeErr)
+			return
+		}
+	}
 	certPath := filepath.Join(domainDir, "cert.pem")
 	saveCertErr := os.WriteFile(certPath, cert.Cert, 0600)
 	if saveCertErr != nil {
@@ -229,6 +292,400 @@ func (fs *FileStore) SaveUserCertificate(_ context.Context, email string, domain
 	return
 }
 
+// SaveUserCertificateOCSP keeps the der ocsp response beside the certificate of domain,
+// it is refused when the stored certificate is no longer the one whose leaf has serial.
+func (fs *FileStore) SaveUserCertificateOCSP(_ context.Context, email string, domain string, serial *big.Int, response []byte, nextUpdate time.Time) (err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	domainDir, keyErr := fs.domainDir(email, domain)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, %v", keyErr)
+		return
+	}
+	certPath := filepath.Join(domainDir, "cert.pem")
+	if !fs.pathExist(certPath) {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, certificate of %s was not found", domain)
+		return
+	}
+	certPem, certReadErr := os.ReadFile(certPath)
+	if certReadErr != nil {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, %v", certReadErr)
+		return
+	}
+	block, _ := pem.Decode(certPem)
+	if block == nil {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, certificate of %s is not pem", domain)
+		return
+	}
+	stored, parseErr := x509.ParseCertificate(block.Bytes)
+	if parseErr != nil {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, %v", parseErr)
+		return
+	}
+	if serial == nil || stored.SerialNumber.Cmp(serial) != 0 {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, certificate of %s was replaced", domain)
+		return
+	}
+	saveOCSPErr := os.WriteFile(filepath.Join(domainDir, "ocsp.der"), response, 0600)
+	if saveOCSPErr != nil {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, %v", saveOCSPErr)
+		return
+	}
+	saveNextUpdateErr := os.WriteFile(filepath.Join(domainDir, "ocsp_next_update.txt"), []byte(nextUpdate.Format(time.RFC3339)), 0600)
+	if saveNextUpdateErr != nil {
+		err = fmt.Errorf("acmes: save user certificate ocsp failed, %v", saveNextUpdateErr)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) RemoveUserCertificate(_ context.Context, email string, domain string) (err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	domainDir, keyErr := fs.domainDir(email, domain)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: remove user certificate failed, %v", keyErr)
+		return
+	}
+	if !fs.pathExist(domainDir) {
+		return
+	}
+	removeErr := os.RemoveAll(domainDir)
+	if removeErr != nil {
+		err = fmt.Errorf("acmes: remove user certificate failed, %v", removeErr)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) ListUserCertificates(_ context.Context, email string) (domains []string, err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	emailDir, keyErr := emailKey(email)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: list user certificates failed, %v", keyErr)
+		return
+	}
+	userDir := filepath.Join(fs.rootDir, emailDir)
+	if !fs.pathExist(userDir) {
+		return
+	}
+	entries, readDirErr := os.ReadDir(userDir)
+	if readDirErr != nil {
+		err = fmt.Errorf("acmes: list user certificates failed, %v", readDirErr)
+		return
+	}
+	domains = make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if !fs.pathExist(filepath.Join(userDir, entry.Name(), "cert.pem")) {
+			continue
+		}
+		domains = append(domains, strings.ReplaceAll(entry.Name(), "[x]", "*"))
+	}
+	return
+}
+
+func (fs *FileStore) AppendAudit(_ context.Context, line []byte) (err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	file, openErr := os.OpenFile(filepath.Join(fs.rootDir, "audit.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if openErr != nil {
+		err = fmt.Errorf("acmes: append audit failed, %v", openErr)
+		return
+	}
+	_, writeErr := file.Write(line)
+	closeErr := file.Close()
+	if writeErr != nil {
+		err = fmt.Errorf("acmes: append audit failed, %v", writeErr)
+		return
+	}
+	if closeErr != nil {
+		err = fmt.Errorf("acmes: append audit failed, %v", closeErr)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) ReadAudit(_ context.Context) (reader io.ReadCloser, has bool, err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	auditPath := filepath.Join(fs.rootDir, "audit.jsonl")
+	if !fs.pathExist(auditPath) {
+		return
+	}
+	file, openErr := os.Open(auditPath)
+	if openErr != nil {
+		err = fmt.Errorf("acmes: read audit failed, %v", openErr)
+		return
+	}
+	reader = file
+	has = true
+	return
+}
+
+func (fs *FileStore) SaveJob(_ context.Context, job *Job) (err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	jobsDir := filepath.Join(fs.rootDir, "jobs")
+	if !fs.pathExist(jobsDir) {
+		mkdirErr := os.MkdirAll(jobsDir, 0700)
+		if mkdirErr != nil {
+			err = fmt.Errorf("acmes: save job failed for create jobs dir failed, %v", mkdirErr)
+			return
+		}
+	}
+	content, encodeErr := json.Marshal(job)
+	if encodeErr != nil {
+		err = fmt.Errorf("acmes: save job failed, %v", encodeErr)
+		return
+	}
+	// write into a temp file then rename it, so that a job is never read half written.
+	id, keyErr := jobKey(job.Id)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: save job failed, %v", keyErr)
+		return
+	}
+	jobPath := filepath.Join(jobsDir, fmt.Sprintf("%s.json", id))
+	tmpPath := jobPath + ".tmp"
+	writeErr := os.WriteFile(tmpPath, content, 0600)
+	if writeErr != nil {
+		err = fmt.Errorf("acmes: save job failed, %v", writeErr)
+		return
+	}
+	renameErr := os.Rename(tmpPath, jobPath)
+	if renameErr != nil {
+		err = fmt.Errorf("acmes: save job failed, %v", renameErr)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) GetJob(_ context.Context, id string) (job *Job, has bool, err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	id, keyErr := jobKey(strings.TrimSpace(id))
+	if keyErr != nil {
+		return
+	}
+	jobPath := filepath.Join(fs.rootDir, "jobs", fmt.Sprintf("%s.json", id))
+	if !fs.pathExist(jobPath) {
+		return
+	}
+	job, err = fs.readJob(jobPath)
+	if err != nil {
+		err = fmt.Errorf("acmes: get job failed, %v", err)
+		return
+	}
+	has = true
+	return
+}
+
+func (fs *FileStore) ListJobs(_ context.Context) (jobs []*Job, err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	jobsDir := filepath.Join(fs.rootDir, "jobs")
+	if !fs.pathExist(jobsDir) {
+		return
+	}
+	entries, readDirErr := os.ReadDir(jobsDir)
+	if readDirErr != nil {
+		err = fmt.Errorf("acmes: list jobs failed, %v", readDirErr)
+		return
+	}
+	jobs = make([]*Job, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		job, readErr := fs.readJob(filepath.Join(jobsDir, entry.Name()))
+		if readErr != nil {
+			err = fmt.Errorf("acmes: list jobs failed, %v", readErr)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+	return
+}
+
+func (fs *FileStore) RemoveJob(_ context.Context, id string) (err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	id, keyErr := jobKey(strings.TrimSpace(id))
+	if keyErr != nil {
+		return
+	}
+	removeErr := os.Remove(filepath.Join(fs.rootDir, "jobs", fmt.Sprintf("%s.json", id)))
+	if removeErr != nil && !os.IsNotExist(removeErr) {
+		err = fmt.Errorf("acmes: remove job failed, %v", removeErr)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) readJob(path string) (job *Job, err error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	job = &Job{}
+	err = json.Unmarshal(content, job)
+	return
+}
+
+func (fs *FileStore) GetChallengeAccount(_ context.Context, provider string, domain string) (account *ChallengeAccount, has bool, err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	accountPath, keyErr := fs.challengeAccountPath(provider, domain)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: get challenge account failed, %v", keyErr)
+		return
+	}
+	if !fs.pathExist(accountPath) {
+		return
+	}
+	account, err = fs.readChallengeAccount(accountPath)
+	if err != nil {
+		err = fmt.Errorf("acmes: get challenge account failed, %v", err)
+		return
+	}
+	has = true
+	return
+}
+
+func (fs *FileStore) SaveChallengeAccount(_ context.Context, account *ChallengeAccount) (err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	accountPath, keyErr := fs.challengeAccountPath(account.Provider, account.Domain)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: save challenge account failed, %v", keyErr)
+		return
+	}
+	accountsDir := filepath.Dir(accountPath)
+	if !fs.pathExist(accountsDir) {
+		mkdirErr := os.MkdirAll(accountsDir, 0700)
+		if mkdirErr != nil {
+			err = fmt.Errorf("acmes: save challenge account failed for create dir failed, %v", mkdirErr)
+			return
+		}
+	}
+	content, encodeErr := json.Marshal(account)
+	if encodeErr != nil {
+		err = fmt.Errorf("acmes: save challenge account failed, %v", encodeErr)
+		return
+	}
+	writeErr := os.WriteFile(accountPath, content, 0600)
+	if writeErr != nil {
+		err = fmt.Errorf("acmes: save challenge account failed, %v", writeErr)
+		return
+	}
+	return
+}
+
+func (fs *FileStore) ListChallengeAccounts(_ context.Context, provider string) (accounts []*ChallengeAccount, err error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	providerDir, keyErr := providerKey(provider)
+	if keyErr != nil {
+		err = fmt.Errorf("acmes: list challenge accounts failed, %v", keyErr)
+		return
+	}
+	accountsDir := filepath.Join(fs.rootDir, "challenges", providerDir)
+	if !fs.pathExist(accountsDir) {
+		return
+	}
+	entries, readDirErr := os.ReadDir(accountsDir)
+	if readDirErr != nil {
+		err = fmt.Errorf("acmes: list challenge accounts failed, %v", readDirErr)
+		return
+	}
+	accounts = make([]*ChallengeAccount, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		account, readErr := fs.readChallengeAccount(filepath.Join(accountsDir, entry.Name()))
+		if readErr != nil {
+			err = fmt.Errorf("acmes: list challenge accounts failed, %v", readErr)
+			return
+		}
+		accounts = append(accounts, account)
+	}
+	return
+}
+
+// challengeAccountPath returns the file of the challenge account, it is rootDir/challenges/{provider}/{domain}.json.
+func (fs *FileStore) challengeAccountPath(provider string, domain string) (path string, err error) {
+	providerDir, providerErr := providerKey(provider)
+	if providerErr != nil {
+		err = providerErr
+		return
+	}
+	domainName, domainErr := domainKey(domain)
+	if domainErr != nil {
+		err = domainErr
+		return
+	}
+	path = filepath.Join(fs.rootDir, "challenges", providerDir, fmt.Sprintf("%s.json", domainName))
+	return
+}
+
+func (fs *FileStore) readChallengeAccount(path string) (account *ChallengeAccount, err error) {
+	content, readErr := os.ReadFile(path)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	account = &ChallengeAccount{}
+	err = json.Unmarshal(content, account)
+	return
+}
+
+func (fs *FileStore) Lock(_ context.Context, shared bool) (unlock func(), err error) {
+	file, openErr := os.OpenFile(filepath.Join(fs.rootDir, "acmes.lock"), os.O_CREATE|os.O_RDWR, 0600)
+	if openErr != nil {
+		err = fmt.Errorf("acmes: lock store failed, %v", openErr)
+		return
+	}
+	lockErr := lockFile(file, shared)
+	if lockErr != nil {
+		_ = file.Close()
+		err = fmt.Errorf("acmes: lock store failed, it is held by another process, %v", lockErr)
+		return
+	}
+	unlock = func() {
+		// closing the file releases the lock
+		_ = file.Close()
+	}
+	return
+}
+
+func (fs *FileStore) Close(_ context.Context) (err error) {
+	// wait for the running operation
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return
+}
+
+// domainDir returns the dir of the certificate of domain, it is rootDir/{email}/{domain}.
+func (fs *FileStore) domainDir(email string, domain string) (dir string, err error) {
+	emailDir, emailErr := emailKey(email)
+	if emailErr != nil {
+		err = emailErr
+		return
+	}
+	domainName, domainErr := domainKey(domain)
+	if domainErr != nil {
+		err = domainErr
+		return
+	}
+	dir = filepath.Join(fs.rootDir, emailDir, domainName)
+	return
+}
+
 func (fs *FileStore) pathExist(v string) (ok bool) {
 	_, err := os.Stat(v)
 	if err == nil {
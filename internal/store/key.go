@@ -0,0 +1,95 @@
+package store
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"regexp"
+	"strings"
+)
+
+// Keys of the file store are names of dirs and files under the root dir, they are checked so that no key escapes it.
+
+var (
+	domainKeyPattern   = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9.-]*[a-z0-9])?$`)
+	jobKeyPattern      = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	providerKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	domainProfile      = idna.New(
+		idna.MapForLookup(),
+		idna.VerifyDNSLength(true),
+		idna.BidiRule(),
+		idna.Transitional(false),
+	)
+)
+
+// domainKey returns the dir name of domain, * of wildcard is replaced by [x].
+func domainKey(domain string) (key string, err error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		err = fmt.Errorf("domain is empty")
+		return
+	}
+	if !domainKeyPattern.MatchString(domain) || strings.Contains(domain, "..") {
+		err = fmt.Errorf("domain %q is not a valid store key", domain)
+		return
+	}
+	key = strings.ReplaceAll(domain, "*", "[x]")
+	return
+}
+
+// NormalizeDomain returns domain the way acmes serve keeps it, the lower case punycode without the trailing dot,
+// so that tools which read the store or match events find the certificate of a domain written in unicode or upper case.
+func NormalizeDomain(domain string) (v string, err error) {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	wildcard := strings.HasPrefix(domain, "*.")
+	if wildcard {
+		domain = domain[2:]
+	}
+	v, err = domainProfile.ToASCII(domain)
+	if err != nil {
+		err = fmt.Errorf("domain %q is invalid, %v", domain, err)
+		return
+	}
+	if v == "" {
+		err = fmt.Errorf("domain is empty")
+		return
+	}
+	if wildcard {
+		v = "*." + v
+	}
+	return
+}
+
+// emailKey returns the dir name of email.
+func emailKey(email string) (key string, err error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		err = fmt.Errorf("email is empty")
+		return
+	}
+	if strings.ContainsAny(email, "/\\\x00") || strings.HasPrefix(email, ".") {
+		err = fmt.Errorf("email %q is not a valid store key", email)
+		return
+	}
+	key = email
+	return
+}
+
+func jobKey(id string) (key string, err error) {
+	if !jobKeyPattern.MatchString(id) {
+		err = fmt.Errorf("job id %q is not a valid store key", id)
+		return
+	}
+	key = id
+	return
+}
+
+// providerKey returns the dir name of a dns provider, such as acme-dns.
+func providerKey(provider string) (key string, err error) {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	if !providerKeyPattern.MatchString(provider) {
+		err = fmt.Errorf("provider %q is not a valid store key", provider)
+		return
+	}
+	key = provider
+	return
+}
@@ -0,0 +1,96 @@
+package store
+
+import "testing"
+
+func TestDomainKey(t *testing.T) {
+	cases := []struct {
+		domain string
+		want   string
+	}{
+		{"www.foo.com", "www.foo.com"},
+		{" WWW.Foo.com", "www.foo.com"},
+		{"*.foo.com", "[x].foo.com"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"", ""},
+		{"..", ""},
+		{"../x", ""},
+		{"foo..com", ""},
+		{".foo.com", ""},
+		{"foo.com.", ""},
+		{"a/b", ""},
+		{"a\\b", ""},
+		{"foo.*.com", ""},
+		{"*foo.com", ""},
+		{"-foo.com", ""},
+		{"foo.com\x00", ""},
+	}
+	for _, c := range cases {
+		got, err := domainKey(c.domain)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%q is the key %q", c.domain, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("key of %q is %q, want %q, %v", c.domain, got, c.want, err)
+		}
+	}
+}
+
+func TestEmailKey(t *testing.T) {
+	cases := []struct {
+		email string
+		want  string
+	}{
+		{"foo@bar.com", "foo@bar.com"},
+		{" foo.bar@bar.com ", "foo.bar@bar.com"},
+		{"", ""},
+		{"..", ""},
+		{"../foo@bar.com", ""},
+		{".foo@bar.com", ""},
+		{"foo/bar@bar.com", ""},
+		{"foo\\bar@bar.com", ""},
+		{"foo\x00@bar.com", ""},
+	}
+	for _, c := range cases {
+		got, err := emailKey(c.email)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%q is the key %q", c.email, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("key of %q is %q, want %q, %v", c.email, got, c.want, err)
+		}
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	cases := []struct {
+		domain string
+		want   string
+	}{
+		{"www.foo.com", "www.foo.com"},
+		{" WWW.Foo.com. ", "www.foo.com"},
+		{"*.Foo.com", "*.foo.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"*.BÜCHER.example.", "*.xn--bcher-kva.example"},
+		{"", ""},
+		{".", ""},
+		{"foo..com", ""},
+	}
+	for _, c := range cases {
+		got, err := NormalizeDomain(c.domain)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("%q is normalized to %q", c.domain, got)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%q is normalized to %q, want %q, %v", c.domain, got, c.want, err)
+		}
+	}
+}
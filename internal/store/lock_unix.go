@@ -0,0 +1,18 @@
+//go:build unix
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks file without waiting, shared locks can be held by many processes at once.
+func lockFile(file *os.File, shared bool) (err error) {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+	err = syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	return
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package store
+
+import (
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile locks file without waiting, shared locks can be held by many processes at once.
+func lockFile(file *os.File, shared bool) (err error) {
+	flags := uint32(windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if !shared {
+		flags |= windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	err = windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, &windows.Overlapped{})
+	return
+}
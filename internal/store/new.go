@@ -0,0 +1,53 @@
+package store
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"runtime"
+)
+
+// New creates a store by url, such as file:///some_dir_path.
+func New(p string) (v Store, err error) {
+	u, urlErr := url.Parse(p)
+	if urlErr != nil {
+		err = fmt.Errorf("acmes: parse store url failed, %v", urlErr)
+		return
+	}
+	switch u.Scheme {
+	case "file":
+		dir, dirErr := FilePath(u)
+		if dirErr != nil {
+			err = fmt.Errorf("acmes: parse store url failed, %v", dirErr)
+			return
+		}
+		v, err = NewFileStore(dir)
+		break
+	case "oss":
+		err = fmt.Errorf("acmes: store schema is not support")
+		return
+	default:
+		err = fmt.Errorf("acmes: store schema is not support")
+		return
+	}
+	return
+}
+
+// FilePath returns the path of a file url, such as /some_dir_path of file:///some_dir_path,
+// the drive of a windows path is kept, such as C:\some_dir_path of file:///C:/some_dir_path.
+func FilePath(u *url.URL) (path string, err error) {
+	if u.Host != "" && u.Host != "localhost" {
+		err = fmt.Errorf("host %s of %s is not supported, use file:///some_path", u.Host, u.String())
+		return
+	}
+	path = u.Path
+	if path == "" {
+		err = fmt.Errorf("path of %s is required", u.String())
+		return
+	}
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	path = filepath.FromSlash(path)
+	return
+}
@@ -2,11 +2,15 @@ package store
 
 import (
 	"crypto"
-	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
+	"github.com/go-acme/lego/v4/certcrypto"
 	"github.com/go-acme/lego/v4/registration"
 	"golang.org/x/net/context"
+	"io"
+	"math/big"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -15,12 +19,33 @@ type Store interface {
 	SaveUser(ctx context.Context, user *User) (err error)
 	GetUserCertificate(ctx context.Context, email string, domain string) (cert *Certificate, has bool, err error)
 	SaveUserCertificate(ctx context.Context, email string, domain string, cert *Certificate) (err error)
+	SaveUserCertificateOCSP(ctx context.Context, email string, domain string, serial *big.Int, response []byte, nextUpdate time.Time) (err error)
+	RemoveUserCertificate(ctx context.Context, email string, domain string) (err error)
+	ListUserCertificates(ctx context.Context, email string) (domains []string, err error)
+	AppendAudit(ctx context.Context, line []byte) (err error)
+	ReadAudit(ctx context.Context) (reader io.ReadCloser, has bool, err error)
+	SaveJob(ctx context.Context, job *Job) (err error)
+	GetJob(ctx context.Context, id string) (job *Job, has bool, err error)
+	ListJobs(ctx context.Context) (jobs []*Job, err error)
+	RemoveJob(ctx context.Context, id string) (err error)
+	GetChallengeAccount(ctx context.Context, provider string, domain string) (account *ChallengeAccount, has bool, err error)
+	SaveChallengeAccount(ctx context.Context, account *ChallengeAccount) (err error)
+	ListChallengeAccounts(ctx context.Context, provider string) (accounts []*ChallengeAccount, err error)
+	// Lock holds the store until unlock, acmes serve holds a shared lock, changes of account keys need an exclusive one.
+	// It fails at once when the lock is held by another process in the other mode, or exclusively.
+	Lock(ctx context.Context, shared bool) (unlock func(), err error)
+	Close(ctx context.Context) (err error)
 }
 
 type User struct {
 	Email    string `json:"email"`
 	Resource []byte `json:"resource"`
 	Key      []byte `json:"key"`
+	// Directory is the directory url of the ca which the account is registered at.
+	Directory string `json:"directory,omitempty"`
+	// PendingKey is the new key of a roll over, it is kept until the ca accepted it and it replaced Key,
+	// so that the account is not lost when the roll over is interrupted.
+	PendingKey []byte `json:"pendingKey,omitempty"`
 }
 
 func (u *User) GetEmail() string {
@@ -36,9 +61,27 @@ func (u *User) GetRegistration() *registration.Resource {
 	return r
 }
 
+// RegisteredAt is true when the account is registered at the ca of directory,
+// users saved before the directory was kept are compared by the host of their account url.
+func (u *User) RegisteredAt(directory string) bool {
+	if u.Directory != "" {
+		return u.Directory == directory
+	}
+	reg := u.GetRegistration()
+	if reg == nil {
+		return false
+	}
+	accountURL, accountErr := url.Parse(reg.URI)
+	directoryURL, directoryErr := url.Parse(directory)
+	if accountErr != nil || directoryErr != nil {
+		return false
+	}
+	return strings.EqualFold(accountURL.Host, directoryURL.Host)
+}
+
+// GetPrivateKey returns the rsa or ecdsa key in pkcs1, sec1 or pkcs8 pem, such as a key of a recovered account.
 func (u *User) GetPrivateKey() crypto.PrivateKey {
-	block, _ := pem.Decode(u.Key)
-	key, parseKeyErr := x509.ParsePKCS1PrivateKey(block.Bytes)
+	key, parseKeyErr := certcrypto.ParsePEMPrivateKey(u.Key)
 	if parseKeyErr != nil {
 		return nil
 	}
@@ -46,8 +89,92 @@ func (u *User) GetPrivateKey() crypto.PrivateKey {
 }
 
 type Certificate struct {
-	Resource []byte    `json:"resource"`
-	Cert     []byte    `json:"cert"`
+	Resource []byte `json:"resource"`
+	// Cert is the full chain, it is kept for clients which read it before chains were separated.
+	Cert []byte `json:"cert"`
+	// Leaf is the certificate of the domain.
+	Leaf []byte `json:"leaf"`
+	// Intermediates are the issuers of Leaf in the chain, without the root.
+	Intermediates []byte `json:"intermediates"`
+	// Chain is Leaf followed by Intermediates.
+	Chain    []byte    `json:"chain"`
 	Key      []byte    `json:"key"`
 	NotAfter time.Time `json:"notAfter"`
+	// OCSP is the der ocsp response of Leaf to staple, it is kept beside the certificate and dropped when the certificate is saved again.
+	OCSP []byte `json:"ocsp,omitempty"`
+	// OCSPNextUpdate is when the ca publishes a newer OCSP, it is refreshed before then, it is zero when OCSP is empty.
+	OCSPNextUpdate time.Time `json:"ocspNextUpdate"`
+}
+
+// NewCertificate separates the leaf and intermediates of the full chain in pem.
+func NewCertificate(resource []byte, chain []byte, key []byte, notAfter time.Time) *Certificate {
+	leaf, intermediates := SplitChain(chain)
+	return &Certificate{
+		Resource:      resource,
+		Cert:          chain,
+		Leaf:          leaf,
+		Intermediates: intermediates,
+		Chain:         chain,
+		Key:           key,
+		NotAfter:      notAfter,
+	}
+}
+
+// SplitChain returns the first certificate of chain as leaf, and the rest as intermediates, both in pem.
+func SplitChain(chain []byte) (leaf []byte, intermediates []byte) {
+	rest := chain
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		encoded := pem.EncodeToMemory(block)
+		if leaf == nil {
+			leaf = encoded
+			continue
+		}
+		intermediates = append(intermediates, encoded...)
+	}
+}
+
+const (
+	JobPending    = "pending"
+	JobValidating = "validating"
+	JobIssued     = "issued"
+	JobFailed     = "failed"
+)
+
+// Job is an asynchronous order, it is kept so that it survives a restart.
+type Job struct {
+	Id      string `json:"id"`
+	Account string `json:"account,omitempty"`
+	Email   string `json:"email"`
+	Domain  string `json:"domain"`
+	// PreferredChain is the chain which the job orders, the one of the account is used when it is empty.
+	PreferredChain string `json:"preferredChain,omitempty"`
+	// MustStaple adds the ocsp must staple extension to the certificate of the job.
+	MustStaple   bool      `json:"mustStaple,omitempty"`
+	State        string    `json:"state"`
+	Cause        string    `json:"cause,omitempty"`
+	Code         string    `json:"code,omitempty"`
+	Client       string    `json:"client,omitempty"`
+	ClientSerial string    `json:"clientSerial,omitempty"`
+	RemoteAddr   string    `json:"remoteAddr,omitempty"`
+	CreateAT     time.Time `json:"createAt"`
+	UpdateAT     time.Time `json:"updateAt"`
+}
+
+func (job *Job) Finished() bool {
+	return job.State == JobIssued || job.State == JobFailed
+}
+
+// ChallengeAccount is an account of a dns challenge service such as acme-dns, it is registered per domain.
+type ChallengeAccount struct {
+	Provider string          `json:"provider"`
+	Domain   string          `json:"domain"`
+	Account  json.RawMessage `json:"account"`
 }
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/go-acme/lego/v4/registration"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestUser_RegisteredAt(t *testing.T) {
+	resource, _ := json.Marshal(&registration.Resource{URI: "https://acme-v02.api.letsencrypt.org/acme/acct/1"})
+	cases := []struct {
+		user      User
+		directory string
+		want      bool
+	}{
+		{User{Resource: resource, Directory: "https://acme-v02.api.letsencrypt.org/directory"}, "https://acme-v02.api.letsencrypt.org/directory", true},
+		{User{Resource: resource, Directory: "https://acme-v02.api.letsencrypt.org/directory"}, "https://acme.zerossl.com/v2/DV90", false},
+		// users saved before the directory was kept are compared by the host of the account url
+		{User{Resource: resource}, "https://acme-v02.api.letsencrypt.org/directory", true},
+		{User{Resource: resource}, "https://acme-staging-v02.api.letsencrypt.org/directory", false},
+		{User{}, "https://acme-v02.api.letsencrypt.org/directory", false},
+	}
+	for _, c := range cases {
+		if got := c.user.RegisteredAt(c.directory); got != c.want {
+			t.Errorf("user at %q registered at %s is %v, want %v", c.user.Directory, c.directory, got, c.want)
+		}
+	}
+}
+
+func TestFilePath(t *testing.T) {
+	cases := []struct {
+		url  string
+		want string
+	}{
+		{"file:///some_path/store", "/some_path/store"},
+		{"file://localhost/some_path/audit.jsonl", "/some_path/audit.jsonl"},
+		{"file://some_path/store", ""},
+		{"file://", ""},
+	}
+	for _, c := range cases {
+		u, _ := url.Parse(c.url)
+		got, err := FilePath(u)
+		if c.want == "" {
+			if err == nil {
+				t.Errorf("path of %s is %s", c.url, got)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(c.want) {
+			t.Errorf("path of %s is %s, want %s, %v", c.url, got, c.want, err)
+		}
+	}
+}